@@ -0,0 +1,99 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// KfClusterConditionType names a typed condition of a KfCluster
+type KfClusterConditionType string
+
+// Typed conditions reported on a KfCluster
+const (
+	// ProvisionerAvailable reports the availability of the provisioner Deployment, with the reason it gives
+	ProvisionerAvailable KfClusterConditionType = "ProvisionerAvailable"
+	// VolumeBound reports whether the provisioner volume claim is bound
+	VolumeBound KfClusterConditionType = "VolumeBound"
+	// SecretsReady reports whether every Secret injected into the provisioner exists
+	SecretsReady KfClusterConditionType = "SecretsReady"
+	// InfrastructureReady summarizes the state of the cluster as reported by its platform provider
+	InfrastructureReady KfClusterConditionType = "InfrastructureReady"
+	// Adopted reports whether an existing cluster was found and taken over
+	Adopted KfClusterConditionType = "Adopted"
+	// KubeflowUpgraded reports whether the installed Kubeflow runs spec.kf_version
+	KubeflowUpgraded KfClusterConditionType = "KubeflowUpgraded"
+	// KubernetesUpgraded reports whether the cluster runs the Kubernetes version of the spec
+	KubernetesUpgraded KfClusterConditionType = "KubernetesUpgraded"
+	// TeardownFailed is True once the controller gave up deleting the infrastructure of a KfCluster being deleted
+	TeardownFailed KfClusterConditionType = "TeardownFailed"
+)
+
+// StageCondition returns the condition type reporting on a provisioning stage
+func StageCondition(stage string) KfClusterConditionType {
+	return KfClusterConditionType(stage + "Ready")
+}
+
+// GetCondition returns the typed condition of the given type, or nil if it isn't set
+func (s *KfClusterStatus) GetCondition(conditionType KfClusterConditionType) *KfClusterCondition {
+	for i := range s.Conditions {
+		if s.Conditions[i].Type == conditionType {
+			return &s.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// SetCondition sets the typed condition of the given type and reports whether the status changed.
+// LastTransitionTime is only moved when the condition status flips.
+func (s *KfClusterStatus) SetCondition(conditionType KfClusterConditionType, status corev1.ConditionStatus, reason, message string) bool {
+	existing := s.GetCondition(conditionType)
+	if existing == nil {
+		s.Conditions = append(s.Conditions, KfClusterCondition{
+			Type:               conditionType,
+			Status:             status,
+			Reason:             reason,
+			Message:            message,
+			LastTransitionTime: metav1.Now(),
+		})
+		return true
+	}
+	if existing.Status == status && existing.Reason == reason && existing.Message == message {
+		return false
+	}
+	if existing.Status != status {
+		existing.LastTransitionTime = metav1.Now()
+	}
+	existing.Status = status
+	existing.Reason = reason
+	existing.Message = message
+	return true
+}
+
+// RemoveUntypedConditions drops the conditions without a type left over from the provisioner state history
+// of earlier versions, whose state and ready fields no longer decode. It reports whether the status changed.
+func (s *KfClusterStatus) RemoveUntypedConditions() bool {
+	conditions := s.Conditions[:0]
+	for _, condition := range s.Conditions {
+		if condition.Type != "" {
+			conditions = append(conditions, condition)
+		}
+	}
+	removed := len(conditions) != len(s.Conditions)
+	s.Conditions = conditions
+	return removed
+}
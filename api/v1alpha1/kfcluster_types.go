@@ -16,7 +16,8 @@ limitations under the License.
 package v1alpha1
 
 import (
-	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -30,30 +31,412 @@ type KfPlatform string
 const (
 	KfClusterFinalizer            = "kfcluster.kubeflow.org"
 	KfGcp              KfPlatform = "gcp"
+	KfGke              KfPlatform = "gke"
+	KfKind             KfPlatform = "kind"
+	KfMetal            KfPlatform = "metal"
 	KfGeneric          KfPlatform = "generic"
+	// LatestKfVersion installs the newest Kubeflow release; it never triggers upgrades
+	LatestKfVersion = "latest"
+	// AdoptAnnotation set to "true" adopts an existing cluster, like spec.adopt does
+	AdoptAnnotation = "kfcluster.kubeflow.org/adopt"
+	// RollbackAnnotation requests a rollback of the latest Kubeflow upgrade; the controller removes it once handled
+	RollbackAnnotation = "kfcluster.kubeflow.org/rollback"
+	// RetryAnnotation requests another attempt at a Failed KfCluster, or at a teardown that failed;
+	// the controller removes it once handled
+	RetryAnnotation = "kfcluster.kubeflow.org/retry"
+	// OrphanAnnotation set to "true" on a KfCluster being deleted releases it without deleting its infrastructure,
+	// which is then left for the user to clean up
+	OrphanAnnotation = "kfcluster.kubeflow.org/orphan-infrastructure"
 )
 
 // KfClusterSpec defines the desired state of KfCluster
 type KfClusterSpec struct {
 	// Important: Run "make" to regenerate code after modifying this file
-	Platform      KfPlatform `json:"platform,omitempty"`
-	KfVersion     string     `json:"kf_version,omitempty"`
-	ConfigMapName string     `json:"config_map_name,omitempty"`
-	Apps          []string   `json:"apps,omitempty"`
-	Secrets       []string   `json:"secrets,omitempty"`
+	Platform KfPlatform `json:"platform,omitempty"`
+	// KfVersion is the Kubeflow release the provisioner installs; changing it upgrades Kubeflow.
+	// Platforms the controller provisions itself don't install Kubeflow and reject it; see InstallsKubeflow.
+	KfVersion string `json:"kf_version,omitempty"`
+	// KubernetesVersion is the Kubernetes version of the cluster, such as 1.15.3; empty selects the platform default.
+	// Changing it upgrades the cluster node by node. On GKE, spec.gke.version takes precedence.
+	KubernetesVersion string `json:"kubernetes_version,omitempty"`
+	// ConfigMapName names a ConfigMap whose keys are exposed to the provisioner as env vars.
+	// A key set in the ConfigMap overrides the value derived from the typed platform settings.
+	ConfigMapName string `json:"config_map_name,omitempty"`
+	// Apps are Kubeflow applications by their KfDef name; they must exist in the release of kf_version.
+	// "kf-clusterctl versions" lists the applications of every release. Like kf_version, they are rejected
+	// on platforms that don't install Kubeflow.
+	Apps []string `json:"apps,omitempty"`
+	// Secrets names Secrets that are mounted whole at /etc/<secret> in the provisioner
+	Secrets []string `json:"secrets,omitempty"`
+	// SecretRefs maps individual Secret keys to env vars or files in the provisioner
+	SecretRefs []SecretRef `json:"secret_refs,omitempty"`
+	// GCP holds the settings used when Platform is "gcp"
+	GCP *GCPSpec `json:"gcp,omitempty"`
+	// GKE holds the settings used when Platform is "gke"
+	GKE *GKESpec `json:"gke,omitempty"`
+	// Kind holds the settings used when Platform is "kind"
+	Kind *KindSpec `json:"kind,omitempty"`
+	// Metal holds the settings used when Platform is "metal"
+	Metal *MetalSpec `json:"metal,omitempty"`
+	// Generic holds the settings used when Platform is "generic"
+	Generic *GenericSpec `json:"generic,omitempty"`
+	// External holds the settings used when Platform names an out-of-tree provider
+	External *ExternalSpec `json:"external,omitempty"`
+	// Adopt takes over an existing cluster instead of provisioning one
+	Adopt *AdoptSpec `json:"adopt,omitempty"`
+	// Provisioner overrides the controller-wide settings of the provisioner pod
+	Provisioner *ProvisionerSpec `json:"provisioner,omitempty"`
+	// Storage overrides the volume the provisioner keeps the kubeconfig and Kubeflow app in
+	Storage *StorageSpec `json:"storage,omitempty"`
+}
+
+// StorageSpec defines the volume claim of the provisioner
+type StorageSpec struct {
+	// StorageClassName defaults to the default StorageClass of the cluster
+	StorageClassName string `json:"storage_class_name,omitempty"`
+	// Size defaults to 10Gi
+	Size *resource.Quantity `json:"size,omitempty"`
+	// AccessMode defaults to ReadWriteMany, or ReadWriteOnce when the StorageClass is unknown or doesn't support it
+	// +kubebuilder:validation:Enum=ReadWriteOnce;ReadWriteMany
+	AccessMode corev1.PersistentVolumeAccessMode `json:"access_mode,omitempty"`
+}
+
+// ProvisionerSpec defines how the provisioner pod of a KfCluster is run
+type ProvisionerSpec struct {
+	Image string `json:"image,omitempty"`
+	// +kubebuilder:validation:Enum=Always;Never;IfNotPresent
+	ImagePullPolicy    corev1.PullPolicy             `json:"image_pull_policy,omitempty"`
+	ImagePullSecrets   []corev1.LocalObjectReference `json:"image_pull_secrets,omitempty"`
+	Resources          *corev1.ResourceRequirements  `json:"resources,omitempty"`
+	NodeSelector       map[string]string             `json:"node_selector,omitempty"`
+	Tolerations        []corev1.Toleration           `json:"tolerations,omitempty"`
+	ServiceAccountName string                        `json:"service_account_name,omitempty"`
+}
+
+// SecretRef selects keys of a Secret to inject into the provisioner pod
+type SecretRef struct {
+	Name string `json:"name"`
+	// Env exposes secret keys as env vars
+	Env []SecretEnvVar `json:"env,omitempty"`
+	// Files projects secret keys as files under /etc/kfcluster/secrets
+	Files []SecretFile `json:"files,omitempty"`
+}
+
+// SecretEnvVar exposes a Secret key as an env var
+type SecretEnvVar struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+}
+
+// SecretFile projects a Secret key as a file
+type SecretFile struct {
+	Key string `json:"key"`
+	// Path is relative to /etc/kfcluster/secrets
+	Path string `json:"path"`
+	// Mode defaults to 0440
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=511
+	Mode *int32 `json:"mode,omitempty"`
+}
+
+// GCPSpec defines the GCP settings for provisioning a KfCluster with kops
+type GCPSpec struct {
+	Project string `json:"project,omitempty"`
+	Zone    string `json:"zone,omitempty"`
+	Region  string `json:"region,omitempty"`
+	Network string `json:"network,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	NodeCount   int32  `json:"node_count,omitempty"`
+	MachineType string `json:"machine_type,omitempty"`
+	// KopsStateStore is the GCS bucket URL kops keeps the cluster state in, e.g. gs://my-kops-state
+	KopsStateStore string `json:"kops_state_store,omitempty"`
+	// Auth selects how the provisioner authenticates to GCP
+	Auth *GCPAuthSpec `json:"auth,omitempty"`
+	// Bootstrap selects how the cluster is provisioned; defaults to kops
+	// +kubebuilder:validation:Enum=kops;kubeadm
+	Bootstrap GCPBootstrap `json:"bootstrap,omitempty"`
+	// APISourceRanges are the CIDR ranges allowed to reach the API server and SSH of kubeadm clusters.
+	// They default to the external IP of the instance the controller runs on, which must reach the API server.
+	APISourceRanges []string `json:"api_source_ranges,omitempty"`
+	// NodeServiceAccount is the email of the GCP service account the instances of kubeadm clusters run as;
+	// it defaults to the Compute Engine default service account. The instances only get the logging, monitoring
+	// and read-only storage scopes, and their pods can't reach the metadata server, so workloads can't act as it.
+	NodeServiceAccount string `json:"node_service_account,omitempty"`
+}
+
+// GCPBootstrap selects how a cluster is provisioned on GCP
+type GCPBootstrap string
+
+// Supported GCP bootstrap methods
+const (
+	// GCPBootstrapKops runs kops from the provisioner pod
+	GCPBootstrapKops GCPBootstrap = "kops"
+	// GCPBootstrapKubeadm has the controller create GCE instances that run kubeadm from their startup scripts
+	GCPBootstrapKubeadm GCPBootstrap = "kubeadm"
+)
+
+// GCPAuthMode selects how the provisioner authenticates to GCP
+type GCPAuthMode string
+
+// Supported GCP authentication modes, in order of preference
+const (
+	GCPAuthWorkloadIdentity GCPAuthMode = "WorkloadIdentity"
+	GCPAuthImpersonation    GCPAuthMode = "Impersonation"
+	GCPAuthKeyFile          GCPAuthMode = "KeyFile"
+)
+
+// GCPAuthSpec defines the GCP credentials of the provisioner
+type GCPAuthSpec struct {
+	// Mode defaults to WorkloadIdentity
+	// +kubebuilder:validation:Enum=WorkloadIdentity;Impersonation;KeyFile
+	Mode GCPAuthMode `json:"mode,omitempty"`
+	// ServiceAccount is the email of the GCP service account the provisioner acts as.
+	// With WorkloadIdentity it is bound to the provisioner's Kubernetes service account;
+	// with Impersonation short-lived tokens are minted for it. Impersonation is only supported
+	// where the controller calls GCP itself, by GKE and the kubeadm bootstrap: kops and kfctl
+	// authenticate with Application Default Credentials. The controller administrator allows the service
+	// accounts of each namespace with --gcp-service-accounts.
+	ServiceAccount string `json:"service_account,omitempty"`
+	// KeySecretRef selects a Secret key holding a service account key file, used by the KeyFile mode
+	KeySecretRef *corev1.SecretKeySelector `json:"key_secret_ref,omitempty"`
+}
+
+// GKESpec defines the settings for provisioning a KfCluster on GKE
+type GKESpec struct {
+	Project string `json:"project,omitempty"`
+	// Location is the zone of a zonal cluster or the region of a regional one
+	Location string `json:"location,omitempty"`
+	// Network defaults to the default network of the project
+	Network string `json:"network,omitempty"`
+	// Version is the Kubernetes version of the master and node pools, e.g. 1.15 or 1.15.4-gke.22.
+	// It defaults to the GKE default version; raising it upgrades the master, then the node pools.
+	Version string `json:"version,omitempty"`
+	// NodePools defaults to a single pool of 2 n1-standard-4 nodes.
+	// Pools missing from the list are deleted from the cluster.
+	NodePools []NodePoolSpec `json:"node_pools,omitempty"`
+	// NodeServiceAccount is the email of the GCP service account the nodes run as; it defaults to the
+	// Compute Engine default service account. The nodes only get the logging, monitoring and read-only storage
+	// scopes, but pods can reach the metadata server and act as it within those scopes.
+	// Existing node pools keep the service account they were created with.
+	NodeServiceAccount string `json:"node_service_account,omitempty"`
+	// Auth selects the GCP credentials of the controller; without it the controller's own are used.
+	// The published kubeconfig authenticates with them too, through an access token the controller renews.
+	Auth *GCPAuthSpec `json:"auth,omitempty"`
+}
+
+// NodePoolSpec defines a GKE node pool
+type NodePoolSpec struct {
+	Name        string `json:"name"`
+	MachineType string `json:"machine_type,omitempty"`
+	// NodeCount is the number of nodes per zone of the cluster; ignored when autoscaling
+	// +kubebuilder:validation:Minimum=0
+	NodeCount int32 `json:"node_count,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	DiskSizeGb int64 `json:"disk_size_gb,omitempty"`
+	// MinNodeCount and MaxNodeCount enable autoscaling when MaxNodeCount is set
+	// +kubebuilder:validation:Minimum=0
+	MinNodeCount int32 `json:"min_node_count,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	MaxNodeCount int32             `json:"max_node_count,omitempty"`
+	Preemptible  bool              `json:"preemptible,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+}
+
+// KindSpec defines the settings for provisioning a local KfCluster with kind (Kubernetes in Docker).
+// The kind nodes run in a Docker-in-Docker sidecar of the provisioner pod, which needs to be privileged.
+type KindSpec struct {
+	// NodeImage is the kindest/node image, which selects the Kubernetes version; defaults to the image of
+	// spec.kubernetes_version, or the kind default
+	NodeImage string `json:"node_image,omitempty"`
+	// Workers is the number of worker nodes next to the control plane node
+	// +kubebuilder:validation:Minimum=0
+	Workers int32 `json:"workers,omitempty"`
+	// DindImage is the Docker-in-Docker image of the sidecar; defaults to docker:19.03-dind
+	DindImage string `json:"dind_image,omitempty"`
+}
+
+// MetalSpec defines the settings for bootstrapping a KfCluster with kubeadm on pre-provisioned hosts over SSH
+type MetalSpec struct {
+	// Hosts are the SSH addresses of the hosts, as host or host:port; the first one becomes the control plane
+	// +kubebuilder:validation:MinItems=1
+	Hosts []string `json:"hosts"`
+	// User is the SSH user, which needs passwordless sudo unless it is root; defaults to root
+	User string `json:"user,omitempty"`
+	// SSHKeySecretRef selects the Secret key holding the SSH private key
+	SSHKeySecretRef *corev1.SecretKeySelector `json:"ssh_key_secret_ref"`
+	// KnownHostsSecretRef selects the Secret key holding known_hosts lines the host keys are verified against.
+	// It is required unless InsecureSkipHostKeyVerification is set.
+	KnownHostsSecretRef *corev1.SecretKeySelector `json:"known_hosts_secret_ref,omitempty"`
+	// InsecureSkipHostKeyVerification connects to the hosts without verifying their keys, for test hosts only:
+	// the controller sends the cluster CA key to the hosts and runs scripts on them as root.
+	InsecureSkipHostKeyVerification bool `json:"insecure_skip_host_key_verification,omitempty"`
+	// ControlPlaneEndpoint is the address the nodes and the kubeconfig reach the API server at;
+	// defaults to the host of the first address
+	ControlPlaneEndpoint string `json:"control_plane_endpoint,omitempty"`
+	// SkipInstall leaves out installing Docker and kubeadm, for hosts that come with them
+	SkipInstall bool `json:"skip_install,omitempty"`
+	// IgnorePreflightErrors lists kubeadm preflight checks to ignore, e.g. Swap when the hosts are containers
+	IgnorePreflightErrors []string `json:"ignore_preflight_errors,omitempty"`
+}
+
+// GenericSpec defines the settings for installing Kubeflow on an existing cluster
+type GenericSpec struct {
+	// KubeconfigSecretRef selects the Secret key holding the kubeconfig of the target cluster
+	KubeconfigSecretRef *corev1.SecretKeySelector `json:"kubeconfig_secret_ref,omitempty"`
+}
+
+// AdoptSpec defines how an existing cluster is found.
+// Adopted clusters are neither created nor deleted by the controller, and Kubeflow is not installed again.
+type AdoptSpec struct {
+	// KubeconfigSecretRef selects the Secret key holding the kubeconfig of the cluster.
+	// Without it, a kops cluster is discovered in the state store of spec.gcp on platform "gcp".
+	KubeconfigSecretRef *corev1.SecretKeySelector `json:"kubeconfig_secret_ref,omitempty"`
+	// ClusterName is the name of the kops cluster; defaults to the name of the KfCluster
+	ClusterName string `json:"cluster_name,omitempty"`
+}
+
+// ExternalSpec defines the settings passed to an out-of-tree provider
+type ExternalSpec struct {
+	// Parameters are passed to the provider as they are; their meaning is up to the provider
+	Parameters map[string]string `json:"parameters,omitempty"`
+	// SecretRefs select Secret keys whose values are passed to the provider, keyed by the name of the Secret key
+	SecretRefs []corev1.SecretKeySelector `json:"secret_refs,omitempty"`
 }
 
 // KfClusterStatus defines the observed state of KfCluster
 type KfClusterStatus struct {
+	// Phase summarizes the state of the KfCluster
+	Phase          KfClusterPhase       `json:"phase,omitempty"`
 	Conditions     []KfClusterCondition `json:"conditions,omitempty"`
 	KubeconfigPath string               `json:"kubeconfig_path,omitempty"`
+	// ProvisionedTime is when the infrastructure first became ready
+	ProvisionedTime *metav1.Time `json:"provisioned_time,omitempty"`
+	// KubeconfigSecret names the Secret holding the kubeconfig of clusters the controller provisions itself
+	KubeconfigSecret string `json:"kubeconfig_secret,omitempty"`
+	// InstalledVersion is the Kubeflow version found on the cluster
+	InstalledVersion string `json:"installed_version,omitempty"`
+	// InstalledApps are the Kubeflow applications found on the cluster
+	InstalledApps []string `json:"installed_apps,omitempty"`
+	// Upgrade records the progress of the latest Kubeflow upgrade
+	Upgrade *KubeflowUpgradeStatus `json:"upgrade,omitempty"`
+	// UpgradeHistory records the finished Kubeflow upgrades and rollbacks, oldest first
+	UpgradeHistory []KubeflowUpgradeStatus `json:"upgrade_history,omitempty"`
+	// KubernetesVersion is the Kubernetes version the cluster was last provisioned or upgraded to
+	KubernetesVersion string `json:"kubernetes_version,omitempty"`
+	// KubernetesUpgrade records the progress of the latest Kubernetes upgrade
+	KubernetesUpgrade *KubernetesUpgradeStatus `json:"kubernetes_upgrade,omitempty"`
+	// RetryCount counts the failed attempts at reconciling the KfCluster since the last one that succeeded
+	RetryCount int `json:"retry_count,omitempty"`
+	// LastError is the error of the latest failed attempt
+	LastError string `json:"last_error,omitempty"`
+	// FailedSpecHash is the hash of the spec a Failed KfCluster failed on; changing the spec retries the KfCluster
+	FailedSpecHash string `json:"failed_spec_hash,omitempty"`
+}
+
+// KfClusterPhase summarizes the state of a KfCluster
+type KfClusterPhase string
+
+// Phases of a KfCluster
+const (
+	PhaseProvisioning KfClusterPhase = "Provisioning"
+	PhaseUpgrading    KfClusterPhase = "Upgrading"
+	PhaseReady        KfClusterPhase = "Ready"
+	PhaseDeleting     KfClusterPhase = "Deleting"
+	// PhaseFailed stops the reconciling of a KfCluster until its spec changes or a retry is requested
+	PhaseFailed KfClusterPhase = "Failed"
+)
+
+// KubernetesUpgradePhase is the state of a Kubernetes upgrade
+type KubernetesUpgradePhase string
+
+// Phases of a Kubernetes upgrade
+const (
+	KubernetesUpgradeInProgress KubernetesUpgradePhase = "InProgress"
+	KubernetesUpgradeSucceeded  KubernetesUpgradePhase = "Succeeded"
+)
+
+// KubernetesUpgradeStatus records the progress of a rolling Kubernetes upgrade
+type KubernetesUpgradeStatus struct {
+	FromVersion string                 `json:"from_version,omitempty"`
+	ToVersion   string                 `json:"to_version,omitempty"`
+	Phase       KubernetesUpgradePhase `json:"phase,omitempty"`
+	// Message reports the last error of an upgrade in progress; the upgrade is retried
+	Message        string       `json:"message,omitempty"`
+	StartTime      *metav1.Time `json:"start_time,omitempty"`
+	CompletionTime *metav1.Time `json:"completion_time,omitempty"`
 }
 
-// KfClusterCondition defines the possible states for the KfCluster
+// KubeflowUpgradePhase is a step of a Kubeflow upgrade, run in the order declared
+type KubeflowUpgradePhase string
+
+// Phases of a Kubeflow upgrade
+const (
+	UpgradePreCheck    KubeflowUpgradePhase = "PreCheck"
+	UpgradeBackup      KubeflowUpgradePhase = "Backup"
+	UpgradeApply       KubeflowUpgradePhase = "Apply"
+	UpgradeHealthCheck KubeflowUpgradePhase = "HealthCheck"
+	UpgradeSucceeded   KubeflowUpgradePhase = "Succeeded"
+	UpgradeFailed      KubeflowUpgradePhase = "Failed"
+	// UpgradeRollback restores the backup of an upgrade, after a phase following the backup failed or on request
+	UpgradeRollback   KubeflowUpgradePhase = "Rollback"
+	UpgradeRolledBack KubeflowUpgradePhase = "RolledBack"
+)
+
+// KubeflowUpgradeStatus records the progress of a Kubeflow upgrade
+type KubeflowUpgradeStatus struct {
+	FromVersion string               `json:"from_version,omitempty"`
+	ToVersion   string               `json:"to_version,omitempty"`
+	Phase       KubeflowUpgradePhase `json:"phase,omitempty"`
+	Message     string               `json:"message,omitempty"`
+	// BackupPath is where the KfDef, kustomize directory and installed manifests were backed up on the provisioner volume
+	BackupPath     string       `json:"backup_path,omitempty"`
+	StartTime      *metav1.Time `json:"start_time,omitempty"`
+	CompletionTime *metav1.Time `json:"completion_time,omitempty"`
+}
+
+// IsFinished reports whether the upgrade has succeeded, failed or been rolled back
+func (u *KubeflowUpgradeStatus) IsFinished() bool {
+	return u.Phase == UpgradeSucceeded || u.Phase == UpgradeFailed || u.Phase == UpgradeRolledBack
+}
+
+// CanRollBack reports whether the upgrade has finished with a backup to restore
+func (u *KubeflowUpgradeStatus) CanRollBack() bool {
+	return u.BackupPath != "" && (u.Phase == UpgradeSucceeded || u.Phase == UpgradeFailed)
+}
+
+// DesiredKubernetesVersion returns the Kubernetes version the spec asks for, or empty for the platform default
+func (r *KfCluster) DesiredKubernetesVersion() string {
+	if r.Spec.Platform == KfGke && r.Spec.GKE != nil && r.Spec.GKE.Version != "" {
+		return r.Spec.GKE.Version
+	}
+	return r.Spec.KubernetesVersion
+}
+
+// InstallsKubeflow reports whether the platform installs Kubeflow on the cluster. The controller provisions
+// gke, metal and kubeadm gcp clusters itself, in stages that stop at a running cluster.
+func (r *KfCluster) InstallsKubeflow() bool {
+	switch r.Spec.Platform {
+	case KfGke, KfMetal:
+		return false
+	case KfGcp:
+		return r.Spec.GCP == nil || r.Spec.GCP.Bootstrap != GCPBootstrapKubeadm
+	}
+	return true
+}
+
+// IsAdopted reports whether the KfCluster takes over an existing cluster
+func (r *KfCluster) IsAdopted() bool {
+	return r.Spec.Adopt != nil || r.Annotations[AdoptAnnotation] == "true"
+}
+
+// KfClusterCondition reports one aspect of the state of the KfCluster
 type KfClusterCondition struct {
-	// Important: Run "make" to regenerate code after modifying this file
-	State *appsv1.DeploymentConditionType `json:"state,omitempty"`
-	Ready bool                            `json:"ready,omitempty"`
+	// Type is unique among the conditions of a KfCluster; see SetCondition
+	Type               KfClusterConditionType `json:"type,omitempty"`
+	Status             corev1.ConditionStatus `json:"status,omitempty"`
+	Reason             string                 `json:"reason,omitempty"`
+	Message            string                 `json:"message,omitempty"`
+	LastTransitionTime metav1.Time            `json:"last_transition_time,omitempty"`
 }
 
 // +kubebuilder:object:root=true
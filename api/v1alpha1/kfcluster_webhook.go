@@ -17,8 +17,16 @@ package v1alpha1
 
 import (
 	"fmt"
+	"net"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
 
+	"github.com/CiscoAI/kf-cluster-api/pkg/kubeflow/compatibility"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/version"
 	ctrl "sigs.k8s.io/controller-runtime"
 	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
@@ -41,7 +49,9 @@ var _ webhook.Defaulter = &KfCluster{}
 func (r *KfCluster) Default() {
 	kfclusterlog.Info("default", "name", r.Name)
 
-	// TODO(user): fill in your defaulting logic.
+	if r.Spec.Generic != nil && r.Spec.Generic.KubeconfigSecretRef != nil && r.Spec.Generic.KubeconfigSecretRef.Key == "" {
+		r.Spec.Generic.KubeconfigSecretRef.Key = "kubeconfig"
+	}
 }
 
 // TODO(user): change verbs to "verbs=create;update;delete" if you want to enable deletion validation.
@@ -53,19 +63,37 @@ var _ webhook.Validator = &KfCluster{}
 // Validation loop should check if the cluster has the neccesary resources for fulfilling a Kuebflow installation
 func (r *KfCluster) ValidateCreate() error {
 	kfclusterlog.Info("validate create", "name", r.Name)
-	if r.Spec.Platform == "gcp" || r.Spec.Platform == "generic" {
-		return nil
+	if r.Spec.Platform == "gcp" || r.Spec.Platform == "gke" || r.Spec.Platform == "kind" || r.Spec.Platform == "metal" || r.Spec.Platform == "generic" || IsExternalPlatform(r.Spec.Platform) {
+		if err := r.validateSpec(); err != nil {
+			return err
+		}
+		if err := r.validateKubeflowSpec(nil); err != nil {
+			return err
+		}
+		return r.validateGCPServiceAccounts(nil)
 	}
-	return fmt.Errorf("Invalid platform type. Please enter one of 'gcp', 'ccp' or 'generic'")
+	return fmt.Errorf("Invalid platform type. Please enter one of 'gcp', 'gke', 'kind', 'metal', 'ccp' or 'generic'")
 }
 
 // ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
 func (r *KfCluster) ValidateUpdate(old runtime.Object) error {
 	kfclusterlog.Info("validate update", "name", r.Name)
-	if r.Spec.Platform == "gcp" || r.Spec.Platform == "metal" {
+	if r.Spec.Platform == "gcp" || r.Spec.Platform == "gke" || r.Spec.Platform == "kind" || r.Spec.Platform == "metal" || r.Spec.Platform == "generic" || IsExternalPlatform(r.Spec.Platform) {
+		if err := r.validateSpec(); err != nil {
+			return err
+		}
+		if oldCluster, ok := old.(*KfCluster); ok {
+			if err := r.validateKubeflowSpec(oldCluster); err != nil {
+				return err
+			}
+			if err := r.validateGCPServiceAccounts(oldCluster); err != nil {
+				return err
+			}
+			return r.validateKubernetesUpgrade(oldCluster)
+		}
 		return nil
 	}
-	return fmt.Errorf("Invalid platform type. Please enter one of 'gcp' or 'metal")
+	return fmt.Errorf("Invalid platform type. Please enter one of 'gcp', 'gke', 'kind', 'metal' or 'generic'")
 }
 
 // ValidateDelete implements webhook.Validator so a webhook will be registered for the type
@@ -75,3 +103,436 @@ func (r *KfCluster) ValidateDelete() error {
 	// TODO(user): fill in your validation logic upon object deletion.
 	return nil
 }
+
+// validateSpec runs the spec checks shared by create and update
+func (r *KfCluster) validateSpec() error {
+	if err := r.validatePlatformSpec(); err != nil {
+		return err
+	}
+	if err := r.validateKfVersion(); err != nil {
+		return err
+	}
+	if err := r.validateKubernetesVersion(); err != nil {
+		return err
+	}
+	if err := r.validateApps(); err != nil {
+		return err
+	}
+	return r.validateSecretRefs()
+}
+
+// validateKubernetesVersion checks the Kubernetes version against the versions the Kubeflow release supports
+func (r *KfCluster) validateKubernetesVersion() error {
+	if r.Spec.KubernetesVersion != "" {
+		if _, err := version.ParseGeneric(r.Spec.KubernetesVersion); err != nil {
+			return fmt.Errorf("spec.kubernetes_version %q is invalid: %v", r.Spec.KubernetesVersion, err)
+		}
+		if r.Spec.GKE != nil && r.Spec.GKE.Version != "" && r.Spec.GKE.Version != r.Spec.KubernetesVersion {
+			return fmt.Errorf("spec.kubernetes_version and spec.gke.version differ; set only one of them")
+		}
+	}
+	kubernetesVersion := r.DesiredKubernetesVersion()
+	if kubernetesVersion == "" || r.Spec.KfVersion == "" {
+		return nil
+	}
+	release, err := compatibility.FindRelease(r.Spec.KfVersion)
+	if err != nil {
+		// Releases the matrix doesn't know yet are not restricted
+		return nil
+	}
+	return release.CheckKubernetes(kubernetesVersion)
+}
+
+// validateApps checks that the apps exist in the Kubeflow release
+func (r *KfCluster) validateApps() error {
+	seen := map[string]bool{}
+	for _, app := range r.Spec.Apps {
+		if seen[app] {
+			return fmt.Errorf("spec.apps lists %q twice", app)
+		}
+		seen[app] = true
+	}
+	if len(r.Spec.Apps) == 0 || r.Spec.KfVersion == "" {
+		return nil
+	}
+	release, err := compatibility.FindRelease(r.Spec.KfVersion)
+	if err != nil {
+		// Releases the matrix doesn't know yet are not restricted
+		return nil
+	}
+	if err := release.CheckApps(r.Spec.Apps); err != nil {
+		return fmt.Errorf("spec.apps: %v", err)
+	}
+	return nil
+}
+
+// validateKubernetesUpgrade checks that a Kubernetes version change is an upgrade the platform can roll out in place.
+// Kubernetes only supports moving one minor version at a time.
+func (r *KfCluster) validateKubernetesUpgrade(old *KfCluster) error {
+	from, to := old.DesiredKubernetesVersion(), r.DesiredKubernetesVersion()
+	if from == "" || to == "" || from == to {
+		return nil
+	}
+	if r.Spec.Platform == KfKind || (r.Spec.Platform == KfGcp && r.Spec.GCP != nil && r.Spec.GCP.Bootstrap == GCPBootstrapKubeadm) {
+		return fmt.Errorf("the Kubernetes version of %s clusters can't be changed in place; create a new KfCluster", r.Spec.Platform)
+	}
+	fromVersion, err := version.ParseGeneric(from)
+	if err != nil {
+		return nil
+	}
+	toVersion, err := version.ParseGeneric(to)
+	if err != nil {
+		return err
+	}
+	if toVersion.LessThan(fromVersion) {
+		return fmt.Errorf("Kubernetes %s is older than %s; downgrades are not supported", to, from)
+	}
+	if toVersion.Major() != fromVersion.Major() || toVersion.Minor() > fromVersion.Minor()+1 {
+		return fmt.Errorf("Kubernetes can only be upgraded one minor version at a time, not from %s to %s", from, to)
+	}
+	return nil
+}
+
+// validateKubeflowSpec rejects spec.kf_version and spec.apps on platforms that don't install Kubeflow.
+// KfClusters created before the check keep the values they had, so that the controller can still update them.
+func (r *KfCluster) validateKubeflowSpec(old *KfCluster) error {
+	if r.InstallsKubeflow() {
+		return nil
+	}
+	if old != nil && old.Spec.KfVersion == r.Spec.KfVersion && reflect.DeepEqual(old.Spec.Apps, r.Spec.Apps) {
+		return nil
+	}
+	if r.Spec.KfVersion != "" || len(r.Spec.Apps) > 0 {
+		return fmt.Errorf("spec.kf_version and spec.apps are not supported on %s clusters the controller provisions itself, as it doesn't install Kubeflow on them", r.Spec.Platform)
+	}
+	return nil
+}
+
+// validateGCPServiceAccounts checks that the controller may act as the GCP service accounts of the spec
+// for the namespace of the KfCluster; see AllowGCPServiceAccounts. Like validateKubeflowSpec, it lets
+// KfClusters keep the service accounts they had.
+func (r *KfCluster) validateGCPServiceAccounts(old *KfCluster) error {
+	for field, serviceAccount := range r.impersonatedServiceAccounts() {
+		if old != nil && old.impersonatedServiceAccounts()[field] == serviceAccount {
+			continue
+		}
+		if !GCPServiceAccountAllowed(r.Namespace, serviceAccount) {
+			return fmt.Errorf("%s.service_account %q is not allowed in namespace %s; the controller administrator allows service accounts with --gcp-service-accounts",
+				field, serviceAccount, r.Namespace)
+		}
+	}
+	return nil
+}
+
+// impersonatedServiceAccounts returns the GCP service accounts of the spec by the field of their auth settings
+func (r *KfCluster) impersonatedServiceAccounts() map[string]string {
+	serviceAccounts := map[string]string{}
+	if r.Spec.GCP != nil && ImpersonatedServiceAccount(r.Spec.GCP.Auth) != "" {
+		serviceAccounts["spec.gcp.auth"] = r.Spec.GCP.Auth.ServiceAccount
+	}
+	if r.Spec.GKE != nil && ImpersonatedServiceAccount(r.Spec.GKE.Auth) != "" {
+		serviceAccounts["spec.gke.auth"] = r.Spec.GKE.Auth.ServiceAccount
+	}
+	return serviceAccounts
+}
+
+// validateKfVersion checks that spec.kf_version is a release version or "latest"
+func (r *KfCluster) validateKfVersion() error {
+	if r.Spec.KfVersion == "" || r.Spec.KfVersion == LatestKfVersion {
+		return nil
+	}
+	if _, err := version.ParseGeneric(r.Spec.KfVersion); err != nil {
+		return fmt.Errorf("spec.kf_version %q is not a Kubeflow release version: %v", r.Spec.KfVersion, err)
+	}
+	return nil
+}
+
+// validateSecretRefs checks that injected secret keys map to valid env var names and unique relative file paths
+func (r *KfCluster) validateSecretRefs() error {
+	paths := map[string]bool{}
+	for i, ref := range r.Spec.SecretRefs {
+		if ref.Name == "" {
+			return fmt.Errorf("spec.secret_refs[%d].name is required", i)
+		}
+		for _, env := range ref.Env {
+			if env.Key == "" || env.Name == "" {
+				return fmt.Errorf("spec.secret_refs[%d].env entries need a key and a name", i)
+			}
+			if errs := validation.IsEnvVarName(env.Name); len(errs) > 0 {
+				return fmt.Errorf("spec.secret_refs[%d].env name %q is invalid: %s", i, env.Name, strings.Join(errs, ", "))
+			}
+		}
+		for _, file := range ref.Files {
+			if file.Key == "" || file.Path == "" {
+				return fmt.Errorf("spec.secret_refs[%d].files entries need a key and a path", i)
+			}
+			if path.IsAbs(file.Path) || strings.HasPrefix(path.Clean(file.Path), "..") {
+				return fmt.Errorf("spec.secret_refs[%d].files path %q must be relative", i, file.Path)
+			}
+			if paths[path.Clean(file.Path)] {
+				return fmt.Errorf("spec.secret_refs[%d].files path %q is used more than once", i, file.Path)
+			}
+			paths[path.Clean(file.Path)] = true
+		}
+	}
+	return nil
+}
+
+// validatePlatformSpec checks the typed platform settings against the selected platform.
+// Required settings may be left empty when a ConfigMap is given, since it can supply them.
+func (r *KfCluster) validatePlatformSpec() error {
+	spec := r.Spec
+	if spec.GCP != nil && spec.Platform != KfGcp {
+		return fmt.Errorf("spec.gcp is only valid for platform 'gcp'")
+	}
+	if spec.GKE != nil && spec.Platform != KfGke {
+		return fmt.Errorf("spec.gke is only valid for platform 'gke'")
+	}
+	if spec.Kind != nil && spec.Platform != KfKind {
+		return fmt.Errorf("spec.kind is only valid for platform 'kind'")
+	}
+	if spec.Metal != nil && spec.Platform != KfMetal {
+		return fmt.Errorf("spec.metal is only valid for platform 'metal'")
+	}
+	if spec.Generic != nil && spec.Platform != KfGeneric {
+		return fmt.Errorf("spec.generic is only valid for platform 'generic'")
+	}
+	if spec.External != nil && !IsExternalPlatform(spec.Platform) {
+		return fmt.Errorf("spec.external is only valid for platforms served by an external provider")
+	}
+	switch spec.Platform {
+	case KfGcp:
+		if spec.GCP == nil {
+			if spec.ConfigMapName == "" {
+				return fmt.Errorf("spec.gcp or spec.config_map_name is required for platform 'gcp'")
+			}
+			return nil
+		}
+		if spec.GCP.NodeCount < 0 {
+			return fmt.Errorf("spec.gcp.node_count must not be negative")
+		}
+		if spec.GCP.Region != "" && spec.GCP.Zone != "" && !strings.HasPrefix(spec.GCP.Zone, spec.GCP.Region+"-") {
+			return fmt.Errorf("spec.gcp.zone %q is not in region %q", spec.GCP.Zone, spec.GCP.Region)
+		}
+		if spec.GCP.KopsStateStore != "" && !strings.HasPrefix(spec.GCP.KopsStateStore, "gs://") {
+			return fmt.Errorf("spec.gcp.kops_state_store must be a gs:// URL")
+		}
+		if err := validateGCPAuth("spec.gcp.auth", spec.GCP.Auth); err != nil {
+			return err
+		}
+		if spec.GCP.Bootstrap != GCPBootstrapKubeadm && spec.GCP.Auth != nil && spec.GCP.Auth.Mode == GCPAuthImpersonation {
+			return fmt.Errorf("spec.gcp.auth.mode Impersonation requires the kubeadm bootstrap; kops authenticates with Application Default Credentials, use WorkloadIdentity or KeyFile")
+		}
+		for i, sourceRange := range spec.GCP.APISourceRanges {
+			if _, _, err := net.ParseCIDR(sourceRange); err != nil {
+				return fmt.Errorf("spec.gcp.api_source_ranges[%d] %q is not a CIDR range", i, sourceRange)
+			}
+		}
+		if spec.GCP.Bootstrap == GCPBootstrapKubeadm {
+			if spec.GCP.Project == "" || spec.GCP.Zone == "" {
+				if spec.ConfigMapName == "" {
+					return fmt.Errorf("spec.gcp.project and spec.gcp.zone are required without a config map")
+				}
+			}
+		} else if spec.ConfigMapName == "" {
+			if spec.GCP.Project == "" || spec.GCP.Zone == "" || spec.GCP.KopsStateStore == "" {
+				return fmt.Errorf("spec.gcp.project, spec.gcp.zone and spec.gcp.kops_state_store are required without a config map")
+			}
+		}
+	case KfGke:
+		if spec.GKE == nil || spec.GKE.Project == "" || spec.GKE.Location == "" {
+			return fmt.Errorf("spec.gke.project and spec.gke.location are required for platform 'gke'")
+		}
+		if err := validateNodePools(spec.GKE.NodePools); err != nil {
+			return err
+		}
+		if err := validateGCPAuth("spec.gke.auth", spec.GKE.Auth); err != nil {
+			return err
+		}
+	case KfMetal:
+		if spec.Metal == nil || len(spec.Metal.Hosts) == 0 {
+			return fmt.Errorf("spec.metal.hosts is required for platform 'metal'")
+		}
+		hosts := map[string]bool{}
+		for i, host := range spec.Metal.Hosts {
+			if host == "" || hosts[host] {
+				return fmt.Errorf("spec.metal.hosts[%d] %q is empty or used more than once", i, host)
+			}
+			hosts[host] = true
+		}
+		if ref := spec.Metal.SSHKeySecretRef; ref == nil || ref.Name == "" || ref.Key == "" {
+			return fmt.Errorf("spec.metal.ssh_key_secret_ref with a name and key is required")
+		}
+		if ref := spec.Metal.KnownHostsSecretRef; ref != nil && (ref.Name == "" || ref.Key == "") {
+			return fmt.Errorf("spec.metal.known_hosts_secret_ref needs a name and key")
+		}
+		if spec.Metal.KnownHostsSecretRef == nil && !spec.Metal.InsecureSkipHostKeyVerification {
+			return fmt.Errorf("spec.metal.known_hosts_secret_ref is required unless spec.metal.insecure_skip_host_key_verification is set")
+		}
+	case KfGeneric:
+		if spec.Generic == nil {
+			return nil
+		}
+		if spec.Generic.KubeconfigSecretRef == nil || spec.Generic.KubeconfigSecretRef.Name == "" {
+			return fmt.Errorf("spec.generic.kubeconfig_secret_ref.name is required")
+		}
+	}
+	if r.IsAdopted() {
+		if err := r.validateAdopt(); err != nil {
+			return err
+		}
+	}
+	if spec.External != nil {
+		keys := map[string]bool{}
+		for i, ref := range spec.External.SecretRefs {
+			if ref.Name == "" || ref.Key == "" {
+				return fmt.Errorf("spec.external.secret_refs[%d] needs a name and key", i)
+			}
+			if keys[ref.Key] {
+				return fmt.Errorf("spec.external.secret_refs[%d] key %q is used more than once", i, ref.Key)
+			}
+			keys[ref.Key] = true
+		}
+	}
+	return nil
+}
+
+// validateAdopt checks that an adopted cluster can be found: from a kubeconfig, or in a kops state store
+func (r *KfCluster) validateAdopt() error {
+	adopt := r.Spec.Adopt
+	if adopt != nil && adopt.KubeconfigSecretRef != nil {
+		if adopt.KubeconfigSecretRef.Name == "" || adopt.KubeconfigSecretRef.Key == "" {
+			return fmt.Errorf("spec.adopt.kubeconfig_secret_ref needs a name and key")
+		}
+		return nil
+	}
+	if r.Spec.Platform != KfGcp || (r.Spec.GCP != nil && r.Spec.GCP.Bootstrap == GCPBootstrapKubeadm) {
+		return fmt.Errorf("spec.adopt.kubeconfig_secret_ref is required unless adopting a kops cluster on platform 'gcp'")
+	}
+	return nil
+}
+
+var (
+	externalPlatformsLock sync.RWMutex
+	externalPlatforms     = map[KfPlatform]bool{}
+)
+
+// RegisterExternalPlatform lets KfClusters name a platform served by an out-of-tree provider
+func RegisterExternalPlatform(platform KfPlatform) {
+	externalPlatformsLock.Lock()
+	defer externalPlatformsLock.Unlock()
+	externalPlatforms[platform] = true
+}
+
+// IsExternalPlatform reports whether the platform is served by an out-of-tree provider
+func IsExternalPlatform(platform KfPlatform) bool {
+	externalPlatformsLock.RLock()
+	defer externalPlatformsLock.RUnlock()
+	return externalPlatforms[platform]
+}
+
+// GCPServiceAccountGrant allows the KfClusters in the namespaces matching Namespace to have the controller
+// act as the GCP service accounts matching ServiceAccount. Both are path.Match patterns, such as
+// team-a=*@team-a-project.iam.gserviceaccount.com.
+type GCPServiceAccountGrant struct {
+	Namespace      string
+	ServiceAccount string
+}
+
+var (
+	gcpServiceAccountsLock sync.RWMutex
+	gcpServiceAccounts     []GCPServiceAccountGrant
+)
+
+// ParseGCPServiceAccountGrants parses a comma separated list of namespace=service-account patterns
+func ParseGCPServiceAccountGrants(value string) ([]GCPServiceAccountGrant, error) {
+	grants := []GCPServiceAccountGrant{}
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid GCP service account grant %q, expected namespace=service-account", pair)
+		}
+		for _, pattern := range parts {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q in GCP service account grant %q: %v", pattern, pair, err)
+			}
+		}
+		grants = append(grants, GCPServiceAccountGrant{Namespace: parts[0], ServiceAccount: parts[1]})
+	}
+	return grants, nil
+}
+
+// AllowGCPServiceAccounts sets the GCP service accounts the controller may act as for KfClusters.
+// Without grants, KfClusters can't name service accounts for Workload Identity or impersonation.
+func AllowGCPServiceAccounts(grants []GCPServiceAccountGrant) {
+	gcpServiceAccountsLock.Lock()
+	defer gcpServiceAccountsLock.Unlock()
+	gcpServiceAccounts = grants
+}
+
+// GCPServiceAccountAllowed reports whether the controller may act as serviceAccount for KfClusters in namespace
+func GCPServiceAccountAllowed(namespace string, serviceAccount string) bool {
+	gcpServiceAccountsLock.RLock()
+	defer gcpServiceAccountsLock.RUnlock()
+	for _, grant := range gcpServiceAccounts {
+		namespaceMatch, _ := path.Match(grant.Namespace, namespace)
+		serviceAccountMatch, _ := path.Match(grant.ServiceAccount, serviceAccount)
+		if namespaceMatch && serviceAccountMatch {
+			return true
+		}
+	}
+	return false
+}
+
+// ImpersonatedServiceAccount returns the GCP service account the controller and the provisioner act as
+// with auth, or empty when they use a key file or their own credentials
+func ImpersonatedServiceAccount(auth *GCPAuthSpec) string {
+	if auth == nil || auth.Mode == GCPAuthKeyFile {
+		return ""
+	}
+	return auth.ServiceAccount
+}
+
+// validateGCPAuth checks that each GCP auth mode has the credentials it needs
+func validateGCPAuth(field string, auth *GCPAuthSpec) error {
+	if auth == nil {
+		return nil
+	}
+	switch auth.Mode {
+	case "", GCPAuthWorkloadIdentity, GCPAuthImpersonation:
+		if auth.ServiceAccount == "" {
+			return fmt.Errorf("%s.service_account is required for Workload Identity and impersonation", field)
+		}
+		if !strings.HasSuffix(auth.ServiceAccount, ".iam.gserviceaccount.com") {
+			return fmt.Errorf("%s.service_account %q is not a GCP service account email", field, auth.ServiceAccount)
+		}
+	case GCPAuthKeyFile:
+		if auth.KeySecretRef == nil || auth.KeySecretRef.Name == "" || auth.KeySecretRef.Key == "" {
+			return fmt.Errorf("%s.key_secret_ref with a name and key is required for the KeyFile mode", field)
+		}
+	default:
+		return fmt.Errorf("%s.mode %q is invalid", field, auth.Mode)
+	}
+	return nil
+}
+
+// validateNodePools checks that GKE node pools have unique valid names and consistent autoscaling bounds
+func validateNodePools(pools []NodePoolSpec) error {
+	names := map[string]bool{}
+	for i, pool := range pools {
+		if errs := validation.IsDNS1123Label(pool.Name); len(errs) > 0 {
+			return fmt.Errorf("spec.gke.node_pools[%d].name %q is invalid: %s", i, pool.Name, strings.Join(errs, ", "))
+		}
+		if names[pool.Name] {
+			return fmt.Errorf("spec.gke.node_pools[%d].name %q is used more than once", i, pool.Name)
+		}
+		names[pool.Name] = true
+		if pool.MaxNodeCount > 0 && pool.MinNodeCount > pool.MaxNodeCount {
+			return fmt.Errorf("spec.gke.node_pools[%d].min_node_count must not exceed max_node_count", i)
+		}
+	}
+	return nil
+}
@@ -20,10 +20,166 @@ limitations under the License.
 package v1alpha1
 
 import (
-	"k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AdoptSpec) DeepCopyInto(out *AdoptSpec) {
+	*out = *in
+	if in.KubeconfigSecretRef != nil {
+		in, out := &in.KubeconfigSecretRef, &out.KubeconfigSecretRef
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AdoptSpec.
+func (in *AdoptSpec) DeepCopy() *AdoptSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AdoptSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExternalSpec) DeepCopyInto(out *ExternalSpec) {
+	*out = *in
+	if in.Parameters != nil {
+		in, out := &in.Parameters, &out.Parameters
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.SecretRefs != nil {
+		in, out := &in.SecretRefs, &out.SecretRefs
+		*out = make([]v1.SecretKeySelector, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExternalSpec.
+func (in *ExternalSpec) DeepCopy() *ExternalSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ExternalSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GCPAuthSpec) DeepCopyInto(out *GCPAuthSpec) {
+	*out = *in
+	if in.KeySecretRef != nil {
+		in, out := &in.KeySecretRef, &out.KeySecretRef
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GCPAuthSpec.
+func (in *GCPAuthSpec) DeepCopy() *GCPAuthSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(GCPAuthSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GCPServiceAccountGrant) DeepCopyInto(out *GCPServiceAccountGrant) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GCPServiceAccountGrant.
+func (in *GCPServiceAccountGrant) DeepCopy() *GCPServiceAccountGrant {
+	if in == nil {
+		return nil
+	}
+	out := new(GCPServiceAccountGrant)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GCPSpec) DeepCopyInto(out *GCPSpec) {
+	*out = *in
+	if in.Auth != nil {
+		in, out := &in.Auth, &out.Auth
+		*out = new(GCPAuthSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.APISourceRanges != nil {
+		in, out := &in.APISourceRanges, &out.APISourceRanges
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GCPSpec.
+func (in *GCPSpec) DeepCopy() *GCPSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(GCPSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GKESpec) DeepCopyInto(out *GKESpec) {
+	*out = *in
+	if in.NodePools != nil {
+		in, out := &in.NodePools, &out.NodePools
+		*out = make([]NodePoolSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Auth != nil {
+		in, out := &in.Auth, &out.Auth
+		*out = new(GCPAuthSpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GKESpec.
+func (in *GKESpec) DeepCopy() *GKESpec {
+	if in == nil {
+		return nil
+	}
+	out := new(GKESpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GenericSpec) DeepCopyInto(out *GenericSpec) {
+	*out = *in
+	if in.KubeconfigSecretRef != nil {
+		in, out := &in.KubeconfigSecretRef, &out.KubeconfigSecretRef
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GenericSpec.
+func (in *GenericSpec) DeepCopy() *GenericSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(GenericSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *KfCluster) DeepCopyInto(out *KfCluster) {
 	*out = *in
@@ -54,11 +210,7 @@ func (in *KfCluster) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *KfClusterCondition) DeepCopyInto(out *KfClusterCondition) {
 	*out = *in
-	if in.State != nil {
-		in, out := &in.State, &out.State
-		*out = new(v1.DeploymentConditionType)
-		**out = **in
-	}
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KfClusterCondition.
@@ -116,6 +268,58 @@ func (in *KfClusterSpec) DeepCopyInto(out *KfClusterSpec) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.SecretRefs != nil {
+		in, out := &in.SecretRefs, &out.SecretRefs
+		*out = make([]SecretRef, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.GCP != nil {
+		in, out := &in.GCP, &out.GCP
+		*out = new(GCPSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.GKE != nil {
+		in, out := &in.GKE, &out.GKE
+		*out = new(GKESpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Kind != nil {
+		in, out := &in.Kind, &out.Kind
+		*out = new(KindSpec)
+		**out = **in
+	}
+	if in.Metal != nil {
+		in, out := &in.Metal, &out.Metal
+		*out = new(MetalSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Generic != nil {
+		in, out := &in.Generic, &out.Generic
+		*out = new(GenericSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.External != nil {
+		in, out := &in.External, &out.External
+		*out = new(ExternalSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Adopt != nil {
+		in, out := &in.Adopt, &out.Adopt
+		*out = new(AdoptSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Provisioner != nil {
+		in, out := &in.Provisioner, &out.Provisioner
+		*out = new(ProvisionerSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Storage != nil {
+		in, out := &in.Storage, &out.Storage
+		*out = new(StorageSpec)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KfClusterSpec.
@@ -138,6 +342,32 @@ func (in *KfClusterStatus) DeepCopyInto(out *KfClusterStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.ProvisionedTime != nil {
+		in, out := &in.ProvisionedTime, &out.ProvisionedTime
+		*out = (*in).DeepCopy()
+	}
+	if in.InstalledApps != nil {
+		in, out := &in.InstalledApps, &out.InstalledApps
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Upgrade != nil {
+		in, out := &in.Upgrade, &out.Upgrade
+		*out = new(KubeflowUpgradeStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.UpgradeHistory != nil {
+		in, out := &in.UpgradeHistory, &out.UpgradeHistory
+		*out = make([]KubeflowUpgradeStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.KubernetesUpgrade != nil {
+		in, out := &in.KubernetesUpgrade, &out.KubernetesUpgrade
+		*out = new(KubernetesUpgradeStatus)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KfClusterStatus.
@@ -149,3 +379,242 @@ func (in *KfClusterStatus) DeepCopy() *KfClusterStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KindSpec) DeepCopyInto(out *KindSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KindSpec.
+func (in *KindSpec) DeepCopy() *KindSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(KindSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KubeflowUpgradeStatus) DeepCopyInto(out *KubeflowUpgradeStatus) {
+	*out = *in
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubeflowUpgradeStatus.
+func (in *KubeflowUpgradeStatus) DeepCopy() *KubeflowUpgradeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(KubeflowUpgradeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KubernetesUpgradeStatus) DeepCopyInto(out *KubernetesUpgradeStatus) {
+	*out = *in
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubernetesUpgradeStatus.
+func (in *KubernetesUpgradeStatus) DeepCopy() *KubernetesUpgradeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(KubernetesUpgradeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetalSpec) DeepCopyInto(out *MetalSpec) {
+	*out = *in
+	if in.Hosts != nil {
+		in, out := &in.Hosts, &out.Hosts
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.SSHKeySecretRef != nil {
+		in, out := &in.SSHKeySecretRef, &out.SSHKeySecretRef
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.KnownHostsSecretRef != nil {
+		in, out := &in.KnownHostsSecretRef, &out.KnownHostsSecretRef
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.IgnorePreflightErrors != nil {
+		in, out := &in.IgnorePreflightErrors, &out.IgnorePreflightErrors
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetalSpec.
+func (in *MetalSpec) DeepCopy() *MetalSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MetalSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodePoolSpec) DeepCopyInto(out *NodePoolSpec) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodePoolSpec.
+func (in *NodePoolSpec) DeepCopy() *NodePoolSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NodePoolSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProvisionerSpec) DeepCopyInto(out *ProvisionerSpec) {
+	*out = *in
+	if in.ImagePullSecrets != nil {
+		in, out := &in.ImagePullSecrets, &out.ImagePullSecrets
+		*out = make([]v1.LocalObjectReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = new(v1.ResourceRequirements)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Tolerations != nil {
+		in, out := &in.Tolerations, &out.Tolerations
+		*out = make([]v1.Toleration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProvisionerSpec.
+func (in *ProvisionerSpec) DeepCopy() *ProvisionerSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ProvisionerSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretEnvVar) DeepCopyInto(out *SecretEnvVar) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretEnvVar.
+func (in *SecretEnvVar) DeepCopy() *SecretEnvVar {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretEnvVar)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretFile) DeepCopyInto(out *SecretFile) {
+	*out = *in
+	if in.Mode != nil {
+		in, out := &in.Mode, &out.Mode
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretFile.
+func (in *SecretFile) DeepCopy() *SecretFile {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretFile)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretRef) DeepCopyInto(out *SecretRef) {
+	*out = *in
+	if in.Env != nil {
+		in, out := &in.Env, &out.Env
+		*out = make([]SecretEnvVar, len(*in))
+		copy(*out, *in)
+	}
+	if in.Files != nil {
+		in, out := &in.Files, &out.Files
+		*out = make([]SecretFile, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretRef.
+func (in *SecretRef) DeepCopy() *SecretRef {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StorageSpec) DeepCopyInto(out *StorageSpec) {
+	*out = *in
+	if in.Size != nil {
+		in, out := &in.Size, &out.Size
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StorageSpec.
+func (in *StorageSpec) DeepCopy() *StorageSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(StorageSpec)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,30 @@
+#!/bin/bash
+# Adopts an existing kops cluster: exports its kubeconfig to the provisioner volume and reports
+# the Kubeflow installed on it in the termination message, without running kops create or kfctl apply.
+
+set -e
+
+. /gcp_auth.sh
+
+if ! kops get cluster ${CLUSTER_NAME} --state ${KOPS_STATE_STORE}/ > /dev/null; then
+  echo "kops cluster ${CLUSTER_NAME} not found in ${KOPS_STATE_STORE}" | tee /dev/termination-log
+  exit 1
+fi
+mkdir -p /mnt/volume/${KFCLUSTER_NAME}
+kops export kubecfg ${CLUSTER_NAME} --state ${KOPS_STATE_STORE}/ --kubeconfig /mnt/volume/${KFCLUSTER_NAME}/kubeconfig
+export KUBECONFIG=/mnt/volume/${KFCLUSTER_NAME}/kubeconfig
+kubectl get ns
+
+# The KfDef kfctl leaves on the cluster names the version and applications
+VERSION=$(kubectl get kfdef -A -o jsonpath='{.items[0].spec.version}' 2> /dev/null || true)
+APPS=$(kubectl get kfdef -A -o jsonpath='{.items[0].spec.applications[*].name}' 2> /dev/null || true)
+if [ -z "${APPS}" ]; then
+  APPS=$(kubectl get deployments -n kubeflow -o jsonpath='{.items[*].metadata.name}' 2> /dev/null || true)
+fi
+if [ -z "${VERSION}" ]; then
+  VERSION=$(kubectl get deployments -n kubeflow -o jsonpath='{.items[*].metadata.labels.app\.kubernetes\.io/version}' 2> /dev/null \
+    | tr ' ' '\n' | sort | uniq -c | sort -rn | awk 'NR==1 {print $2}')
+fi
+APPS_JSON=$(for app in ${APPS}; do printf '"%s",' "${app}"; done)
+printf '{"version":"%s","apps":[%s]}' "${VERSION}" "${APPS_JSON%,}" > /dev/termination-log
+cat /dev/termination-log
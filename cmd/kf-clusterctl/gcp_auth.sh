@@ -0,0 +1,24 @@
+#!/bin/bash
+# Authenticates gcloud and kops, sourced by the GCP entrypoints
+
+# GCP_AUTH_MODE is set by the controller from spec.gcp.auth. Workload Identity needs no key;
+# without a mode the legacy key from the ConfigMap is used.
+case "${GCP_AUTH_MODE}" in
+  WorkloadIdentity)
+    # Application Default Credentials resolve through the GKE metadata server
+    ;;
+  Impersonation)
+    # kops and kfctl read Application Default Credentials, which gcloud impersonation doesn't reach
+    echo "GCP impersonation is not supported by kops; use WorkloadIdentity or KeyFile" | tee /dev/termination-log
+    exit 1
+    ;;
+  KeyFile)
+    gcloud -q auth activate-service-account --key-file="${GOOGLE_APPLICATION_CREDENTIALS}" --user-output-enabled false
+    ;;
+  *)
+    echo "${APPLICATION_CREDENTIALS}" | base64 -d > /tmp/account.json
+    gcloud -q auth activate-service-account --key-file=/tmp/account.json --user-output-enabled false
+    export GOOGLE_APPLICATION_CREDENTIALS=/tmp/account.json
+    ;;
+esac
+gcloud -q config set project "$PROJECT" --user-output-enabled false
@@ -2,13 +2,26 @@
 
 set -e
 
-echo "${APPLICATION_CREDENTIALS}" | base64 -d > /tmp/account.json
-gcloud -q auth activate-service-account --key-file=/tmp/account.json --user-output-enabled false
-gcloud -q config set project "$PROJECT" --user-output-enabled false
-
-export GOOGLE_APPLICATION_CREDENTIALS=/tmp/account.json
-# kops create cluster - creates cluster spec and initializes state
-kops create cluster ${CLUSTER_NAME} --zones ${ZONE} --state ${KOPS_STATE_STORE}/ --project=${PROJECT}
+. /gcp_auth.sh
+# Optional settings from the KfCluster spec or its ConfigMap
+KOPS_FLAGS=""
+if [ -n "${NODE_COUNT}" ]; then
+  KOPS_FLAGS="${KOPS_FLAGS} --node-count=${NODE_COUNT}"
+fi
+if [ -n "${MACHINE_TYPE}" ]; then
+  KOPS_FLAGS="${KOPS_FLAGS} --node-size=${MACHINE_TYPE}"
+fi
+if [ -n "${NETWORK}" ]; then
+  KOPS_FLAGS="${KOPS_FLAGS} --vpc=${NETWORK}"
+fi
+if [ -n "${KUBERNETES_VERSION}" ]; then
+  KOPS_FLAGS="${KOPS_FLAGS} --kubernetes-version=${KUBERNETES_VERSION}"
+fi
+# kops create cluster - creates cluster spec and initializes state, unless an earlier run did.
+# Later Kubernetes versions are rolled out by the upgrade Job, see kubernetes_upgrade_entrypoint.sh
+if ! kops get cluster ${CLUSTER_NAME} --state ${KOPS_STATE_STORE}/ > /dev/null 2>&1; then
+  kops create cluster ${CLUSTER_NAME} --zones ${ZONE} --state ${KOPS_STATE_STORE}/ --project=${PROJECT} ${KOPS_FLAGS}
+fi
 # kops update cluster - updates cluster spec, actual step that creates the cluster
 kops update cluster ${CLUSTER_NAME} --yes
 # Export created cluster kubeconfig
@@ -21,5 +34,12 @@ kubectl get ns
 mkdir -p /mnt/volume/${CLUSTER_NAME}/kf-app
 cd /mnt/volume/${CLUSTER_NAME}/kf-app 
 kfctl apply -V -f ${KF_CONFIG}
+# Turns the provisioner pod ready, which tells the controller Kubeflow is installed
+touch /mnt/volume/kubeflow-installed
 sleep 120
 kubectl get po -A
+
+# Keep the pod running, and ready, instead of installing again on every restart
+while true; do
+  sleep 3600
+done
@@ -0,0 +1,24 @@
+#!/bin/bash
+# Deletes the kops cluster of a KfCluster being deleted, along with its Compute Engine resources.
+# The controller records the outcome from the termination message.
+
+set -e
+
+. /gcp_auth.sh
+
+fail() {
+  echo "$1" | tee /dev/termination-log
+  exit 1
+}
+
+[ -n "${CLUSTER_NAME}" ] || fail "CLUSTER_NAME is not set; the ConfigMap of the KfCluster may be gone"
+export KOPS_STATE_STORE=${KOPS_STATE_STORE}/
+if ! output=$(kops get cluster ${CLUSTER_NAME} 2>&1); then
+  if echo "${output}" | grep -q "not found"; then
+    echo -n "kops cluster ${CLUSTER_NAME} does not exist" > /dev/termination-log
+    exit 0
+  fi
+  fail "${output}"
+fi
+kops delete cluster ${CLUSTER_NAME} --yes || fail "kops delete of ${CLUSTER_NAME} failed"
+echo -n "deleted ${CLUSTER_NAME}" > /dev/termination-log
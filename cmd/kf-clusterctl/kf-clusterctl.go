@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
 
 	"github.com/CiscoAI/kf-cluster-api/pkg/gcp"
@@ -13,8 +14,9 @@ const defaultLevel = log.InfoLevel
 
 // Flags for the kind command
 type Flags struct {
-	LogLevel string
-	File     string
+	LogLevel  string
+	File      string
+	Namespace string
 }
 
 // NewCommand creates the root cobra command
@@ -27,7 +29,9 @@ func NewCommand() *cobra.Command {
 
 Usage:
 	'kf-clusterctl create -f "kfcluster-gcp.yaml"' - creates a KF Cluster from spec.
-	'kf-clusterctl delete' - deletes a KF Cluster from spec.`,
+	'kf-clusterctl delete' - deletes a KF Cluster from spec.
+	'kf-clusterctl versions [kf_version]' - lists the Kubernetes versions and apps of Kubeflow releases.
+	'kf-clusterctl rollback <name>' - rolls back the latest Kubeflow upgrade of a KfCluster.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return runE(flags, cmd, args)
 		},
@@ -35,12 +39,13 @@ Usage:
 	}
 	cmd.Flags().StringVar(&flags.LogLevel, "loglevel", "info", "Default Log Level")
 	cmd.Flags().StringVar(&flags.File, "file", "", "KF Cluster spec file")
+	cmd.Flags().StringVarP(&flags.Namespace, "namespace", "n", "default", "Namespace of the KfCluster")
 	return cmd
 }
 
 func runE(flags *Flags, cmd *cobra.Command, args []string) error {
 	if len(args) < 1 {
-		log.Fatalf("kf-clusterctl needs an argument: `kf-clusterctl create` or `kf-clusterctl delete`")
+		log.Fatalf("kf-clusterctl needs an argument: `kf-clusterctl create`, `kf-clusterctl delete`, `kf-clusterctl versions` or `kf-clusterctl rollback`")
 	}
 	// handle logLevel logic
 	level := defaultLevel
@@ -52,10 +57,24 @@ func runE(flags *Flags, cmd *cobra.Command, args []string) error {
 	}
 	log.SetLevel(level)
 
+	if args[0] == "versions" {
+		kfVersion := ""
+		if len(args) > 1 {
+			kfVersion = args[1]
+		}
+		return printVersions(os.Stdout, kfVersion)
+	}
+
 	ctx := context.Background()
+	if args[0] == "rollback" {
+		if len(args) < 2 {
+			return fmt.Errorf("kf-clusterctl rollback needs the name of a KfCluster")
+		}
+		return requestRollback(ctx, flags.Namespace, args[1])
+	}
 	if args[0] == "create" {
 		// Get compute engine client
-		computeService, err := gcp.GetClient(ctx)
+		computeService, err := gcp.GetClient(ctx, gcp.AuthConfigFromEnv())
 		if err != nil {
 			return err
 		}
@@ -67,7 +86,7 @@ func runE(flags *Flags, cmd *cobra.Command, args []string) error {
 	}
 	if args[0] == "delete" {
 		// Get compute engine client
-		computeService, err := gcp.GetClient(ctx)
+		computeService, err := gcp.GetClient(ctx, gcp.AuthConfigFromEnv())
 		if err != nil {
 			return err
 		}
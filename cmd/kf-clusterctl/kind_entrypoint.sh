@@ -0,0 +1,59 @@
+#!/bin/bash
+
+set -e
+
+# The kind nodes run in the Docker-in-Docker sidecar reached through DOCKER_HOST
+until docker info > /dev/null 2>&1; do
+  echo "Waiting for the Docker daemon at ${DOCKER_HOST}"
+  sleep 2
+done
+
+KUBECONFIG_PATH=/mnt/volume/${CLUSTER_NAME}/kubeconfig
+mkdir -p /mnt/volume/${CLUSTER_NAME}
+
+# kind create cluster - skipped when the container restarts with the cluster already running
+if ! kind get clusters | grep -qx "${CLUSTER_NAME}"; then
+  # Expose the API server on the pod IP so the kubeconfig works from outside the pod
+  cat > /tmp/kind-config.yaml <<CONFIG
+kind: Cluster
+apiVersion: kind.x-k8s.io/v1alpha4
+networking:
+  apiServerAddress: "0.0.0.0"
+  apiServerPort: 6443
+kubeadmConfigPatches:
+- |
+  kind: ClusterConfiguration
+  apiServer:
+    certSANs:
+    - "${POD_IP}"
+nodes:
+- role: control-plane
+CONFIG
+  for i in $(seq 1 "${KIND_WORKERS:-0}"); do
+    echo "- role: worker" >> /tmp/kind-config.yaml
+  done
+  KIND_FLAGS=""
+  if [ -n "${KIND_NODE_IMAGE}" ]; then
+    KIND_FLAGS="${KIND_FLAGS} --image=${KIND_NODE_IMAGE}"
+  fi
+  kind create cluster --name ${CLUSTER_NAME} --config /tmp/kind-config.yaml --wait 5m ${KIND_FLAGS}
+fi
+# Export created cluster kubeconfig
+kind get kubeconfig --name ${CLUSTER_NAME} > ${KUBECONFIG_PATH}
+kubectl config set-cluster kind-${CLUSTER_NAME} --server=https://${POD_IP}:6443 --kubeconfig ${KUBECONFIG_PATH}
+
+# Export Kubeconfig
+export KUBECONFIG=${KUBECONFIG_PATH}
+kubectl get nodes
+# Install Kubeflow, with a minimal app set unless the ConfigMap names a KfDef
+mkdir -p /mnt/volume/${CLUSTER_NAME}/kf-app
+cd /mnt/volume/${CLUSTER_NAME}/kf-app
+kfctl apply -V -f ${KF_CONFIG:-/etc/kfcluster/kfdef/kind.yaml}
+# Turns the provisioner pod ready, which tells the controller Kubeflow is installed
+touch /mnt/volume/kubeflow-installed
+kubectl get po -A
+
+# The cluster lives as long as the sidecar, so keep the pod running
+while true; do
+  sleep 3600
+done
@@ -0,0 +1,20 @@
+#!/bin/bash
+# Upgrades the Kubernetes version of a kops cluster to ${KUBERNETES_VERSION} and rolls the nodes one at a time.
+# The controller records the outcome from the termination message.
+
+set -e
+
+. /gcp_auth.sh
+
+fail() {
+  echo "$1" | tee /dev/termination-log
+  exit 1
+}
+
+export KOPS_STATE_STORE=${KOPS_STATE_STORE}/
+kops get cluster ${CLUSTER_NAME} -o yaml > /tmp/cluster.yaml || fail "kops cluster ${CLUSTER_NAME} not found"
+sed -i "s/^\(  kubernetesVersion:\).*/\1 ${KUBERNETES_VERSION}/" /tmp/cluster.yaml
+kops replace -f /tmp/cluster.yaml || fail "kops rejected Kubernetes ${KUBERNETES_VERSION}"
+kops update cluster ${CLUSTER_NAME} --yes || fail "kops update of ${CLUSTER_NAME} failed"
+kops rolling-update cluster ${CLUSTER_NAME} --yes || fail "rolling update of ${CLUSTER_NAME} failed"
+echo -n "upgraded to ${KUBERNETES_VERSION}" > /dev/termination-log
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+)
+
+// requestRollback annotates a KfCluster so that the controller rolls back its latest Kubeflow upgrade
+func requestRollback(ctx context.Context, namespace string, name string) error {
+	scheme := runtime.NewScheme()
+	if err := cluster.AddToScheme(scheme); err != nil {
+		return err
+	}
+	restConfig, err := config.GetConfig()
+	if err != nil {
+		return err
+	}
+	c, err := client.New(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return err
+	}
+	kfCluster := &cluster.KfCluster{}
+	if err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, kfCluster); err != nil {
+		return err
+	}
+	upgrade := kfCluster.Status.Upgrade
+	if upgrade == nil || !upgrade.CanRollBack() {
+		return fmt.Errorf("KfCluster %s/%s has no finished Kubeflow upgrade with a backup to roll back", namespace, name)
+	}
+	if kfCluster.Annotations == nil {
+		kfCluster.Annotations = map[string]string{}
+	}
+	kfCluster.Annotations[cluster.RollbackAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	if err := c.Update(ctx, kfCluster); err != nil {
+		return err
+	}
+	log.Infof("Requested the rollback of KfCluster %s/%s from Kubeflow %s to %s", namespace, name, upgrade.ToVersion, upgrade.FromVersion)
+	return nil
+}
@@ -0,0 +1,93 @@
+#!/bin/bash
+# Runs one phase of a Kubeflow upgrade from ${KF_FROM_VERSION} to ${KF_TO_VERSION}.
+# The controller runs the phases in order and records their outcome from the termination message.
+# The Rollback phase restores the snapshot taken by the Backup phase.
+# KF_UPGRADE_CONFIG may name the KfDef of the new version; it defaults to the Istio KfDef of the release.
+
+set -e
+
+PHASE=$1
+APP_DIR=/mnt/volume/${KFCLUSTER_NAME}/kf-app
+BACKUP_DIR=/mnt/volume/${KFCLUSTER_NAME}/backups/${KF_FROM_VERSION}
+export KUBECONFIG=${KUBECONFIG:-/mnt/volume/${KFCLUSTER_NAME}/kubeconfig}
+
+fail() {
+  echo "$1" | tee /dev/termination-log
+  exit 1
+}
+
+case "${PHASE}" in
+  PreCheck)
+    kubectl version > /dev/null || fail "the cluster is not reachable"
+    kubectl get namespace kubeflow > /dev/null || fail "namespace kubeflow not found"
+    kubectl get kfdef -A -o name | grep -q . || fail "no KfDef found on the cluster"
+    NOT_READY=$(kubectl get deployments -n kubeflow --no-headers | awk '{split($2, r, "/"); if (r[1] != r[2]) print $1}')
+    [ -z "${NOT_READY}" ] || fail "deployments not ready before the upgrade: $(echo ${NOT_READY})"
+    echo -n "cluster is healthy" > /dev/termination-log
+    ;;
+  Backup)
+    mkdir -p ${BACKUP_DIR}
+    KFDEF_NAME=$(kubectl get kfdef -A -o jsonpath='{.items[0].metadata.name}')
+    KFDEF_NAMESPACE=$(kubectl get kfdef -A -o jsonpath='{.items[0].metadata.namespace}')
+    kubectl get kfdef ${KFDEF_NAME} -n ${KFDEF_NAMESPACE} -o yaml > ${BACKUP_DIR}/kfdef.yaml
+    # The installed manifests, for reference when a rollback needs manual repair
+    kubectl get deployments,statefulsets,services,configmaps,serviceaccounts -n kubeflow -o yaml > ${BACKUP_DIR}/manifests.yaml \
+      || fail "exporting the installed manifests failed"
+    if [ -d ${APP_DIR} ]; then
+      rm -rf ${BACKUP_DIR}/kf-app
+      cp -r ${APP_DIR} ${BACKUP_DIR}/kf-app
+    fi
+    echo -n ${BACKUP_DIR} > /dev/termination-log
+    ;;
+  Apply)
+    KFDEF_NAME=$(kubectl get kfdef -A -o jsonpath='{.items[0].metadata.name}')
+    KFDEF_NAMESPACE=$(kubectl get kfdef -A -o jsonpath='{.items[0].metadata.namespace}')
+    if [ ! -f ${APP_DIR}/kfctl.yaml ]; then
+      # Adopted clusters have no kustomize directory; kfctl finds the current KfDef in the app directory
+      mkdir -p ${APP_DIR}
+      kubectl get kfdef ${KFDEF_NAME} -n ${KFDEF_NAMESPACE} -o yaml > ${APP_DIR}/kfctl.yaml
+    fi
+    RELEASE=${KF_TO_VERSION#v}
+    KF_UPGRADE_CONFIG=${KF_UPGRADE_CONFIG:-https://raw.githubusercontent.com/kubeflow/manifests/v${RELEASE%.*}-branch/kfdef/kfctl_k8s_istio.v${RELEASE}.yaml}
+    cat > ${APP_DIR}/kfupgrade.yaml <<UPGRADE
+apiVersion: kfupgrade.apps.kubeflow.org/v1alpha1
+kind: KfUpgrade
+metadata:
+  name: kf-upgrade-${KF_TO_VERSION}
+spec:
+  currentKfDef:
+    name: ${KFDEF_NAME}
+    version: ${KF_FROM_VERSION}
+  newKfDef:
+    name: ${KFDEF_NAME}
+    version: ${KF_TO_VERSION}
+  baseConfigPath: ${KF_UPGRADE_CONFIG}
+UPGRADE
+    cd ${APP_DIR}
+    kfctl apply -V -f kfupgrade.yaml || fail "kfctl apply of the upgrade to ${KF_TO_VERSION} failed"
+    echo -n "applied ${KF_TO_VERSION}" > /dev/termination-log
+    ;;
+  HealthCheck)
+    kubectl wait --for=condition=Available deployments --all -n kubeflow --timeout=15m \
+      || fail "deployments did not become available after the upgrade"
+    echo -n "Kubeflow ${KF_TO_VERSION} is healthy" > /dev/termination-log
+    ;;
+  Rollback)
+    [ -f ${BACKUP_DIR}/kfdef.yaml ] || fail "no backup of Kubeflow ${KF_FROM_VERSION} in ${BACKUP_DIR}"
+    rm -rf ${APP_DIR}
+    if [ -d ${BACKUP_DIR}/kf-app ]; then
+      cp -r ${BACKUP_DIR}/kf-app ${APP_DIR}
+    else
+      mkdir -p ${APP_DIR}
+      cp ${BACKUP_DIR}/kfdef.yaml ${APP_DIR}/kfctl.yaml
+    fi
+    cd ${APP_DIR}
+    kfctl apply -V -f kfctl.yaml || fail "kfctl apply of Kubeflow ${KF_FROM_VERSION} failed"
+    kubectl wait --for=condition=Available deployments --all -n kubeflow --timeout=15m \
+      || fail "deployments did not become available after the rollback"
+    echo -n "rolled back to ${KF_FROM_VERSION}" > /dev/termination-log
+    ;;
+  *)
+    fail "unknown upgrade phase ${PHASE}"
+    ;;
+esac
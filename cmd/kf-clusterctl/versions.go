@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/CiscoAI/kf-cluster-api/pkg/kubeflow/compatibility"
+)
+
+// printVersions writes the compatibility matrix: the Kubernetes range of every Kubeflow release,
+// or the applications of one release when kfVersion is set
+func printVersions(out io.Writer, kfVersion string) error {
+	if kfVersion != "" {
+		release, err := compatibility.FindRelease(kfVersion)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Kubeflow %s supports Kubernetes %s to %s\n", release.Version, release.MinKubernetes, release.MaxKubernetes)
+		fmt.Fprintf(out, "Apps:\n  %s\n", strings.Join(release.Apps, "\n  "))
+		return nil
+	}
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "KUBEFLOW\tKUBERNETES\tAPPS\n")
+	for _, release := range compatibility.Releases() {
+		fmt.Fprintf(w, "%s\t%s - %s\t%d\n", release.Version, release.MinKubernetes, release.MaxKubernetes, len(release.Apps))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "\nCompatibility matrix %s; run 'kf-clusterctl versions <kf_version>' to list the apps of a release.\n", compatibility.MatrixVersion)
+	return nil
+}
@@ -21,31 +21,57 @@ import (
 
 	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
 	"github.com/CiscoAI/kf-cluster-api/pkg/kubernetes"
+	"github.com/CiscoAI/kf-cluster-api/pkg/provider"
 	"github.com/go-logr/logr"
-	reconcilehelper "github.com/kubeflow/kubeflow/components/common/reconcilehelper"
-	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 // KfClusterReconciler reconciles a KfCluster object
 type KfClusterReconciler struct {
 	client.Client
-	Log    logr.Logger     `json:"log,omitempty"`
-	Scheme *runtime.Scheme `json:"scheme,omitempty"`
+	Log         logr.Logger                  `json:"log,omitempty"`
+	Scheme      *runtime.Scheme              `json:"scheme,omitempty"`
+	Provisioner kubernetes.ProvisionerConfig `json:"provisioner,omitempty"`
+	Recorder    record.EventRecorder         `json:"-"`
+	// Platforms restricts the platforms the reconciler provisions; empty enables every registered platform
+	Platforms []cluster.KfPlatform `json:"platforms,omitempty"`
+	// MaxConcurrentReconciles is the number of KfClusters reconciled in parallel; defaults to 1.
+	// The work queue never hands a KfCluster to two workers at once.
+	MaxConcurrentReconciles int `json:"maxConcurrentReconciles,omitempty"`
+	// MaxRetries is the number of failed attempts in a row after which a KfCluster is Failed; 0 retries forever
+	MaxRetries int `json:"maxRetries,omitempty"`
 }
 
 // +kubebuilder:rbac:groups=cluster.kubeflow.org,resources=kfclusters,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=cluster.kubeflow.org,resources=kfclusters/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch
+// +kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=get;list;watch;create;update;patch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;patch;delete
+// +kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=storage.k8s.io,resources=storageclasses,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
-// Reconcile - reconciles the KfCluster object
+// Reconcile - reconciles the KfCluster object.
+// Reconciles never wait on the platform: long-running operations are started and then checked on when the KfCluster
+// is requeued, so that with several workers a slow KfCluster doesn't hold up the others.
 func (r *KfClusterReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx := context.Background()
 	log := r.Log.WithValues("kfcluster", req.NamespacedName)
-
 	kfCluster := &cluster.KfCluster{}
 	if err := r.Client.Get(ctx, req.NamespacedName, kfCluster); err != nil {
 		if apierrors.IsNotFound(err) {
@@ -55,128 +81,194 @@ func (r *KfClusterReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return ctrl.Result{}, err
 	}
 
-	// Provision cluster resources and get a kubernetes cluster
-	// Store kubeconfig in the associated PV
-	if kfCluster.Spec.Platform == cluster.KfGcp {
-		err := r.reconcileGcp(ctx, kfCluster, log)
-		if err != nil {
-			log.Info("Error reconciling KfCluster on GCP")
-			return ctrl.Result{}, err
-		}
-	} else if kfCluster.Spec.Platform == cluster.KfGeneric {
-		err := r.reconcileGeneric(ctx, kfCluster, log)
-		if err != nil {
-			log.Info("Error reconciling KfCluster on k8s")
+	deleting := !kfCluster.DeletionTimestamp.IsZero()
+	if !deleting {
+		if failed, err := r.checkFailed(ctx, kfCluster, log); err != nil || failed {
 			return ctrl.Result{}, err
 		}
 	}
-
-	// Use the kubeconfig stored in the associated PV
-	// Use the platform resources to install Kubeflow
-	err := r.reconcileKubeflow(ctx, kfCluster, log)
+	if deleting && !containsString(kfCluster.Finalizers, cluster.KfClusterFinalizer) {
+		return ctrl.Result{}, nil
+	}
+	if deleting && kfCluster.Annotations[cluster.OrphanAnnotation] == "true" {
+		log.Info("Releasing KfCluster without deleting its infrastructure on request")
+		r.Recorder.Eventf(kfCluster, corev1.EventTypeWarning, ReasonTeardownOrphaned,
+			"Left the %s infrastructure in place on request; it has to be deleted by hand", kfCluster.Spec.Platform)
+		return ctrl.Result{}, r.releaseFinalizer(ctx, kfCluster)
+	}
+	prov, err := r.getProvider(kfCluster)
 	if err != nil {
-		log.Error(err, "error installing Kubeflow")
+		log.Error(err, "unsupported platform", "platform", kfCluster.Spec.Platform)
+		r.Recorder.Event(kfCluster, corev1.EventTypeWarning, ReasonInvalidSpec, err.Error())
+		if deleting {
+			return ctrl.Result{}, r.giveUpTeardown(ctx, kfCluster, err, log)
+		}
+		return r.recordAttempt(ctx, kfCluster, ctrl.Result{}, provider.Terminal(err), log)
 	}
-	return ctrl.Result{}, nil
-}
-
-func (r *KfClusterReconciler) reconcileKubeflow(ctx context.Context, kfCluster *cluster.KfCluster, log logr.Logger) error {
-
-	return nil
+	if deleting {
+		return r.teardown(ctx, prov, kfCluster, log)
+	}
+	result, err := r.reconcileCluster(ctx, prov, kfCluster, log)
+	return r.recordAttempt(ctx, kfCluster, result, err, log)
 }
 
-func (r *KfClusterReconciler) reconcileGcp(ctx context.Context, kfCluster *cluster.KfCluster, log logr.Logger) error {
-	log.Info("Reconciling KfCluster on GCP")
-	deployment, kfVolumeClaim := kubernetes.CreateDeployment(kfCluster)
-	if deployment == nil {
-		log.Info("Deploymeny spec wasn't generated")
-		return fmt.Errorf("error generating deployment spec")
-	}
-	if kfVolumeClaim == nil {
-		log.Info("VolumeClaim spec wasn't generated")
-		return fmt.Errorf("error generating volumeclaim spec")
+// reconcileCluster brings the infrastructure and then Kubeflow in line with the spec,
+// and requeues the KfCluster while either is in progress or when the provider asks to refresh it
+func (r *KfClusterReconciler) reconcileCluster(ctx context.Context, prov provider.Provider, kfCluster *cluster.KfCluster, log logr.Logger) (ctrl.Result, error) {
+	ready, refreshAfter, err := r.reconcileInfrastructure(ctx, prov, kfCluster, log)
+	if err != nil {
+		return ctrl.Result{}, err
 	}
-	if err := ctrl.SetControllerReference(kfCluster, deployment, r.Scheme); err != nil {
-		log.Info("unable to set controllereference for created deployment")
-		return err
+	if !ready {
+		if upgrade := kfCluster.Status.KubernetesUpgrade; upgrade != nil && upgrade.Phase == cluster.KubernetesUpgradeInProgress {
+			return ctrl.Result{RequeueAfter: kubernetesUpgradeRequeueInterval}, nil
+		}
+		return ctrl.Result{RequeueAfter: provisioningRequeueInterval}, nil
 	}
-	if err := ctrl.SetControllerReference(kfCluster, kfVolumeClaim, r.Scheme); err != nil {
-		log.Info("unable to set controllereference for created volumeclaim")
-		return err
+
+	// Upgrade Kubeflow on the cluster when spec.kf_version changes
+	upgraded, err := r.reconcileKubeflow(ctx, kfCluster, log)
+	if err != nil {
+		return ctrl.Result{}, err
 	}
-	justCreatedVolumeClaim := false
-	if err := r.Get(ctx, types.NamespacedName{Name: kfVolumeClaim.Name, Namespace: kfVolumeClaim.Namespace}, kfVolumeClaim); err != nil {
-		if apierrors.IsNotFound(err) {
-			log.Info("Creating volume claim for KfCluster")
-			if err := r.Create(ctx, kfVolumeClaim); err != nil {
-				log.Error(err, "Failed to create volume claim")
-				return err
-			}
-			justCreatedVolumeClaim = true
-		} else {
-			log.Error(err, "error getting volume claim")
-			return err
-		}
+	if !upgraded && (refreshAfter == 0 || refreshAfter > kubeflowUpgradeRequeueInterval) {
+		return ctrl.Result{RequeueAfter: kubeflowUpgradeRequeueInterval}, nil
 	}
-	if !justCreatedVolumeClaim {
-		log.Info("volume claim already exists, updating volume claim")
-		if err := r.Update(ctx, kfVolumeClaim); err != nil {
-			log.Error(err, "error upadting volume claim")
-			return err
+	return ctrl.Result{RequeueAfter: refreshAfter}, nil
+}
+
+// teardown deletes the infrastructure of a KfCluster being deleted and then releases its finalizer.
+// Failed attempts are retried with the backoff of the work queue until the error is terminal or MaxRetries
+// attempts failed in a row: then the TeardownFailed condition is set and the teardown waits for the retry
+// or orphan annotation, so that the KfCluster isn't stuck retrying an error that can't clear.
+func (r *KfClusterReconciler) teardown(ctx context.Context, prov provider.Provider, kfCluster *cluster.KfCluster, log logr.Logger) (ctrl.Result, error) {
+	if condition := kfCluster.Status.GetCondition(cluster.TeardownFailed); condition != nil && condition.Status == corev1.ConditionTrue {
+		if _, retry := kfCluster.Annotations[cluster.RetryAnnotation]; !retry {
+			return ctrl.Result{}, nil
 		}
-	}
-	// TODO(swiftdiaries): fix deployment reconcile logic; reconcile loop re-creates deployment
-	justCreatedDeployment := false
-	existingDeployment := &appsv1.Deployment{}
-	if err := r.Get(ctx, types.NamespacedName{Name: deployment.Name, Namespace: deployment.Namespace}, existingDeployment); err != nil {
-		if apierrors.IsNotFound(err) {
-			log.Info("Creating deployment for KfCluster")
-			if err := r.Create(ctx, deployment); err != nil {
-				log.Error(err, "error creating deployment")
-				return err
-			}
-			kfCluster.Status.KubeconfigPath = "/mnt/volume/" + kfCluster.Name + "/kubeconfig"
-			justCreatedDeployment = true
-		} else {
-			log.Error(err, "error getting deployment")
-			return err
+		log.Info("Retrying failed teardown", "reason", "a retry was requested")
+		r.Recorder.Event(kfCluster, corev1.EventTypeNormal, ReasonRetrying, "Retrying the teardown: a retry was requested")
+		delete(kfCluster.Annotations, cluster.RetryAnnotation)
+		kfCluster.Status.SetCondition(cluster.TeardownFailed, corev1.ConditionFalse, "Retrying", "a retry was requested")
+		kfCluster.Status.RetryCount = 0
+		if err := r.Update(ctx, kfCluster); err != nil {
+			return ctrl.Result{}, err
 		}
 	}
-	if !justCreatedDeployment && reconcilehelper.CopyDeploymentSetFields(deployment, existingDeployment) {
-		log.Info("deployment already exists, update deployment")
-		if err := r.Update(ctx, existingDeployment); err != nil {
-			log.Error(err, "error upadting deployment")
-			return err
+	if condition := kfCluster.Status.GetCondition(cluster.InfrastructureReady); condition == nil || condition.Reason != "Deleting" {
+		r.Recorder.Eventf(kfCluster, corev1.EventTypeNormal, ReasonTeardownStarted, "Deleting the %s infrastructure", kfCluster.Spec.Platform)
+		kfCluster.Status.SetCondition(cluster.InfrastructureReady, corev1.ConditionFalse, "Deleting", "the infrastructure is being deleted")
+		kfCluster.Status.Phase = cluster.PhaseDeleting
+		// Failures are counted afresh for the teardown
+		kfCluster.Status.RetryCount = 0
+		if err := r.Update(ctx, kfCluster); err != nil {
+			return ctrl.Result{}, err
 		}
 	}
-
-	if len(deployment.Status.Conditions) > 0 {
-		clusterCondition := cluster.KfClusterCondition{
-			State: &deployment.Status.Conditions[0].Type,
-			Ready: deployment.Status.Conditions[0].Type == appsv1.DeploymentAvailable,
-		}
-		clusterConditionLen := len(kfCluster.Status.Conditions)
-		if clusterConditionLen == 0 || kfCluster.Status.Conditions[clusterConditionLen-1].State != clusterCondition.State {
-			kfCluster.Status.Conditions = append(kfCluster.Status.Conditions, clusterCondition)
+	deleted, err := prov.Teardown(ctx, kfCluster)
+	if err != nil {
+		log.Info("Error tearing down KfCluster", "attempt", kfCluster.Status.RetryCount+1, "error", err.Error())
+		r.Recorder.Event(kfCluster, corev1.EventTypeWarning, ReasonTeardownFailed, err.Error())
+		countFailure(kfCluster, ReasonTeardownFailed)
+		kfCluster.Status.RetryCount++
+		kfCluster.Status.LastError = err.Error()
+		if provider.IsTerminal(err) || (r.MaxRetries > 0 && kfCluster.Status.RetryCount >= r.MaxRetries) {
+			return ctrl.Result{}, r.giveUpTeardown(ctx, kfCluster, err, log)
 		}
-		log.Info("cluster condition", clusterCondition)
-		err := r.Update(ctx, kfCluster)
-		if err != nil {
-			return err
+		if updateErr := r.Update(ctx, kfCluster); updateErr != nil {
+			return ctrl.Result{}, updateErr
 		}
+		return ctrl.Result{}, err
 	}
-	return nil
+	if !deleted {
+		return ctrl.Result{RequeueAfter: teardownRequeueInterval}, nil
+	}
+	r.Recorder.Eventf(kfCluster, corev1.EventTypeNormal, ReasonTeardownComplete, "Deleted the %s infrastructure", kfCluster.Spec.Platform)
+	return ctrl.Result{}, r.releaseFinalizer(ctx, kfCluster)
 }
 
-func (r *KfClusterReconciler) reconcileGeneric(ctx context.Context, kfCLuster *cluster.KfCluster, log logr.Logger) error {
-	log.Info("Reconciling KfCluster on k8s")
-	return nil
+// giveUpTeardown sets the TeardownFailed condition, after which the KfCluster waits for the retry or orphan annotation
+func (r *KfClusterReconciler) giveUpTeardown(ctx context.Context, kfCluster *cluster.KfCluster, err error, log logr.Logger) error {
+	message := fmt.Sprintf("giving up deleting the infrastructure: %v; set the %s annotation to retry, "+
+		"or %s to \"true\" to release the KfCluster and delete the infrastructure by hand", err, cluster.RetryAnnotation, cluster.OrphanAnnotation)
+	log.Error(err, "KfCluster teardown failed", "attempts", kfCluster.Status.RetryCount)
+	r.Recorder.Event(kfCluster, corev1.EventTypeWarning, ReasonFailed, message)
+	kfCluster.Status.SetCondition(cluster.TeardownFailed, corev1.ConditionTrue, ReasonTeardownFailed, message)
+	return r.Update(ctx, kfCluster)
+}
+
+// releaseFinalizer lets the deletion of a KfCluster complete
+func (r *KfClusterReconciler) releaseFinalizer(ctx context.Context, kfCluster *cluster.KfCluster) error {
+	kfCluster.Finalizers = removeString(kfCluster.Finalizers, cluster.KfClusterFinalizer)
+	return r.Update(ctx, kfCluster)
+}
+
+const (
+	// secretNamesIndex indexes KfClusters by the names of the Secrets they inject
+	secretNamesIndex = "spec.secretNames"
+	// configMapNameIndex indexes KfClusters by the ConfigMap overriding their config
+	configMapNameIndex = "spec.config_map_name"
+)
+
+// kfClustersForSecret maps a Secret to the KfClusters in its namespace that inject it.
+// Secrets no KfCluster references map to nothing, through the index rather than a List of every KfCluster.
+func (r *KfClusterReconciler) kfClustersForSecret(obj handler.MapObject) []reconcile.Request {
+	return r.kfClustersReferencing(secretNamesIndex, obj)
+}
+
+// kfClustersForConfigMap maps a ConfigMap to the KfClusters in its namespace whose config it overrides
+func (r *KfClusterReconciler) kfClustersForConfigMap(obj handler.MapObject) []reconcile.Request {
+	return r.kfClustersReferencing(configMapNameIndex, obj)
+}
+
+// kfClustersReferencing returns requests for the KfClusters in the namespace of obj that index references by name
+func (r *KfClusterReconciler) kfClustersReferencing(index string, obj handler.MapObject) []reconcile.Request {
+	kfClusters := &cluster.KfClusterList{}
+	err := r.List(context.Background(), kfClusters,
+		client.InNamespace(obj.Meta.GetNamespace()), client.MatchingField(index, obj.Meta.GetName()))
+	if err != nil {
+		r.Log.Error(err, "error listing KfClusters", "index", index, "name", obj.Meta.GetName())
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, kfCluster := range kfClusters.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: kfCluster.Name, Namespace: kfCluster.Namespace},
+		})
+	}
+	return requests
 }
 
 // SetupWithManager registers the controller reconciler logic with the manager binary
 func (r *KfClusterReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	err := ctrl.NewControllerManagedBy(mgr).
+	if err := metrics.Registry.Register(&clusterCollector{client: mgr.GetClient(), log: r.Log}); err != nil {
+		return err
+	}
+	err := mgr.GetFieldIndexer().IndexField(&cluster.KfCluster{}, secretNamesIndex, func(obj runtime.Object) []string {
+		return kubernetes.SecretNames(obj.(*cluster.KfCluster))
+	})
+	if err != nil {
+		return err
+	}
+	err = mgr.GetFieldIndexer().IndexField(&cluster.KfCluster{}, configMapNameIndex, func(obj runtime.Object) []string {
+		if name := obj.(*cluster.KfCluster).Spec.ConfigMapName; name != "" {
+			return []string{name}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = ctrl.NewControllerManagedBy(mgr).
 		For(&cluster.KfCluster{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Owns(&batchv1.Job{}).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.kfClustersForSecret),
+		}).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.kfClustersForConfigMap),
+		}).
 		Complete(r)
 	if err != nil {
 		return err
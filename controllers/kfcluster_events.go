@@ -0,0 +1,54 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+// Reasons of the events recorded on KfClusters.
+// Alerting keys on them, so they don't change once released; Warning events are marked as such.
+const (
+	// ReasonInvalidSpec is a Warning: the spec can't be acted on, such as an unregistered platform
+	ReasonInvalidSpec = "InvalidSpec"
+	// ReasonProvisioning reports that the controller started creating the infrastructure
+	ReasonProvisioning = "Provisioning"
+	// ReasonProvisioningFailed is a Warning: the provider returned an error; it is retried unless the KfCluster fails
+	ReasonProvisioningFailed = "ProvisioningFailed"
+	// ReasonInfrastructureReady reports that the cluster became ready
+	ReasonInfrastructureReady = "InfrastructureReady"
+	// ReasonKubeconfigPublished reports that the kubeconfig Secret was created
+	ReasonKubeconfigPublished = "KubeconfigPublished"
+	// ReasonKubernetesUpgradeStarted and ReasonKubernetesUpgradeSucceeded bracket a Kubernetes upgrade
+	ReasonKubernetesUpgradeStarted   = "KubernetesUpgradeStarted"
+	ReasonKubernetesUpgradeSucceeded = "KubernetesUpgradeSucceeded"
+	// ReasonKubeflowInstalled reports the Kubeflow version first found on the cluster
+	ReasonKubeflowInstalled = "KubeflowInstalled"
+	// ReasonKubeflowUpgradeStarted, ReasonKubeflowUpgradeSucceeded and ReasonKubeflowUpgradeFailed (a Warning)
+	// bracket a Kubeflow upgrade
+	ReasonKubeflowUpgradeStarted   = "KubeflowUpgradeStarted"
+	ReasonKubeflowUpgradeSucceeded = "KubeflowUpgradeSucceeded"
+	ReasonKubeflowUpgradeFailed    = "KubeflowUpgradeFailed"
+	// ReasonKubeflowRollbackStarted is a Warning when a failed upgrade phase triggered it
+	ReasonKubeflowRollbackStarted = "KubeflowRollbackStarted"
+	ReasonKubeflowRolledBack      = "KubeflowRolledBack"
+	// ReasonTeardownStarted, ReasonTeardownFailed (a Warning) and ReasonTeardownComplete report on deletion
+	ReasonTeardownStarted  = "TeardownStarted"
+	ReasonTeardownFailed   = "TeardownFailed"
+	ReasonTeardownComplete = "TeardownComplete"
+	// ReasonTeardownOrphaned is a Warning: the KfCluster was released without deleting its infrastructure
+	ReasonTeardownOrphaned = "TeardownOrphaned"
+	// ReasonFailed is a Warning: the KfCluster is no longer retried after a terminal error or too many failed attempts
+	ReasonFailed = "Failed"
+	// ReasonRetrying reports that a Failed KfCluster is retried after its spec changed or a retry was requested
+	ReasonRetrying = "Retrying"
+)
@@ -0,0 +1,310 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	"github.com/CiscoAI/kf-cluster-api/pkg/kubeflow"
+	"github.com/CiscoAI/kf-cluster-api/pkg/kubernetes"
+	"github.com/CiscoAI/kf-cluster-api/pkg/provider"
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// maxUpgradeHistory is how many finished upgrades and rollbacks the status keeps
+const maxUpgradeHistory = 10
+
+// reconcileKubeflow keeps the installed Kubeflow in line with spec.kf_version and rolls back upgrades on request.
+// It returns true unless an upgrade is in progress; progress is recorded in the status whether or not it fails.
+func (r *KfClusterReconciler) reconcileKubeflow(ctx context.Context, kfCluster *cluster.KfCluster, log logr.Logger) (bool, error) {
+	previousStatus := kfCluster.Status.DeepCopy()
+	previousAnnotations := len(kfCluster.Annotations)
+	r.handleRollbackRequest(kfCluster, log)
+	done, err := r.upgradeKubeflow(ctx, kfCluster, log)
+	if !equality.Semantic.DeepEqual(previousStatus, &kfCluster.Status) || len(kfCluster.Annotations) != previousAnnotations {
+		if updateErr := r.Update(ctx, kfCluster); updateErr != nil {
+			return false, updateErr
+		}
+	}
+	return done, err
+}
+
+// handleRollbackRequest starts the rollback of the latest upgrade when the rollback annotation is set,
+// and removes the annotation. Requests without a finished upgrade to roll back are dropped.
+func (r *KfClusterReconciler) handleRollbackRequest(kfCluster *cluster.KfCluster, log logr.Logger) {
+	if _, ok := kfCluster.Annotations[cluster.RollbackAnnotation]; !ok {
+		return
+	}
+	delete(kfCluster.Annotations, cluster.RollbackAnnotation)
+	upgrade := kfCluster.Status.Upgrade
+	if upgrade == nil || !upgrade.CanRollBack() {
+		log.Info("Ignoring rollback request: there is no finished upgrade with a backup to restore")
+		return
+	}
+	log.Info("Rolling back Kubeflow upgrade on request", "from", upgrade.ToVersion, "to", upgrade.FromVersion)
+	r.startRollback(kfCluster, corev1.EventTypeNormal, "rollback requested")
+}
+
+// upgradeKubeflow starts an upgrade when spec.kf_version differs from the installed version,
+// and runs its phases one Job at a time
+func (r *KfClusterReconciler) upgradeKubeflow(ctx context.Context, kfCluster *cluster.KfCluster, log logr.Logger) (bool, error) {
+	upgrade := kfCluster.Status.Upgrade
+	if upgrade == nil || upgrade.IsFinished() {
+		installedVersion := kfCluster.Status.InstalledVersion
+		if err := r.detectKubeflow(ctx, kfCluster); err != nil {
+			return false, err
+		}
+		if installedVersion == "" && kfCluster.Status.InstalledVersion != "" {
+			r.Recorder.Eventf(kfCluster, corev1.EventTypeNormal, ReasonKubeflowInstalled, "Kubeflow %s is installed", kfCluster.Status.InstalledVersion)
+			timeToKubeflow.WithLabelValues(string(kfCluster.Spec.Platform)).Observe(secondsSinceCreation(kfCluster))
+		}
+		if !kubeflow.NeedsUpgrade(kfCluster) {
+			if kfCluster.Status.InstalledVersion != "" && (upgrade == nil || upgrade.Phase == cluster.UpgradeSucceeded) {
+				kfCluster.Status.SetCondition(cluster.KubeflowUpgraded, corev1.ConditionTrue, "UpToDate",
+					fmt.Sprintf("Kubeflow %s is installed", kfCluster.Status.InstalledVersion))
+			}
+			return true, nil
+		}
+		if err := r.deleteUpgradeJobs(ctx, kfCluster); err != nil {
+			return false, err
+		}
+		now := metav1.Now()
+		upgrade = &cluster.KubeflowUpgradeStatus{
+			FromVersion: kfCluster.Status.InstalledVersion,
+			ToVersion:   kfCluster.Spec.KfVersion,
+			Phase:       cluster.UpgradePreCheck,
+			StartTime:   &now,
+		}
+		kfCluster.Status.Upgrade = upgrade
+		log.Info("Upgrading Kubeflow", "from", upgrade.FromVersion, "to", upgrade.ToVersion)
+		r.Recorder.Eventf(kfCluster, corev1.EventTypeNormal, ReasonKubeflowUpgradeStarted,
+			"upgrading Kubeflow from %s to %s", upgrade.FromVersion, upgrade.ToVersion)
+		if err := kubeflow.PreUpgradeCheck(upgrade.FromVersion, upgrade.ToVersion); err != nil {
+			r.failUpgrade(kfCluster, err.Error())
+			return true, nil
+		}
+	}
+
+	job, volumeClaim, err := r.upgradeJob(ctx, kfCluster)
+	if err != nil {
+		return false, err
+	}
+	if _, err := provider.ApplyIfMissing(ctx, r.providerOptions(kfCluster), kfCluster, volumeClaim); err != nil {
+		return false, err
+	}
+	existing := &batchv1.Job{}
+	if err := r.Get(ctx, types.NamespacedName{Name: job.Name, Namespace: job.Namespace}, existing); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return false, err
+		}
+		stopped, err := provider.ProvisionerStopped(ctx, r.providerOptions(kfCluster), kfCluster)
+		if err != nil {
+			return false, err
+		}
+		if !stopped {
+			log.Info("Waiting for the provisioner to stop before upgrading Kubeflow")
+			return false, nil
+		}
+		log.Info("Starting Kubeflow upgrade phase", "phase", upgrade.Phase, "to", upgrade.ToVersion)
+		if err := provider.Apply(ctx, r.providerOptions(kfCluster), kfCluster, job); err != nil {
+			return false, err
+		}
+		if upgrade.Phase != cluster.UpgradeRollback {
+			kfCluster.Status.SetCondition(cluster.KubeflowUpgraded, corev1.ConditionFalse, "Upgrading",
+				fmt.Sprintf("upgrading Kubeflow to %s: %s", upgrade.ToVersion, upgrade.Phase))
+		}
+		return false, nil
+	}
+	if existing.Annotations[kubernetes.UpgradeVersionAnnotation] != upgrade.ToVersion {
+		// Left over from an upgrade to another version; it is recreated on the next reconcile
+		return false, r.Delete(ctx, existing, client.PropagationPolicy(metav1.DeletePropagationBackground))
+	}
+	finished := kubernetes.JobFinished(existing)
+	if finished == nil {
+		return false, nil
+	}
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(existing.Namespace), client.MatchingLabels{"job-name": existing.Name}); err != nil {
+		return false, err
+	}
+	message := strings.TrimSpace(kubernetes.JobTerminationMessage(pods.Items))
+	if finished.Type == batchv1.JobFailed {
+		log.Info("Kubeflow upgrade phase failed", "phase", upgrade.Phase, "message", message)
+		switch {
+		case upgrade.Phase == cluster.UpgradeRollback:
+			r.failUpgrade(kfCluster, fmt.Sprintf("rollback to %s failed: %s", upgrade.FromVersion, message))
+		case upgrade.BackupPath != "":
+			// Any phase after the backup may have left Kubeflow half upgraded
+			log.Info("Rolling back Kubeflow upgrade", "from", upgrade.ToVersion, "to", upgrade.FromVersion)
+			r.startRollback(kfCluster, corev1.EventTypeWarning, fmt.Sprintf("%s failed: %s", upgrade.Phase, message))
+			return false, nil
+		default:
+			r.failUpgrade(kfCluster, fmt.Sprintf("%s failed: %s", upgrade.Phase, message))
+		}
+		return true, nil
+	}
+	if upgrade.Phase == cluster.UpgradeRollback {
+		kfCluster.Status.InstalledVersion = upgrade.FromVersion
+		finishUpgrade(kfCluster, cluster.UpgradeRolledBack,
+			fmt.Sprintf("rolled back Kubeflow from %s to %s after: %s", upgrade.ToVersion, upgrade.FromVersion, upgrade.Message))
+		kfCluster.Status.SetCondition(cluster.KubeflowUpgraded, corev1.ConditionFalse, "RolledBack", upgrade.Message)
+		r.Recorder.Event(kfCluster, corev1.EventTypeNormal, ReasonKubeflowRolledBack, upgrade.Message)
+		log.Info("Kubeflow rolled back", "from", upgrade.ToVersion, "to", upgrade.FromVersion)
+		return true, nil
+	}
+	if upgrade.Phase == cluster.UpgradeBackup {
+		upgrade.BackupPath = message
+	}
+	upgrade.Phase = kubeflow.NextUpgradePhase(upgrade.Phase)
+	if upgrade.Phase != cluster.UpgradeSucceeded {
+		kfCluster.Status.SetCondition(cluster.KubeflowUpgraded, corev1.ConditionFalse, "Upgrading",
+			fmt.Sprintf("upgrading Kubeflow to %s: %s", upgrade.ToVersion, upgrade.Phase))
+		return false, nil
+	}
+	finishUpgrade(kfCluster, cluster.UpgradeSucceeded, fmt.Sprintf("upgraded Kubeflow from %s to %s", upgrade.FromVersion, upgrade.ToVersion))
+	kfCluster.Status.InstalledVersion = upgrade.ToVersion
+	kfCluster.Status.SetCondition(cluster.KubeflowUpgraded, corev1.ConditionTrue, "Upgraded", upgrade.Message)
+	r.Recorder.Event(kfCluster, corev1.EventTypeNormal, ReasonKubeflowUpgradeSucceeded, upgrade.Message)
+	log.Info("Kubeflow upgraded", "from", upgrade.FromVersion, "to", upgrade.ToVersion)
+	return true, nil
+}
+
+// upgradeJob builds the Job of the current upgrade phase
+func (r *KfClusterReconciler) upgradeJob(ctx context.Context, kfCluster *cluster.KfCluster) (*batchv1.Job, *corev1.PersistentVolumeClaim, error) {
+	configOverrides, err := provider.ConfigOverrides(ctx, r.providerOptions(kfCluster), kfCluster)
+	if err != nil {
+		return nil, nil, err
+	}
+	storageClasses := &storagev1.StorageClassList{}
+	if err := r.List(ctx, storageClasses); err != nil {
+		return nil, nil, err
+	}
+	volumeConfig, _ := kubernetes.ResolveVolumeConfig(kfCluster, storageClasses.Items)
+	job, volumeClaim := kubernetes.CreateUpgradeJob(kfCluster, kubernetes.DeploymentOptions{
+		ConfigOverrides: configOverrides,
+		Provisioner:     r.Provisioner,
+		Volume:          volumeConfig,
+	}, kfCluster.Status.Upgrade.Phase)
+	return job, volumeClaim, nil
+}
+
+// deleteUpgradeJobs removes the Jobs of an earlier upgrade so that their names can be reused
+func (r *KfClusterReconciler) deleteUpgradeJobs(ctx context.Context, kfCluster *cluster.KfCluster) error {
+	for _, phase := range append(kubeflow.UpgradePhases(), cluster.UpgradeRollback) {
+		job := &batchv1.Job{}
+		job.Name = kubernetes.UpgradeJobName(kfCluster, phase)
+		job.Namespace = kfCluster.Namespace
+		if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// detectKubeflow records the Kubeflow installed on the cluster.
+// Clusters the controller can reach are inspected; clusters whose kubeconfig stays on the provisioner
+// volume are assumed to run spec.kf_version once the provisioner reports the install complete.
+func (r *KfClusterReconciler) detectKubeflow(ctx context.Context, kfCluster *cluster.KfCluster) error {
+	if kfCluster.Status.KubeconfigSecret == "" {
+		if kfCluster.Status.InstalledVersion == "" && kfCluster.Status.KubeconfigPath != "" && !kfCluster.IsAdopted() &&
+			kfCluster.Spec.KfVersion != cluster.LatestKfVersion {
+			installed, err := r.provisionerInstalled(ctx, kfCluster)
+			if err != nil {
+				return err
+			}
+			if installed {
+				kfCluster.Status.InstalledVersion = kfCluster.Spec.KfVersion
+			}
+		}
+		return nil
+	}
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Name: kfCluster.Status.KubeconfigSecret, Namespace: kfCluster.Namespace}, secret); err != nil {
+		return fmt.Errorf("unable to read kubeconfig: %v", err)
+	}
+	installation, err := kubeflow.DetectInstallation(secret.Data[kubeconfigSecretKey])
+	if err != nil {
+		return fmt.Errorf("unable to detect Kubeflow: %v", err)
+	}
+	if installation != nil {
+		kfCluster.Status.InstalledVersion = installation.Version
+		kfCluster.Status.InstalledApps = installation.Apps
+	}
+	return nil
+}
+
+// provisionerInstalled reports whether the provisioner has installed Kubeflow: its pod only turns ready
+// once kfctl apply has succeeded
+func (r *KfClusterReconciler) provisionerInstalled(ctx context.Context, kfCluster *cluster.KfCluster) (bool, error) {
+	deployment := &appsv1.Deployment{}
+	if err := r.Get(ctx, types.NamespacedName{Name: kfCluster.Name, Namespace: kfCluster.Namespace}, deployment); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return deployment.Status.ReadyReplicas > 0, nil
+}
+
+// failUpgrade records a failed upgrade; it is retried once spec.kf_version changes
+func (r *KfClusterReconciler) failUpgrade(kfCluster *cluster.KfCluster, message string) {
+	finishUpgrade(kfCluster, cluster.UpgradeFailed, message)
+	kfCluster.Status.SetCondition(cluster.KubeflowUpgraded, corev1.ConditionFalse, "UpgradeFailed", message)
+	r.Recorder.Event(kfCluster, corev1.EventTypeWarning, ReasonKubeflowUpgradeFailed, message)
+	countFailure(kfCluster, ReasonKubeflowUpgradeFailed)
+}
+
+// startRollback moves the upgrade in the status to the phase restoring its backup.
+// eventType is Warning when the rollback answers a failure rather than a request.
+func (r *KfClusterReconciler) startRollback(kfCluster *cluster.KfCluster, eventType string, reason string) {
+	upgrade := kfCluster.Status.Upgrade
+	upgrade.Phase = cluster.UpgradeRollback
+	upgrade.Message = reason
+	upgrade.CompletionTime = nil
+	message := fmt.Sprintf("rolling back Kubeflow to %s: %s", upgrade.FromVersion, reason)
+	kfCluster.Status.SetCondition(cluster.KubeflowUpgraded, corev1.ConditionFalse, "RollingBack", message)
+	r.Recorder.Event(kfCluster, eventType, ReasonKubeflowRollbackStarted, message)
+}
+
+// finishUpgrade ends the upgrade in the status in a final phase and records it in the upgrade history
+func finishUpgrade(kfCluster *cluster.KfCluster, phase cluster.KubeflowUpgradePhase, message string) {
+	now := metav1.Now()
+	upgrade := kfCluster.Status.Upgrade
+	upgrade.Phase = phase
+	upgrade.Message = message
+	upgrade.CompletionTime = &now
+	if upgrade.StartTime != nil {
+		upgradeDuration.WithLabelValues(string(kfCluster.Spec.Platform), componentKubeflow, string(phase)).
+			Observe(now.Sub(upgrade.StartTime.Time).Seconds())
+	}
+	history := append(kfCluster.Status.UpgradeHistory, *upgrade.DeepCopy())
+	if len(history) > maxUpgradeHistory {
+		history = history[len(history)-maxUpgradeHistory:]
+	}
+	kfCluster.Status.UpgradeHistory = history
+}
@@ -0,0 +1,131 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// provisioningBuckets span 30 seconds to about 4 hours, the range clusters and Kubeflow take to come up
+var provisioningBuckets = prometheus.ExponentialBuckets(30, 2, 10)
+
+var (
+	timeToInfrastructure = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kfcluster_time_to_infrastructure_seconds",
+		Help:    "Time from the creation of a KfCluster until its infrastructure first became ready",
+		Buckets: provisioningBuckets,
+	}, []string{"platform"})
+	timeToKubeflow = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kfcluster_time_to_kubeflow_seconds",
+		Help:    "Time from the creation of a KfCluster until Kubeflow was first found installed on it",
+		Buckets: provisioningBuckets,
+	}, []string{"platform"})
+	upgradeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kfcluster_upgrade_duration_seconds",
+		Help:    "Duration of finished Kubeflow and Kubernetes upgrades",
+		Buckets: provisioningBuckets,
+	}, []string{"platform", "component", "result"})
+	provisioningFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kfcluster_provisioning_failures_total",
+		Help: "Errors provisioning, upgrading or tearing down KfClusters, by event reason and failed stage",
+	}, []string{"platform", "reason", "stage"})
+)
+
+// Values of the component label of kfcluster_upgrade_duration_seconds
+const (
+	componentKubeflow   = "kubeflow"
+	componentKubernetes = "kubernetes"
+)
+
+func init() {
+	metrics.Registry.MustRegister(timeToInfrastructure, timeToKubeflow, upgradeDuration, provisioningFailures)
+}
+
+// clusterPhase summarizes the state of a KfCluster for its status and the kfcluster_clusters gauge
+func clusterPhase(kfCluster *cluster.KfCluster) cluster.KfClusterPhase {
+	if !kfCluster.DeletionTimestamp.IsZero() {
+		return cluster.PhaseDeleting
+	}
+	if kfCluster.Status.FailedSpecHash != "" {
+		return cluster.PhaseFailed
+	}
+	if condition := kfCluster.Status.GetCondition(cluster.InfrastructureReady); condition == nil || condition.Status != corev1.ConditionTrue {
+		return cluster.PhaseProvisioning
+	}
+	if upgrade := kfCluster.Status.Upgrade; upgrade != nil && !upgrade.IsFinished() {
+		return cluster.PhaseUpgrading
+	}
+	if upgrade := kfCluster.Status.KubernetesUpgrade; upgrade != nil && upgrade.Phase == cluster.KubernetesUpgradeInProgress {
+		return cluster.PhaseUpgrading
+	}
+	return cluster.PhaseReady
+}
+
+// clusterCollector reports the number of KfClusters per phase and platform, counted from the cache on every scrape
+type clusterCollector struct {
+	client client.Client
+	log    logr.Logger
+}
+
+var clustersDesc = prometheus.NewDesc("kfcluster_clusters", "Number of KfClusters per phase and platform", []string{"phase", "platform"}, nil)
+
+// Describe implements prometheus.Collector
+func (c *clusterCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- clustersDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *clusterCollector) Collect(ch chan<- prometheus.Metric) {
+	kfClusters := &cluster.KfClusterList{}
+	if err := c.client.List(context.Background(), kfClusters); err != nil {
+		c.log.Error(err, "error listing KfClusters for metrics")
+		return
+	}
+	type key struct{ phase, platform string }
+	counts := map[key]int{}
+	for i := range kfClusters.Items {
+		kfCluster := &kfClusters.Items[i]
+		counts[key{string(clusterPhase(kfCluster)), string(kfCluster.Spec.Platform)}]++
+	}
+	for k, count := range counts {
+		ch <- prometheus.MustNewConstMetric(clustersDesc, prometheus.GaugeValue, float64(count), k.phase, k.platform)
+	}
+}
+
+// secondsSinceCreation returns the age of a KfCluster in seconds
+func secondsSinceCreation(kfCluster *cluster.KfCluster) float64 {
+	return time.Since(kfCluster.CreationTimestamp.Time).Seconds()
+}
+
+// countFailure counts an error event of a KfCluster, attributing it to the stage that failed, if any
+func countFailure(kfCluster *cluster.KfCluster, reason string) {
+	stage := ""
+	for _, condition := range kfCluster.Status.Conditions {
+		if condition.Reason == "StageFailed" {
+			stage = string(condition.Type)
+			break
+		}
+	}
+	provisioningFailures.WithLabelValues(string(kfCluster.Spec.Platform), reason, stage).Inc()
+}
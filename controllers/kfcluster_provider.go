@@ -0,0 +1,242 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	"github.com/CiscoAI/kf-cluster-api/pkg/provider"
+	"github.com/CiscoAI/kf-cluster-api/pkg/provider/adopt"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// kubeconfigSecretKey is the key of the kubeconfig in the Secret published for a KfCluster
+	kubeconfigSecretKey = "kubeconfig"
+	// provisioningRequeueInterval is how often a cluster that isn't ready yet is checked on
+	provisioningRequeueInterval = 30 * time.Second
+	// kubernetesUpgradeRequeueInterval is how often a rolling Kubernetes upgrade is checked on
+	kubernetesUpgradeRequeueInterval = time.Minute
+	// kubeflowUpgradeRequeueInterval is how often a Kubeflow upgrade is checked on; its Jobs are watched,
+	// so this only bounds the delay of a missed event
+	kubeflowUpgradeRequeueInterval = 2 * time.Minute
+	// teardownRequeueInterval is how often the deletion of the infrastructure is checked on
+	teardownRequeueInterval = 15 * time.Second
+)
+
+// getProvider returns the provider registered for the KfCluster platform, or the adopt provider for adopted clusters
+func (r *KfClusterReconciler) getProvider(kfCluster *cluster.KfCluster) (provider.Provider, error) {
+	if len(r.Platforms) > 0 && !containsPlatform(r.Platforms, kfCluster.Spec.Platform) {
+		return nil, fmt.Errorf("platform %q is not enabled in this controller", kfCluster.Spec.Platform)
+	}
+	opts := r.providerOptions(kfCluster)
+	if kfCluster.IsAdopted() {
+		return adopt.New(opts), nil
+	}
+	return provider.New(kfCluster.Spec.Platform, opts)
+}
+
+// providerOptions returns what the controller shares with the provider of a KfCluster
+func (r *KfClusterReconciler) providerOptions(kfCluster *cluster.KfCluster) provider.Options {
+	return provider.Options{
+		Client:      r.Client,
+		Scheme:      r.Scheme,
+		Log:         r.Log.WithName(string(kfCluster.Spec.Platform)),
+		Provisioner: r.Provisioner,
+	}
+}
+
+// reconcileInfrastructure drives the provider until the cluster runs the spec and publishes its kubeconfig.
+// It returns true once the cluster is ready, along with how soon the provider asks to refresh it;
+// progress is recorded in the status whether or not it fails.
+func (r *KfClusterReconciler) reconcileInfrastructure(ctx context.Context, prov provider.Provider, kfCluster *cluster.KfCluster, log logr.Logger) (bool, time.Duration, error) {
+	log.Info("Reconciling KfCluster infrastructure", "platform", kfCluster.Spec.Platform)
+	if !containsString(kfCluster.Finalizers, cluster.KfClusterFinalizer) {
+		kfCluster.Finalizers = append(kfCluster.Finalizers, cluster.KfClusterFinalizer)
+		if err := r.Update(ctx, kfCluster); err != nil {
+			return false, 0, err
+		}
+		r.Recorder.Eventf(kfCluster, corev1.EventTypeNormal, ReasonProvisioning, "Creating the %s infrastructure", kfCluster.Spec.Platform)
+	}
+	previousStatus := kfCluster.Status.DeepCopy()
+	var refreshAfter time.Duration
+	r.startKubernetesUpgrade(kfCluster, log)
+	ready, err := r.runProvider(ctx, prov, kfCluster)
+	if err != nil {
+		log.Error(err, "error reconciling KfCluster infrastructure")
+		r.Recorder.Event(kfCluster, corev1.EventTypeWarning, ReasonProvisioningFailed, err.Error())
+		countFailure(kfCluster, ReasonProvisioningFailed)
+		kfCluster.Status.SetCondition(cluster.InfrastructureReady, corev1.ConditionFalse, "ProviderError", err.Error())
+		if upgrade := kfCluster.Status.KubernetesUpgrade; upgrade != nil && upgrade.Phase == cluster.KubernetesUpgradeInProgress {
+			upgrade.Message = err.Error()
+		}
+	} else if status, statusErr := prov.Status(ctx, kfCluster); statusErr != nil {
+		err = statusErr
+	} else {
+		conditionStatus := corev1.ConditionFalse
+		if status.Ready {
+			conditionStatus = corev1.ConditionTrue
+		}
+		kfCluster.Status.SetCondition(cluster.InfrastructureReady, conditionStatus, status.Reason, status.Message)
+		if previous := previousStatus.GetCondition(cluster.InfrastructureReady); status.Ready && (previous == nil || previous.Status != corev1.ConditionTrue) {
+			r.Recorder.Event(kfCluster, corev1.EventTypeNormal, ReasonInfrastructureReady, status.Message)
+		}
+		if status.Ready && kfCluster.Status.ProvisionedTime == nil {
+			now := metav1.Now()
+			kfCluster.Status.ProvisionedTime = &now
+			timeToInfrastructure.WithLabelValues(string(kfCluster.Spec.Platform)).Observe(secondsSinceCreation(kfCluster))
+		}
+		if ready {
+			r.finishKubernetesUpgrade(kfCluster, log)
+			refreshAfter = status.RefreshAfter
+		}
+	}
+	if !equality.Semantic.DeepEqual(previousStatus, &kfCluster.Status) {
+		if updateErr := r.Update(ctx, kfCluster); updateErr != nil {
+			return false, 0, updateErr
+		}
+	}
+	return ready, refreshAfter, err
+}
+
+// startKubernetesUpgrade records an upgrade when the spec asks for another Kubernetes version than the cluster runs.
+// Providers roll out the upgrade in progress from Upgrade.
+func (r *KfClusterReconciler) startKubernetesUpgrade(kfCluster *cluster.KfCluster, log logr.Logger) {
+	desired, current := kfCluster.DesiredKubernetesVersion(), kfCluster.Status.KubernetesVersion
+	if desired == "" || current == "" || desired == current {
+		return
+	}
+	upgrade := kfCluster.Status.KubernetesUpgrade
+	if upgrade != nil && upgrade.Phase == cluster.KubernetesUpgradeInProgress && upgrade.ToVersion == desired {
+		return
+	}
+	log.Info("Starting Kubernetes upgrade", "from", current, "to", desired)
+	now := metav1.Now()
+	kfCluster.Status.KubernetesUpgrade = &cluster.KubernetesUpgradeStatus{
+		FromVersion: current,
+		ToVersion:   desired,
+		Phase:       cluster.KubernetesUpgradeInProgress,
+		StartTime:   &now,
+	}
+	message := fmt.Sprintf("upgrading Kubernetes from %s to %s", current, desired)
+	kfCluster.Status.SetCondition(cluster.KubernetesUpgraded, corev1.ConditionFalse, "Upgrading", message)
+	r.Recorder.Event(kfCluster, corev1.EventTypeNormal, ReasonKubernetesUpgradeStarted, message)
+}
+
+// finishKubernetesUpgrade records the Kubernetes version of a ready cluster and completes the upgrade in progress
+func (r *KfClusterReconciler) finishKubernetesUpgrade(kfCluster *cluster.KfCluster, log logr.Logger) {
+	desired := kfCluster.DesiredKubernetesVersion()
+	if desired == "" {
+		return
+	}
+	if upgrade := kfCluster.Status.KubernetesUpgrade; upgrade != nil && upgrade.Phase == cluster.KubernetesUpgradeInProgress {
+		log.Info("Kubernetes upgrade succeeded", "version", upgrade.ToVersion)
+		now := metav1.Now()
+		upgrade.Phase = cluster.KubernetesUpgradeSucceeded
+		upgrade.Message = ""
+		upgrade.CompletionTime = &now
+		r.Recorder.Eventf(kfCluster, corev1.EventTypeNormal, ReasonKubernetesUpgradeSucceeded,
+			"upgraded Kubernetes from %s to %s", upgrade.FromVersion, upgrade.ToVersion)
+		upgradeDuration.WithLabelValues(string(kfCluster.Spec.Platform), componentKubernetes, string(upgrade.Phase)).
+			Observe(upgrade.CompletionTime.Sub(upgrade.StartTime.Time).Seconds())
+	}
+	kfCluster.Status.KubernetesVersion = desired
+	kfCluster.Status.SetCondition(cluster.KubernetesUpgraded, corev1.ConditionTrue, "UpToDate", "the cluster runs Kubernetes "+desired)
+}
+
+// runProvider ensures the infrastructure, upgrades it and publishes the kubeconfig, stopping at the first step in progress
+func (r *KfClusterReconciler) runProvider(ctx context.Context, prov provider.Provider, kfCluster *cluster.KfCluster) (bool, error) {
+	if ready, err := prov.EnsureInfrastructure(ctx, kfCluster); err != nil || !ready {
+		return false, err
+	}
+	if upgraded, err := prov.Upgrade(ctx, kfCluster); err != nil || !upgraded {
+		return false, err
+	}
+	kubeconfig, err := prov.GetKubeconfig(ctx, kfCluster)
+	if err != nil {
+		return false, err
+	}
+	if kubeconfig != nil {
+		if err := r.publishKubeconfig(ctx, kfCluster, kubeconfig); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// publishKubeconfig stores the kubeconfig of the provisioned cluster in a Secret and records it in the status
+func (r *KfClusterReconciler) publishKubeconfig(ctx context.Context, kfCluster *cluster.KfCluster, kubeconfig []byte) error {
+	key := types.NamespacedName{Name: kfCluster.Name + "-kubeconfig", Namespace: kfCluster.Namespace}
+	created := false
+	if err := r.Get(ctx, key, &corev1.Secret{}); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		created = true
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.Name,
+			Namespace: key.Namespace,
+			Labels:    map[string]string{"kfcluster": kfCluster.Name},
+		},
+		Data: map[string][]byte{kubeconfigSecretKey: kubeconfig},
+	}
+	if err := provider.Apply(ctx, r.providerOptions(kfCluster), kfCluster, secret); err != nil {
+		return err
+	}
+	if created {
+		r.Recorder.Eventf(kfCluster, corev1.EventTypeNormal, ReasonKubeconfigPublished, "Published the kubeconfig in Secret %s", key.Name)
+	}
+	kfCluster.Status.KubeconfigSecret = key.Name
+	return nil
+}
+
+func containsPlatform(platforms []cluster.KfPlatform, platform cluster.KfPlatform) bool {
+	for _, p := range platforms {
+		if p == platform {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(values []string, value string) []string {
+	result := []string{}
+	for _, v := range values {
+		if v != value {
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,106 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	"github.com/CiscoAI/kf-cluster-api/pkg/provider"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// checkFailed returns true for a Failed KfCluster, which isn't reconciled until its spec changes or the retry
+// annotation is set. Otherwise it clears the failure and removes the annotation.
+func (r *KfClusterReconciler) checkFailed(ctx context.Context, kfCluster *cluster.KfCluster, log logr.Logger) (bool, error) {
+	_, retry := kfCluster.Annotations[cluster.RetryAnnotation]
+	failed := kfCluster.Status.FailedSpecHash != ""
+	if failed && !retry && kfCluster.Status.FailedSpecHash == specHash(kfCluster) {
+		return true, nil
+	}
+	if !failed && !retry {
+		return false, nil
+	}
+	delete(kfCluster.Annotations, cluster.RetryAnnotation)
+	if failed {
+		reason := "the spec changed"
+		if retry {
+			reason = "a retry was requested"
+		}
+		log.Info("Retrying failed KfCluster", "reason", reason)
+		r.Recorder.Eventf(kfCluster, corev1.EventTypeNormal, ReasonRetrying, "Retrying the KfCluster: %s", reason)
+		kfCluster.Status.FailedSpecHash = ""
+		kfCluster.Status.RetryCount = 0
+		kfCluster.Status.Phase = clusterPhase(kfCluster)
+	}
+	return false, r.Update(ctx, kfCluster)
+}
+
+// recordAttempt records the outcome of a reconcile in the status. Failed attempts are counted and retried with
+// the backoff of the work queue, until the error is terminal or MaxRetries attempts failed in a row:
+// then the KfCluster is Failed and no longer requeued.
+func (r *KfClusterReconciler) recordAttempt(ctx context.Context, kfCluster *cluster.KfCluster, result ctrl.Result, err error, log logr.Logger) (ctrl.Result, error) {
+	if apierrors.IsConflict(err) {
+		// The KfCluster changed while it was reconciled, which says nothing about the cluster
+		return result, err
+	}
+	previousStatus := kfCluster.Status.DeepCopy()
+	if err == nil {
+		kfCluster.Status.RetryCount = 0
+		kfCluster.Status.LastError = ""
+	} else {
+		kfCluster.Status.RetryCount++
+		kfCluster.Status.LastError = err.Error()
+		terminal := provider.IsTerminal(err)
+		if terminal || (r.MaxRetries > 0 && kfCluster.Status.RetryCount >= r.MaxRetries) {
+			message := fmt.Sprintf("giving up after %d failed attempts: %v", kfCluster.Status.RetryCount, err)
+			if terminal {
+				message = fmt.Sprintf("giving up on an error retrying can't fix: %v", err)
+			}
+			log.Error(err, "KfCluster failed", "attempts", kfCluster.Status.RetryCount)
+			r.Recorder.Event(kfCluster, corev1.EventTypeWarning, ReasonFailed, message)
+			kfCluster.Status.FailedSpecHash = specHash(kfCluster)
+			result, err = ctrl.Result{}, nil
+		} else {
+			log.Info("Retrying KfCluster after error", "attempt", kfCluster.Status.RetryCount, "error", err.Error())
+		}
+	}
+	kfCluster.Status.Phase = clusterPhase(kfCluster)
+	if !equality.Semantic.DeepEqual(previousStatus, &kfCluster.Status) {
+		if updateErr := r.Update(ctx, kfCluster); updateErr != nil {
+			return ctrl.Result{}, updateErr
+		}
+	}
+	return result, err
+}
+
+// specHash identifies the spec a KfCluster failed on
+func specHash(kfCluster *cluster.KfCluster) string {
+	data, err := json.Marshal(kfCluster.Spec)
+	if err != nil {
+		return ""
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
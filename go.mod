@@ -3,19 +3,25 @@ module github.com/CiscoAI/kf-cluster-api
 go 1.13
 
 require (
+	cloud.google.com/go v0.38.0
 	github.com/cenkalti/backoff v2.2.1+incompatible
 	github.com/go-logr/logr v0.1.0
+	github.com/go-logr/zapr v0.1.1
 	github.com/kubeflow/kfctl/v3 v3.0.0-20200123233633-331fb9d02799
-	github.com/kubeflow/kubeflow/components/common v0.0.0-20200128174740-28fe3b22a4c7
 	github.com/onsi/ginkgo v1.8.0
 	github.com/onsi/gomega v1.5.0
+	github.com/prometheus/client_golang v0.9.2
 	github.com/sirupsen/logrus v1.4.2
 	github.com/spf13/cobra v0.0.5
+	go.uber.org/zap v1.12.0
+	golang.org/x/crypto v0.0.0
+	golang.org/x/oauth2 v0.0.0-20190604053449-0f29369cfe45
 	google.golang.org/api v0.10.0
 	k8s.io/api v0.0.0
 	k8s.io/apimachinery v0.0.0
 	k8s.io/client-go v11.0.1-0.20190409021438-1a26190bd76a+incompatible
 	sigs.k8s.io/controller-runtime v0.4.0
+	sigs.k8s.io/yaml v1.1.0
 )
 
 replace (
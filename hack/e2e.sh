@@ -0,0 +1,68 @@
+#!/bin/bash
+# End-to-end test of the kind platform. It needs docker, kind, kubectl and kustomize on the PATH.
+# A kind management cluster runs the CRDs and the provisioner pods, and the controller runs locally
+# against it. The KfCluster sample then creates a nested kind cluster and installs Kubeflow on it.
+
+set -euo pipefail
+
+E2E_CLUSTER=${E2E_CLUSTER:-kf-cluster-e2e}
+CLI_IMG=${CLI_IMG:-ciscoai/kf-clusterctl:e2e}
+KFCLUSTER=kf-kind
+TIMEOUT=${TIMEOUT:-1800}
+LOG_DIR=${LOG_DIR:-$(mktemp -d)}
+
+cleanup() {
+  kubectl delete -f config/samples/kind_kfcluster.yaml --ignore-not-found --wait=false || true
+  if [ -n "${CONTROLLER_PID:-}" ]; then
+    kill "${CONTROLLER_PID}" || true
+  fi
+  if [ -z "${KEEP_CLUSTER:-}" ]; then
+    kind delete cluster --name "${E2E_CLUSTER}"
+  fi
+  echo "Controller logs are in ${LOG_DIR}"
+}
+
+# wait_for runs a command until it succeeds or the timeout expires
+wait_for() {
+  local deadline=$((SECONDS + TIMEOUT))
+  until "$@" > /dev/null 2>&1; do
+    if [ ${SECONDS} -ge ${deadline} ]; then
+      echo "Timed out waiting for: $*"
+      return 1
+    fi
+    sleep 10
+  done
+}
+
+if ! kind get clusters | grep -qx "${E2E_CLUSTER}"; then
+  kind create cluster --name "${E2E_CLUSTER}" --wait 5m
+fi
+trap cleanup EXIT
+kubectl config use-context "kind-${E2E_CLUSTER}"
+
+# Build the provisioner image and side-load it, so the test needs no registry
+docker build -f cmd/kf-clusterctl/Dockerfile -t "${CLI_IMG}" .
+kind load docker-image "${CLI_IMG}" --name "${E2E_CLUSTER}"
+
+kustomize build config/crd | kubectl apply -f -
+ENABLE_WEBHOOKS=false go run ./main.go \
+  --metrics-addr=:0 \
+  --provisioner-image="${CLI_IMG}" \
+  --provisioner-image-pull-policy=Never > "${LOG_DIR}/controller.log" 2>&1 &
+CONTROLLER_PID=$!
+
+kubectl apply -f config/samples/kind_kfcluster.yaml
+echo "Waiting for the provisioner of ${KFCLUSTER}"
+wait_for kubectl wait --for=condition=Available "deployment/${KFCLUSTER}" --timeout=10s
+
+# The nested cluster is ready once its kubeconfig works and Kubeflow runs on it
+KUBECONFIG_PATH=/mnt/volume/${KFCLUSTER}/kubeconfig
+nested() {
+  kubectl exec "deployment/${KFCLUSTER}" -c "${KFCLUSTER}" -- kubectl --kubeconfig "${KUBECONFIG_PATH}" "$@"
+}
+echo "Waiting for the nodes of ${KFCLUSTER}"
+wait_for nested wait --for=condition=Ready nodes --all --timeout=10s
+echo "Waiting for Kubeflow on ${KFCLUSTER}"
+wait_for nested -n kubeflow wait --for=condition=Available deployment --all --timeout=10s
+nested -n kubeflow get pods
+echo "PASS"
@@ -0,0 +1,30 @@
+#!/bin/bash
+# Example out-of-tree provider serving an existing cluster.
+# Register it with --external-providers=example=hack/external/example-provider.sh;
+# the kubeconfig handed to the controller is read from $EXAMPLE_KUBECONFIG.
+set -euo pipefail
+
+API_VERSION=provider.cluster.kubeflow.org/v1alpha1
+
+request=$(cat)
+if [ "$(jq -r .apiVersion <<< "$request")" != "$API_VERSION" ]; then
+  echo "unsupported protocol version" >&2
+  exit 1
+fi
+
+name=$(jq -r .cluster.metadata.name <<< "$request")
+case "$(jq -r .operation <<< "$request")" in
+  create|upgrade|delete)
+    jq -n --arg v "$API_VERSION" --arg name "$name" '{apiVersion: $v, done: true,
+      conditions: [{type: "ExampleReady", status: "True", reason: "Adopted", message: ("cluster " + $name + " is served as is")}]}'
+    ;;
+  kubeconfig)
+    jq -n --arg v "$API_VERSION" --arg k "$(base64 -w0 < "${EXAMPLE_KUBECONFIG:?}")" '{apiVersion: $v, kubeconfig: $k}'
+    ;;
+  status)
+    jq -n --arg v "$API_VERSION" '{apiVersion: $v, status: {ready: true, reason: "Adopted", message: "the cluster exists"}}'
+    ;;
+  *)
+    jq -n --arg v "$API_VERSION" '{apiVersion: $v, error: "unsupported operation"}'
+    ;;
+esac
@@ -0,0 +1,34 @@
+#!/bin/bash
+# Starts containers running sshd to use as metal hosts, and creates the Secret with the SSH key
+# and the known_hosts lines for them.
+# Usage: hack/metal/hosts.sh [count]   Remove them with: docker rm -f $(docker ps -qf label=kfcluster-metal)
+
+set -euo pipefail
+
+COUNT=${1:-2}
+IMAGE=kfcluster-metal-host
+NAMESPACE=${NAMESPACE:-default}
+KEY_DIR=$(mktemp -d)
+
+docker build -t "${IMAGE}" hack/metal
+ssh-keygen -q -t rsa -b 4096 -N "" -f "${KEY_DIR}/id_rsa"
+
+HOSTS=()
+for i in $(seq 0 $((COUNT - 1))); do
+  NAME=kfcluster-metal-${i}
+  # kind node images need to be privileged and boot systemd
+  docker run -d --name "${NAME}" --hostname "${NAME}" --label kfcluster-metal --privileged \
+    --tmpfs /tmp --tmpfs /run -v /lib/modules:/lib/modules:ro "${IMAGE}" > /dev/null
+  docker cp "${KEY_DIR}/id_rsa.pub" "${NAME}:/root/.ssh/authorized_keys"
+  docker exec "${NAME}" chown root:root /root/.ssh/authorized_keys
+  ADDRESS=$(docker inspect -f '{{range .NetworkSettings.Networks}}{{.IPAddress}}{{end}}' "${NAME}")
+  HOSTS+=("${ADDRESS}")
+  docker exec "${NAME}" cat /etc/ssh/ssh_host_ed25519_key.pub | awk -v host="${ADDRESS}" '{print host, $1, $2}' \
+    >> "${KEY_DIR}/known_hosts"
+done
+
+kubectl -n "${NAMESPACE}" create secret generic metal-ssh-key \
+  --from-file=ssh-privatekey="${KEY_DIR}/id_rsa" --from-file=known_hosts="${KEY_DIR}/known_hosts" --dry-run -o yaml | kubectl apply -f -
+rm -rf "${KEY_DIR}"
+
+echo "Hosts for spec.metal.hosts: ${HOSTS[*]}"
@@ -17,15 +17,32 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"net"
 	"os"
+	"strconv"
+	"strings"
 
 	clusterv1alpha1 "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
 	"github.com/CiscoAI/kf-cluster-api/controllers"
+	"github.com/CiscoAI/kf-cluster-api/pkg/config"
+	"github.com/CiscoAI/kf-cluster-api/pkg/gcp"
+	"github.com/CiscoAI/kf-cluster-api/pkg/healthz"
+	"github.com/CiscoAI/kf-cluster-api/pkg/kubernetes"
+	"github.com/CiscoAI/kf-cluster-api/pkg/provider"
+	"github.com/CiscoAI/kf-cluster-api/pkg/provider/external"
+	_ "github.com/CiscoAI/kf-cluster-api/pkg/provider/gcp"
+	_ "github.com/CiscoAI/kf-cluster-api/pkg/provider/generic"
+	_ "github.com/CiscoAI/kf-cluster-api/pkg/provider/gke"
+	_ "github.com/CiscoAI/kf-cluster-api/pkg/provider/kind"
+	_ "github.com/CiscoAI/kf-cluster-api/pkg/provider/metal"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
-	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -42,46 +59,203 @@ func init() {
 }
 
 func main() {
-	var metricsAddr string
-	var enableLeaderElection bool
-	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
-	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
-		"Enable leader election for controller manager. Enabling this will ensure there is only one active controller manager.")
-	flag.Parse()
-
-	ctrl.SetLogger(zap.Logger(true))
-
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:             scheme,
-		MetricsBindAddress: metricsAddr,
-		LeaderElection:     enableLeaderElection,
-		Port:               9443,
-	})
+	cfg := &config.ManagerConfig{
+		MetricsAddr:             ":8080",
+		HealthProbeAddr:         ":8081",
+		WebhookPort:             9443,
+		LeaderElectionID:        "kfcluster-controller-leader-election",
+		MaxConcurrentReconciles: 4,
+		MaxRetries:              10,
+		LogLevel:                "info",
+		LogFormat:               config.LogFormatJSON,
+		Provisioner: config.ProvisionerConfig{
+			Image:           kubernetes.DefaultProvisionerImage(),
+			ImagePullPolicy: string(corev1.PullAlways),
+			CPURequest:      "100m",
+			MemoryRequest:   "256Mi",
+			CPULimit:        "1",
+			MemoryLimit:     "1Gi",
+		},
+	}
+	if err := cfg.Parse(flag.CommandLine, os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	ctrl.SetLogger(cfg.Logger())
+
+	provisionerConfig, err := newProvisionerConfig(cfg.Provisioner)
+	if err != nil {
+		setupLog.Error(err, "invalid provisioner configuration")
+		os.Exit(1)
+	}
+
+	providers, err := external.ParseProviders(cfg.ExternalProviders)
+	if err != nil {
+		setupLog.Error(err, "invalid external providers")
+		os.Exit(1)
+	}
+	for platform, path := range providers {
+		if err := external.Register(platform, path); err != nil {
+			setupLog.Error(err, "unable to register external provider", "platform", platform)
+			os.Exit(1)
+		}
+		setupLog.Info("registered external provider", "platform", platform, "path", path)
+	}
+
+	grants, err := clusterv1alpha1.ParseGCPServiceAccountGrants(cfg.GCPServiceAccounts)
+	if err != nil {
+		setupLog.Error(err, "invalid GCP service accounts")
+		os.Exit(1)
+	}
+	clusterv1alpha1.AllowGCPServiceAccounts(grants)
+
+	enabledPlatforms := parsePlatforms(cfg.Platforms)
+	for _, platform := range enabledPlatforms {
+		if !containsPlatform(provider.Platforms(), platform) {
+			setupLog.Error(fmt.Errorf("platform %q is not registered", platform), "invalid platforms")
+			os.Exit(1)
+		}
+	}
+
+	options := ctrl.Options{
+		Scheme:                  scheme,
+		MetricsBindAddress:      cfg.MetricsAddr,
+		LeaderElection:          cfg.LeaderElection,
+		LeaderElectionID:        cfg.LeaderElectionID,
+		LeaderElectionNamespace: cfg.LeaderElectionNS,
+		SyncPeriod:              cfg.SyncPeriodOrNil(),
+		Port:                    cfg.WebhookPort,
+	}
+	switch len(cfg.Namespaces) {
+	case 0:
+	case 1:
+		options.Namespace = cfg.Namespaces[0]
+	default:
+		options.NewCache = cache.MultiNamespacedCacheBuilder(cfg.Namespaces)
+	}
+	if len(cfg.Namespaces) > 0 {
+		setupLog.Info("watching namespaces", "namespaces", cfg.Namespaces)
+	}
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), options)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
+	if cfg.WebhookCertDir != "" {
+		mgr.GetWebhookServer().CertDir = cfg.WebhookCertDir
+	}
 
 	if err = (&controllers.KfClusterReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("KfCluster"),
-		Scheme: mgr.GetScheme(),
+		Client:      mgr.GetClient(),
+		Log:         ctrl.Log.WithName("controllers").WithName("KfCluster"),
+		Scheme:      mgr.GetScheme(),
+		Provisioner: provisionerConfig,
+		Recorder:    mgr.GetEventRecorderFor("kfcluster-controller"),
+		Platforms:   enabledPlatforms,
+
+		MaxConcurrentReconciles: cfg.MaxConcurrentReconciles,
+		MaxRetries:              cfg.MaxRetries,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "KfCluster")
 		os.Exit(1)
 	}
 
+	probes := &healthz.Server{Addr: cfg.HealthProbeAddr, Log: ctrl.Log.WithName("healthz")}
+	probes.AddLivenessCheck("ping", healthz.Ping)
+	cacheSynced := &healthz.CacheSynced{}
+	if err := mgr.Add(cacheSynced); err != nil {
+		setupLog.Error(err, "unable to add cache sync check")
+		os.Exit(1)
+	}
+	probes.AddReadinessCheck("cache-synced", cacheSynced.Check)
+
 	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
 		if err = (&clusterv1alpha1.KfCluster{}).SetupWebhookWithManager(mgr); err != nil {
 			setupLog.Error(err, "unable to create webhook", "webhook", "KfCluster")
 			os.Exit(1)
 		}
+		probes.AddReadinessCheck("webhook-server", healthz.TLSServing(net.JoinHostPort("127.0.0.1", strconv.Itoa(cfg.WebhookPort))))
+	}
+	if containsPlatform(enabledPlatforms, clusterv1alpha1.KfGcp) || containsPlatform(enabledPlatforms, clusterv1alpha1.KfGke) {
+		probes.AddReadinessCheck("gcp-credentials", healthz.Func(gcp.CheckDefaultCredentials))
 	}
 	// +kubebuilder:scaffold:builder
 
+	stop := ctrl.SetupSignalHandler()
+	go func() {
+		if err := probes.Start(stop); err != nil {
+			setupLog.Error(err, "problem running health probes")
+			os.Exit(1)
+		}
+	}()
+
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	if err := mgr.Start(stop); err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
 }
+
+// parsePlatforms parses a comma separated list of platforms
+func parsePlatforms(value string) []clusterv1alpha1.KfPlatform {
+	var platforms []clusterv1alpha1.KfPlatform
+	for _, platform := range strings.Split(value, ",") {
+		if platform = strings.TrimSpace(platform); platform != "" {
+			platforms = append(platforms, clusterv1alpha1.KfPlatform(platform))
+		}
+	}
+	return platforms
+}
+
+func containsPlatform(platforms []clusterv1alpha1.KfPlatform, platform clusterv1alpha1.KfPlatform) bool {
+	for _, p := range platforms {
+		if p == platform {
+			return true
+		}
+	}
+	return false
+}
+
+// newProvisionerConfig builds the controller-wide provisioner pod defaults from the manager configuration
+func newProvisionerConfig(c config.ProvisionerConfig) (kubernetes.ProvisionerConfig, error) {
+	provisioner := kubernetes.ProvisionerConfig{
+		Image:           c.Image,
+		ImagePullPolicy: corev1.PullPolicy(c.ImagePullPolicy),
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{},
+			Limits:   corev1.ResourceList{},
+		},
+	}
+	switch provisioner.ImagePullPolicy {
+	case corev1.PullAlways, corev1.PullNever, corev1.PullIfNotPresent:
+	default:
+		return provisioner, fmt.Errorf("invalid image pull policy %q", c.ImagePullPolicy)
+	}
+	for _, secret := range strings.Split(c.ImagePullSecrets, ",") {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			provisioner.ImagePullSecrets = append(provisioner.ImagePullSecrets, secret)
+		}
+	}
+	quantities := []struct {
+		list  corev1.ResourceList
+		name  corev1.ResourceName
+		value string
+	}{
+		{provisioner.Resources.Requests, corev1.ResourceCPU, c.CPURequest},
+		{provisioner.Resources.Requests, corev1.ResourceMemory, c.MemoryRequest},
+		{provisioner.Resources.Limits, corev1.ResourceCPU, c.CPULimit},
+		{provisioner.Resources.Limits, corev1.ResourceMemory, c.MemoryLimit},
+	}
+	for _, q := range quantities {
+		if q.value == "" {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(q.value)
+		if err != nil {
+			return provisioner, fmt.Errorf("invalid %s quantity %q: %v", q.name, q.value, err)
+		}
+		q.list[q.name] = quantity
+	}
+	return provisioner, nil
+}
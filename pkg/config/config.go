@@ -0,0 +1,242 @@
+// Package config holds the configuration of the controller manager.
+// Every setting has a flag; a YAML config file can set them too, and flags given on the command line win over it.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/zapr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	ctrlzap "sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/yaml"
+)
+
+// Log formats
+const (
+	LogFormatJSON    = "json"
+	LogFormatConsole = "console"
+)
+
+// ManagerConfig configures the controller manager
+type ManagerConfig struct {
+	MetricsAddr      string `json:"metrics_addr,omitempty"`
+	HealthProbeAddr  string `json:"health_probe_bind_address,omitempty"`
+	WebhookPort      int    `json:"webhook_port,omitempty"`
+	WebhookCertDir   string `json:"webhook_cert_dir,omitempty"`
+	LeaderElection   bool   `json:"leader_election,omitempty"`
+	LeaderElectionID string `json:"leader_election_id,omitempty"`
+	LeaderElectionNS string `json:"leader_election_namespace,omitempty"`
+	// Namespaces restricts the manager to KfClusters in these namespaces; empty watches all namespaces
+	Namespaces              []string        `json:"namespaces,omitempty"`
+	SyncPeriod              metav1.Duration `json:"sync_period,omitempty"`
+	MaxConcurrentReconciles int             `json:"max_concurrent_reconciles,omitempty"`
+	// MaxRetries is the number of failed attempts in a row after which a KfCluster is Failed; 0 retries forever
+	MaxRetries int    `json:"max_retries,omitempty"`
+	LogLevel   string `json:"log_level,omitempty"`
+	LogFormat  string `json:"log_format,omitempty"`
+	// Platforms is a comma separated list of the platforms the controller provisions; empty enables all of them
+	Platforms string `json:"platforms,omitempty"`
+	// ExternalProviders is a comma separated list of platform=path pairs
+	ExternalProviders string `json:"external_providers,omitempty"`
+	// GCPServiceAccounts is a comma separated list of namespace=service-account patterns naming the GCP
+	// service accounts KfClusters may have the controller act as; empty allows none
+	GCPServiceAccounts string            `json:"gcp_service_accounts,omitempty"`
+	Provisioner        ProvisionerConfig `json:"provisioner,omitempty"`
+}
+
+// ProvisionerConfig holds the provisioner pod defaults as they are given on the command line
+type ProvisionerConfig struct {
+	Image            string `json:"image,omitempty"`
+	ImagePullPolicy  string `json:"image_pull_policy,omitempty"`
+	ImagePullSecrets string `json:"image_pull_secrets,omitempty"`
+	CPURequest       string `json:"cpu_request,omitempty"`
+	MemoryRequest    string `json:"memory_request,omitempty"`
+	CPULimit         string `json:"cpu_limit,omitempty"`
+	MemoryLimit      string `json:"memory_limit,omitempty"`
+}
+
+// BindFlags registers the flags of every setting, defaulting to the current values of c
+func (c *ManagerConfig) BindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.MetricsAddr, "metrics-addr", c.MetricsAddr, "The address the metric endpoint binds to.")
+	fs.StringVar(&c.HealthProbeAddr, "health-probe-bind-address", c.HealthProbeAddr, "The address the liveness and readiness probe endpoints bind to.")
+	fs.IntVar(&c.WebhookPort, "webhook-port", c.WebhookPort, "The port the webhook server listens on.")
+	fs.StringVar(&c.WebhookCertDir, "webhook-cert-dir", c.WebhookCertDir, "The directory holding the tls.crt and tls.key of the webhook server.")
+	fs.BoolVar(&c.LeaderElection, "enable-leader-election", c.LeaderElection,
+		"Enable leader election for controller manager. Enabling this will ensure there is only one active controller manager.")
+	fs.StringVar(&c.LeaderElectionID, "leader-election-id", c.LeaderElectionID, "The name of the ConfigMap used for leader election.")
+	fs.StringVar(&c.LeaderElectionNS, "leader-election-namespace", c.LeaderElectionNS,
+		"The namespace of the leader election ConfigMap; defaults to the namespace the manager runs in.")
+	fs.Var(listValue{&c.Namespaces}, "namespaces",
+		"Comma separated list of the namespaces to watch KfClusters in; empty watches all namespaces. "+
+			"Watching namespaces needs only the namespaced RBAC of config/rbac-namespaced.")
+	fs.Var(durationValue{&c.SyncPeriod}, "sync-period", "How often every watched object is reconciled again, such as 10h.")
+	fs.IntVar(&c.MaxConcurrentReconciles, "max-concurrent-reconciles", c.MaxConcurrentReconciles, "The number of KfClusters reconciled in parallel.")
+	fs.IntVar(&c.MaxRetries, "max-retries", c.MaxRetries,
+		"The number of failed attempts in a row after which a KfCluster is marked Failed and no longer retried; 0 retries forever.")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "The log level: debug, info or error.")
+	fs.StringVar(&c.LogFormat, "log-format", c.LogFormat, "The log format: json or console.")
+	fs.StringVar(&c.Platforms, "platforms", c.Platforms,
+		"Comma separated list of the platforms this controller provisions; empty enables every registered platform. "+
+			"Naming gcp or gke makes loadable GCP credentials a readiness condition.")
+	fs.StringVar(&c.ExternalProviders, "external-providers", c.ExternalProviders,
+		"Comma separated list of platform=path pairs naming the executables of out-of-tree providers.")
+	fs.StringVar(&c.GCPServiceAccounts, "gcp-service-accounts", c.GCPServiceAccounts,
+		"Comma separated list of namespace=service-account pairs allowing the KfClusters of a namespace to have the controller "+
+			"act as GCP service accounts, through Workload Identity or impersonation. Both sides are glob patterns, such as "+
+			"team-a=*@team-a-project.iam.gserviceaccount.com; empty allows no service account.")
+	fs.StringVar(&c.Provisioner.Image, "provisioner-image", c.Provisioner.Image, "The kf-clusterctl image used by provisioner pods.")
+	fs.StringVar(&c.Provisioner.ImagePullPolicy, "provisioner-image-pull-policy", c.Provisioner.ImagePullPolicy, "The image pull policy of provisioner pods.")
+	fs.StringVar(&c.Provisioner.ImagePullSecrets, "provisioner-image-pull-secrets", c.Provisioner.ImagePullSecrets,
+		"Comma separated list of image pull secrets for provisioner pods.")
+	fs.StringVar(&c.Provisioner.CPURequest, "provisioner-cpu-request", c.Provisioner.CPURequest, "The CPU request of provisioner pods.")
+	fs.StringVar(&c.Provisioner.MemoryRequest, "provisioner-memory-request", c.Provisioner.MemoryRequest, "The memory request of provisioner pods.")
+	fs.StringVar(&c.Provisioner.CPULimit, "provisioner-cpu-limit", c.Provisioner.CPULimit, "The CPU limit of provisioner pods.")
+	fs.StringVar(&c.Provisioner.MemoryLimit, "provisioner-memory-limit", c.Provisioner.MemoryLimit, "The memory limit of provisioner pods.")
+}
+
+// Parse parses the command line into c. When --config names a file, its settings apply first and
+// the flags set on the command line override them.
+func (c *ManagerConfig) Parse(fs *flag.FlagSet, args []string) error {
+	var configFile string
+	fs.StringVar(&configFile, "config", "", "A YAML file with manager settings; flags given on the command line take precedence.")
+	c.BindFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if configFile == "" {
+		return c.Validate()
+	}
+	explicit := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return fmt.Errorf("invalid config file %s: %v", configFile, err)
+	}
+	for name, value := range explicit {
+		if err := fs.Set(name, value); err != nil {
+			return err
+		}
+	}
+	return c.Validate()
+}
+
+// Validate checks the settings that aren't checked where they are used
+func (c *ManagerConfig) Validate() error {
+	if c.WebhookPort <= 0 || c.WebhookPort > 65535 {
+		return fmt.Errorf("invalid webhook port %d", c.WebhookPort)
+	}
+	if c.MaxConcurrentReconciles < 1 {
+		return fmt.Errorf("max concurrent reconciles must be at least 1, not %d", c.MaxConcurrentReconciles)
+	}
+	if c.MaxRetries < 0 {
+		return fmt.Errorf("max retries must not be negative, not %d", c.MaxRetries)
+	}
+	if c.LeaderElection && c.LeaderElectionID == "" {
+		return fmt.Errorf("leader election needs a leader election ID")
+	}
+	if c.LogFormat != LogFormatJSON && c.LogFormat != LogFormatConsole {
+		return fmt.Errorf("invalid log format %q; use json or console", c.LogFormat)
+	}
+	seen := map[string]bool{}
+	for _, namespace := range c.Namespaces {
+		if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+			return fmt.Errorf("invalid namespace %q: %s", namespace, strings.Join(errs, ", "))
+		}
+		if seen[namespace] {
+			return fmt.Errorf("namespace %q is listed twice", namespace)
+		}
+		seen[namespace] = true
+	}
+	if _, err := c.logLevel(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *ManagerConfig) logLevel() (zapcore.Level, error) {
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		return level, fmt.Errorf("invalid log level %q: %v", c.LogLevel, err)
+	}
+	return level, nil
+}
+
+// Logger returns the logger of the configured level and format.
+// Debug messages are the ones logged with V(1).
+func (c *ManagerConfig) Logger() logr.Logger {
+	level, _ := c.logLevel()
+	var encoder zapcore.Encoder
+	if c.LogFormat == LogFormatConsole {
+		encoder = zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
+	} else {
+		encoder = zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	}
+	sink := zapcore.AddSync(os.Stderr)
+	core := zapcore.NewCore(&ctrlzap.KubeAwareEncoder{Encoder: encoder, Verbose: level <= zapcore.DebugLevel}, sink, zap.NewAtomicLevelAt(level))
+	return zapr.NewLogger(zap.New(core, zap.AddCallerSkip(1), zap.ErrorOutput(sink), zap.AddStacktrace(zap.ErrorLevel)))
+}
+
+// SyncPeriodOrNil returns the sync period for the manager options, or nil for the default
+func (c *ManagerConfig) SyncPeriodOrNil() *time.Duration {
+	if c.SyncPeriod.Duration <= 0 {
+		return nil
+	}
+	return &c.SyncPeriod.Duration
+}
+
+// listValue is a flag.Value for a comma separated list
+type listValue struct {
+	list *[]string
+}
+
+func (v listValue) String() string {
+	if v.list == nil {
+		return ""
+	}
+	return strings.Join(*v.list, ",")
+}
+
+func (v listValue) Set(value string) error {
+	*v.list = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*v.list = append(*v.list, item)
+		}
+	}
+	return nil
+}
+
+// durationValue is a flag.Value for a metav1.Duration, which is how durations are written in the config file
+type durationValue struct {
+	d *metav1.Duration
+}
+
+func (v durationValue) String() string {
+	if v.d == nil {
+		return ""
+	}
+	return v.d.Duration.String()
+}
+
+func (v durationValue) Set(value string) error {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	v.d.Duration = d
+	return nil
+}
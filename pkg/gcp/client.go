@@ -2,16 +2,196 @@ package gcp
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
 
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
 	compute "google.golang.org/api/compute/v1"
+	iamcredentials "google.golang.org/api/iamcredentials/v1"
+	"google.golang.org/api/option"
 )
 
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// AuthConfig selects how GCP clients authenticate.
+// With neither field set the Application Default Credentials are used, which resolve to
+// Workload Identity or the node's service account when running in a pod.
+type AuthConfig struct {
+	// ImpersonateServiceAccount is the email of a service account to mint short-lived tokens for
+	ImpersonateServiceAccount string
+	// CredentialsFile is the path of a service account key file; only used as a fallback
+	CredentialsFile string
+	// CredentialsJSON is the content of a service account key file; only used as a fallback
+	CredentialsJSON []byte
+}
+
+// AuthConfigFromEnv reads the AuthConfig set up for the provisioner pod by the controller
+func AuthConfigFromEnv() AuthConfig {
+	auth := AuthConfig{}
+	switch os.Getenv("GCP_AUTH_MODE") {
+	case "Impersonation":
+		auth.ImpersonateServiceAccount = os.Getenv("GCP_SERVICE_ACCOUNT")
+	case "KeyFile", "":
+		auth.CredentialsFile = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	return auth
+}
+
+// ClientOptions returns the client options that authenticate API clients as described by auth
+func ClientOptions(ctx context.Context, auth AuthConfig) ([]option.ClientOption, error) {
+	if auth.ImpersonateServiceAccount != "" {
+		tokenSource, err := impersonatedTokenSource(ctx, auth.ImpersonateServiceAccount)
+		if err != nil {
+			return nil, err
+		}
+		return []option.ClientOption{option.WithTokenSource(tokenSource)}, nil
+	}
+	if auth.CredentialsFile != "" {
+		return []option.ClientOption{option.WithCredentialsFile(auth.CredentialsFile)}, nil
+	}
+	if len(auth.CredentialsJSON) > 0 {
+		return []option.ClientOption{option.WithCredentialsJSON(auth.CredentialsJSON)}, nil
+	}
+	return nil, nil
+}
+
 // GetClient authenticates to GCP and fetches the Instance List
-func GetClient(ctx context.Context) (*compute.Service, error) {
-	computeService, err := compute.NewService(ctx)
+func GetClient(ctx context.Context, auth AuthConfig) (*compute.Service, error) {
+	opts, err := ClientOptions(ctx, auth)
+	if err != nil {
+		return nil, err
+	}
+	computeService, err := compute.NewService(ctx, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("Error autenticating to the GCP service account")
 	}
 	return computeService, nil
 }
+
+// impersonatedTokenSource mints short-lived access tokens for serviceAccount using the
+// Application Default Credentials, which need roles/iam.serviceAccountTokenCreator on it
+func impersonatedTokenSource(ctx context.Context, serviceAccount string) (oauth2.TokenSource, error) {
+	tokenSource, err := newImpersonationTokenSource(ctx, serviceAccount)
+	if err != nil {
+		return nil, err
+	}
+	return oauth2.ReuseTokenSource(nil, tokenSource), nil
+}
+
+func newImpersonationTokenSource(ctx context.Context, serviceAccount string) (*impersonationTokenSource, error) {
+	baseTokenSource, err := google.DefaultTokenSource(ctx, cloudPlatformScope)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting default GCP credentials: %v", err)
+	}
+	iamService, err := iamcredentials.NewService(ctx, option.WithTokenSource(baseTokenSource))
+	if err != nil {
+		return nil, fmt.Errorf("Error creating IAM credentials client: %v", err)
+	}
+	return &impersonationTokenSource{
+		ctx:            ctx,
+		iamService:     iamService,
+		serviceAccount: serviceAccount,
+	}, nil
+}
+
+// impersonationTokenSource implements oauth2.TokenSource with the IAM credentials API
+type impersonationTokenSource struct {
+	ctx            context.Context
+	iamService     *iamcredentials.Service
+	serviceAccount string
+}
+
+// Token implements oauth2.TokenSource
+func (ts *impersonationTokenSource) Token() (*oauth2.Token, error) {
+	name := "projects/-/serviceAccounts/" + ts.serviceAccount
+	resp, err := ts.iamService.Projects.ServiceAccounts.GenerateAccessToken(name, &iamcredentials.GenerateAccessTokenRequest{
+		Scope:    []string{cloudPlatformScope},
+		Lifetime: "3600s",
+	}).Context(ts.ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("Error impersonating service account %s: %v", ts.serviceAccount, err)
+	}
+	expiry, err := time.Parse(time.RFC3339, resp.ExpireTime)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing token expiry %q: %v", resp.ExpireTime, err)
+	}
+	return &oauth2.Token{
+		AccessToken: resp.AccessToken,
+		TokenType:   "Bearer",
+		Expiry:      expiry,
+	}, nil
+}
+
+// minKubeconfigTokenValidity is how long the access token of a generated kubeconfig is valid at least
+const minKubeconfigTokenValidity = 30 * time.Minute
+
+var (
+	kubeconfigTokensLock sync.Mutex
+	// kubeconfigTokens holds the last token minted for each identity, so that kubeconfigs only change
+	// once their token needs renewing
+	kubeconfigTokens = map[string]*oauth2.Token{}
+)
+
+// kubeconfigToken returns an access token of the identity auth selects for a kubeconfig, valid for at least
+// minKubeconfigTokenValidity. It returns nil for the Application Default Credentials, which kubeconfigs use
+// through the gcp auth provider instead.
+func kubeconfigToken(ctx context.Context, auth AuthConfig) (*oauth2.Token, error) {
+	var identity string
+	switch {
+	case auth.ImpersonateServiceAccount != "":
+		identity = "impersonate " + auth.ImpersonateServiceAccount
+	case auth.CredentialsFile != "":
+		identity = "file " + auth.CredentialsFile
+	case len(auth.CredentialsJSON) > 0:
+		sum := sha256.Sum256(auth.CredentialsJSON)
+		identity = "key " + hex.EncodeToString(sum[:])
+	default:
+		return nil, nil
+	}
+	kubeconfigTokensLock.Lock()
+	defer kubeconfigTokensLock.Unlock()
+	if token := kubeconfigTokens[identity]; token != nil && time.Until(token.Expiry) > minKubeconfigTokenValidity {
+		return token, nil
+	}
+	var tokenSource oauth2.TokenSource
+	if auth.ImpersonateServiceAccount != "" {
+		impersonation, err := newImpersonationTokenSource(ctx, auth.ImpersonateServiceAccount)
+		if err != nil {
+			return nil, err
+		}
+		tokenSource = impersonation
+	} else {
+		key := auth.CredentialsJSON
+		if auth.CredentialsFile != "" {
+			var err error
+			if key, err = ioutil.ReadFile(auth.CredentialsFile); err != nil {
+				return nil, fmt.Errorf("Error reading GCP credentials file: %v", err)
+			}
+		}
+		credentials, err := google.CredentialsFromJSON(ctx, key, cloudPlatformScope)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading GCP credentials: %v", err)
+		}
+		tokenSource = credentials.TokenSource
+	}
+	token, err := tokenSource.Token()
+	if err != nil {
+		return nil, err
+	}
+	kubeconfigTokens[identity] = token
+	return token, nil
+}
+
+// CheckDefaultCredentials checks that the Application Default Credentials of the caller can be loaded
+func CheckDefaultCredentials(ctx context.Context) error {
+	if _, err := google.FindDefaultCredentials(ctx, cloudPlatformScope); err != nil {
+		return fmt.Errorf("Error loading default GCP credentials: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package gcp
+
+import (
+	"errors"
+	"net/http"
+
+	"google.golang.org/api/googleapi"
+)
+
+// apiError returns the GCP API error err wraps, if any
+func apiError(err error) (*googleapi.Error, bool) {
+	var apiErr *googleapi.Error
+	ok := errors.As(err, &apiErr)
+	return apiErr, ok
+}
+
+// isNotFound reports whether err is a GCP API 404
+func isNotFound(err error) bool {
+	apiErr, ok := apiError(err)
+	return ok && apiErr.Code == http.StatusNotFound
+}
+
+// isAlreadyExists reports whether err is a GCP API 409, returned when a resource is created twice
+func isAlreadyExists(err error) bool {
+	apiErr, ok := apiError(err)
+	return ok && apiErr.Code == http.StatusConflict
+}
+
+// isResourceBusy reports whether err rejects an operation because a previous one on the
+// resource, or on a resource using it, is still running
+func isResourceBusy(err error) bool {
+	apiErr, ok := apiError(err)
+	if !ok {
+		return false
+	}
+	for _, item := range apiErr.Errors {
+		if item.Reason == "resourceNotReady" || item.Reason == "resourceInUseByAnotherResource" {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTerminal reports whether err is a GCP API 400 or 403, which retrying the same request can't fix:
+// the spec is invalid or the credentials lack a permission. Rate limits and exhausted quotas are also
+// returned as 403 but clear up on their own, so they are not terminal.
+func IsTerminal(err error) bool {
+	apiErr, ok := apiError(err)
+	if !ok {
+		return false
+	}
+	switch apiErr.Code {
+	case http.StatusBadRequest:
+		return true
+	case http.StatusForbidden:
+		for _, item := range apiErr.Errors {
+			switch item.Reason {
+			case "rateLimitExceeded", "userRateLimitExceeded", "quotaExceeded":
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
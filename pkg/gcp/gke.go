@@ -0,0 +1,431 @@
+package gcp
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/CiscoAI/kf-cluster-api/pkg/provision"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+	compute "google.golang.org/api/compute/v1"
+	container "google.golang.org/api/container/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// Names of the GKE provisioning stages, in the order they run
+const (
+	StageGKECluster   = "GKECluster"
+	StageGKEMaster    = "GKEMaster"
+	StageGKENodePools = "GKENodePools"
+)
+
+// Statuses of GKE clusters and node pools
+const (
+	gkeStatusRunning      = "RUNNING"
+	gkeStatusProvisioning = "PROVISIONING"
+	gkeStatusStopping     = "STOPPING"
+)
+
+const defaultNodePoolName = "default-pool"
+
+// NodePoolConfig describes a GKE node pool
+type NodePoolConfig struct {
+	Name        string
+	MachineType string
+	// NodeCount is the number of nodes per zone of the cluster; ignored when autoscaling
+	NodeCount  int32
+	DiskSizeGb int64
+	// MinNodeCount and MaxNodeCount enable autoscaling when MaxNodeCount is set
+	MinNodeCount int32
+	MaxNodeCount int32
+	Preemptible  bool
+	Labels       map[string]string
+}
+
+// GKEConfig describes a GKE cluster
+type GKEConfig struct {
+	Name    string
+	Project string
+	// Location is the zone of a zonal cluster or the region of a regional one
+	Location string
+	// Network defaults to the default network of the project
+	Network string
+	// Version is the Kubernetes version of the master and node pools; empty selects the GKE default
+	Version   string
+	NodePools []NodePoolConfig
+	// NodeServiceAccount is the service account the nodes run as, with NodeScopes;
+	// it defaults to the Compute Engine default service account
+	NodeServiceAccount string
+}
+
+// GetContainerClient authenticates to GCP and returns a client of the GKE API
+func GetContainerClient(ctx context.Context, auth AuthConfig) (*container.Service, error) {
+	opts, err := ClientOptions(ctx, auth)
+	if err != nil {
+		return nil, err
+	}
+	containerService, err := container.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating GKE client: %w", err)
+	}
+	return containerService, nil
+}
+
+// GKEProvisioner creates, upgrades and deletes a GKE cluster through the container API.
+// Like Provisioner it never blocks on an operation; its stages report progress instead.
+type GKEProvisioner struct {
+	config           GKEConfig
+	auth             AuthConfig
+	containerService *container.Service
+	computeService   *compute.Service
+
+	// Observed while the stages run
+	cluster *container.Cluster
+	// kubeconfigExpiry is when the token of the kubeconfig last returned expires
+	kubeconfigExpiry time.Time
+}
+
+// NewGKEProvisioner returns a GKEProvisioner for config, filling in defaults.
+// The clients and the kubeconfig authenticate as auth selects.
+func NewGKEProvisioner(config GKEConfig, auth AuthConfig, containerService *container.Service, computeService *compute.Service) *GKEProvisioner {
+	p := &GKEProvisioner{config: config, auth: auth, containerService: containerService, computeService: computeService}
+	if len(p.config.NodePools) == 0 {
+		p.config.NodePools = []NodePoolConfig{{Name: defaultNodePoolName}}
+	}
+	for i := range p.config.NodePools {
+		pool := &p.config.NodePools[i]
+		if pool.MachineType == "" {
+			pool.MachineType = defaultMachineType
+		}
+		if pool.NodeCount == 0 && pool.MaxNodeCount == 0 {
+			pool.NodeCount = defaultNodeCount
+		}
+	}
+	return p
+}
+
+// Stages returns the provisioning stages in the order they must run.
+// Re-running them after a spec change upgrades the master and node pools and resizes the pools.
+func (p *GKEProvisioner) Stages() []provision.Stage {
+	return []provision.Stage{
+		{Name: StageGKECluster, Run: p.ensureCluster},
+		{Name: StageGKEMaster, Run: p.ensureMasterVersion},
+		{Name: StageGKENodePools, Run: p.ensureNodePools},
+	}
+}
+
+// TeardownStages returns the stages deleting the cluster, in the order they must run
+func (p *GKEProvisioner) TeardownStages() []provision.Stage {
+	return []provision.Stage{
+		{Name: StageGKECluster, Run: p.deleteCluster},
+	}
+}
+
+// Kubeconfig returns a kubeconfig for the cluster once the GKECluster stage has completed.
+// It authenticates with an access token of the service account or key of the provisioner, which
+// KubeconfigRenewal tells when to renew. Without either it uses the gcp auth provider, so its users need
+// Application Default Credentials with access to the cluster, as with the kubeconfig written by gcloud.
+func (p *GKEProvisioner) Kubeconfig() []byte {
+	if p.cluster == nil || p.cluster.MasterAuth == nil {
+		return nil
+	}
+	token, err := kubeconfigToken(context.Background(), p.auth)
+	if err != nil {
+		log.Errorf("Error minting the kubeconfig token of %s: %v", p.config.Name, err)
+		return nil
+	}
+	kubeconfig, err := GKEKubeconfig(p.cluster, token)
+	if err != nil {
+		log.Errorf("Error generating kubeconfig of %s: %v", p.config.Name, err)
+		return nil
+	}
+	if token != nil {
+		p.kubeconfigExpiry = token.Expiry
+	}
+	return kubeconfig
+}
+
+// KubeconfigRenewal returns when the kubeconfig last returned needs to be generated again,
+// or zero when it doesn't expire
+func (p *GKEProvisioner) KubeconfigRenewal() time.Time {
+	if p.kubeconfigExpiry.IsZero() {
+		return time.Time{}
+	}
+	return p.kubeconfigExpiry.Add(-minKubeconfigTokenValidity)
+}
+
+// GKEKubeconfig returns a kubeconfig for a GKE cluster from its endpoint and CA.
+// It authenticates with token, or with the gcp auth provider when token is nil.
+func GKEKubeconfig(cluster *container.Cluster, token *oauth2.Token) ([]byte, error) {
+	if cluster.Endpoint == "" || cluster.MasterAuth == nil {
+		return nil, fmt.Errorf("cluster %s has no endpoint yet", cluster.Name)
+	}
+	caCert, err := base64.StdEncoding.DecodeString(cluster.MasterAuth.ClusterCaCertificate)
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding CA certificate of cluster %s: %v", cluster.Name, err)
+	}
+	name := "gke_" + cluster.Name
+	config := clientcmdapi.NewConfig()
+	config.Clusters[name] = &clientcmdapi.Cluster{
+		Server:                   "https://" + cluster.Endpoint,
+		CertificateAuthorityData: caCert,
+	}
+	if token != nil {
+		config.AuthInfos[name] = &clientcmdapi.AuthInfo{Token: token.AccessToken}
+	} else {
+		config.AuthInfos[name] = &clientcmdapi.AuthInfo{
+			AuthProvider: &clientcmdapi.AuthProviderConfig{Name: "gcp"},
+		}
+	}
+	config.Contexts[name] = &clientcmdapi.Context{Cluster: name, AuthInfo: name}
+	config.CurrentContext = name
+	return clientcmd.Write(*config)
+}
+
+func (p *GKEProvisioner) parent() string {
+	return fmt.Sprintf("projects/%s/locations/%s", p.config.Project, p.config.Location)
+}
+
+func (p *GKEProvisioner) clusterName() string {
+	return p.parent() + "/clusters/" + p.config.Name
+}
+
+func (p *GKEProvisioner) nodePoolName(pool string) string {
+	return p.clusterName() + "/nodePools/" + pool
+}
+
+func (p *GKEProvisioner) nodePool(config NodePoolConfig) *container.NodePool {
+	pool := &container.NodePool{
+		Name:             config.Name,
+		InitialNodeCount: int64(config.NodeCount),
+		Version:          p.config.Version,
+		Config: &container.NodeConfig{
+			MachineType:    config.MachineType,
+			DiskSizeGb:     config.DiskSizeGb,
+			Preemptible:    config.Preemptible,
+			Labels:         config.Labels,
+			ServiceAccount: p.config.NodeServiceAccount,
+			OauthScopes:    NodeScopes,
+		},
+		Management: &container.NodeManagement{AutoRepair: true},
+	}
+	if config.MaxNodeCount > 0 {
+		pool.Autoscaling = nodePoolAutoscaling(config)
+		if pool.InitialNodeCount < int64(config.MinNodeCount) {
+			pool.InitialNodeCount = int64(config.MinNodeCount)
+		}
+		if pool.InitialNodeCount == 0 {
+			pool.InitialNodeCount = 1
+		}
+	}
+	return pool
+}
+
+func nodePoolAutoscaling(config NodePoolConfig) *container.NodePoolAutoscaling {
+	return &container.NodePoolAutoscaling{
+		Enabled:         config.MaxNodeCount > 0,
+		MinNodeCount:    int64(config.MinNodeCount),
+		MaxNodeCount:    int64(config.MaxNodeCount),
+		ForceSendFields: []string{"Enabled", "MinNodeCount", "MaxNodeCount"},
+	}
+}
+
+// ensureCluster creates the cluster with its node pools unless it exists, and completes once it runs
+func (p *GKEProvisioner) ensureCluster(ctx context.Context) (bool, error) {
+	cluster, err := p.containerService.Projects.Locations.Clusters.Get(p.clusterName()).Context(ctx).Do()
+	if err == nil {
+		p.cluster = cluster
+		if cluster.Status == gkeStatusProvisioning {
+			log.Infof("GKE cluster %s is being created", p.config.Name)
+			return false, nil
+		}
+		return cluster.Endpoint != "", nil
+	}
+	if !isNotFound(err) {
+		return false, fmt.Errorf("Error getting GKE cluster %s: %w", p.config.Name, err)
+	}
+	pools := []*container.NodePool{}
+	for _, pool := range p.config.NodePools {
+		pools = append(pools, p.nodePool(pool))
+	}
+	log.Infof("Creating GKE cluster: %v", p.config.Name)
+	_, err = p.containerService.Projects.Locations.Clusters.Create(p.parent(), &container.CreateClusterRequest{
+		Cluster: &container.Cluster{
+			Name:                  p.config.Name,
+			Network:               p.config.Network,
+			InitialClusterVersion: p.config.Version,
+			NodePools:             pools,
+			ResourceLabels:        map[string]string{"kfcluster": p.config.Name},
+		},
+	}).Context(ctx).Do()
+	if err != nil && !isAlreadyExists(err) {
+		return false, fmt.Errorf("Error creating GKE cluster %s: %w", p.config.Name, err)
+	}
+	return false, nil
+}
+
+// ensureMasterVersion upgrades the master to the configured version and completes once it runs it
+func (p *GKEProvisioner) ensureMasterVersion(ctx context.Context) (bool, error) {
+	if p.config.Version == "" || versionMatches(p.cluster.CurrentMasterVersion, p.config.Version) {
+		return true, nil
+	}
+	if p.cluster.Status != gkeStatusRunning {
+		log.Infof("Waiting for GKE cluster %s to upgrade its master", p.config.Name)
+		return false, nil
+	}
+	log.Infof("Upgrading master of GKE cluster %s from %s to %s", p.config.Name, p.cluster.CurrentMasterVersion, p.config.Version)
+	_, err := p.containerService.Projects.Locations.Clusters.UpdateMaster(p.clusterName(), &container.UpdateMasterRequest{
+		MasterVersion: p.config.Version,
+	}).Context(ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("Error upgrading master of GKE cluster %s: %w", p.config.Name, err)
+	}
+	return false, nil
+}
+
+// ensureNodePools brings the node pools in line with the config, one change per reconcile since
+// GKE runs a single operation per cluster at a time. It completes once no change is left.
+func (p *GKEProvisioner) ensureNodePools(ctx context.Context) (bool, error) {
+	if p.cluster.Status != gkeStatusRunning {
+		log.Infof("Waiting for GKE cluster %s to finish its running operation", p.config.Name)
+		return false, nil
+	}
+	existing := map[string]*container.NodePool{}
+	for _, pool := range p.cluster.NodePools {
+		existing[pool.Name] = pool
+	}
+	pools := p.containerService.Projects.Locations.Clusters.NodePools
+	for _, config := range p.config.NodePools {
+		pool, ok := existing[config.Name]
+		if !ok {
+			log.Infof("Creating node pool %s of GKE cluster %s", config.Name, p.config.Name)
+			_, err := pools.Create(p.clusterName(), &container.CreateNodePoolRequest{NodePool: p.nodePool(config)}).Context(ctx).Do()
+			if err != nil && !isAlreadyExists(err) {
+				return false, fmt.Errorf("Error creating node pool %s: %w", config.Name, err)
+			}
+			return false, nil
+		}
+		delete(existing, config.Name)
+		if pool.Status != gkeStatusRunning {
+			log.Infof("Waiting for node pool %s of GKE cluster %s", config.Name, p.config.Name)
+			return false, nil
+		}
+		if !versionMatches(pool.Version, p.cluster.CurrentMasterVersion) {
+			log.Infof("Upgrading node pool %s of GKE cluster %s to %s", config.Name, p.config.Name, p.cluster.CurrentMasterVersion)
+			_, err := pools.Update(p.nodePoolName(config.Name), &container.UpdateNodePoolRequest{
+				NodeVersion: "-",
+				ImageType:   pool.Config.ImageType,
+			}).Context(ctx).Do()
+			if err != nil {
+				return false, fmt.Errorf("Error upgrading node pool %s: %w", config.Name, err)
+			}
+			return false, nil
+		}
+		if autoscalingChanged(pool.Autoscaling, config) {
+			log.Infof("Setting autoscaling of node pool %s of GKE cluster %s", config.Name, p.config.Name)
+			_, err := pools.SetAutoscaling(p.nodePoolName(config.Name), &container.SetNodePoolAutoscalingRequest{
+				Autoscaling: nodePoolAutoscaling(config),
+			}).Context(ctx).Do()
+			if err != nil {
+				return false, fmt.Errorf("Error setting autoscaling of node pool %s: %w", config.Name, err)
+			}
+			return false, nil
+		}
+		if config.MaxNodeCount == 0 {
+			resized, err := p.ensureNodePoolSize(ctx, pool, config)
+			if err != nil || !resized {
+				return false, err
+			}
+		}
+	}
+	for name := range existing {
+		log.Infof("Deleting node pool %s of GKE cluster %s", name, p.config.Name)
+		_, err := pools.Delete(p.nodePoolName(name)).Context(ctx).Do()
+		if err != nil && !isNotFound(err) {
+			return false, fmt.Errorf("Error deleting node pool %s: %w", name, err)
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// ensureNodePoolSize resizes a pool without autoscaling to its configured node count per zone.
+// The current size is read from the managed instance groups backing the pool.
+func (p *GKEProvisioner) ensureNodePoolSize(ctx context.Context, pool *container.NodePool, config NodePoolConfig) (bool, error) {
+	for _, url := range pool.InstanceGroupUrls {
+		zone, name, err := parseInstanceGroupURL(url)
+		if err != nil {
+			return false, err
+		}
+		manager, err := p.computeService.InstanceGroupManagers.Get(p.config.Project, zone, name).Context(ctx).Do()
+		if err != nil {
+			return false, fmt.Errorf("Error getting instance group %s: %w", name, err)
+		}
+		if manager.TargetSize == int64(config.NodeCount) {
+			continue
+		}
+		log.Infof("Resizing node pool %s of GKE cluster %s to %d nodes per zone", config.Name, p.config.Name, config.NodeCount)
+		_, err = p.containerService.Projects.Locations.Clusters.NodePools.SetSize(p.nodePoolName(config.Name), &container.SetNodePoolSizeRequest{
+			NodeCount:       int64(config.NodeCount),
+			ForceSendFields: []string{"NodeCount"},
+		}).Context(ctx).Do()
+		if err != nil {
+			return false, fmt.Errorf("Error resizing node pool %s: %w", config.Name, err)
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// deleteCluster deletes the cluster and returns true once it is gone
+func (p *GKEProvisioner) deleteCluster(ctx context.Context) (bool, error) {
+	cluster, err := p.containerService.Projects.Locations.Clusters.Get(p.clusterName()).Context(ctx).Do()
+	if isNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Error getting GKE cluster %s: %w", p.config.Name, err)
+	}
+	if cluster.Status == gkeStatusStopping {
+		log.Infof("GKE cluster %s is being deleted", p.config.Name)
+		return false, nil
+	}
+	if cluster.Status != gkeStatusRunning && cluster.Status != "ERROR" && cluster.Status != "DEGRADED" {
+		log.Infof("Waiting for GKE cluster %s to finish its running operation before deleting it", p.config.Name)
+		return false, nil
+	}
+	log.Infof("Deleting GKE cluster: %v", p.config.Name)
+	_, err = p.containerService.Projects.Locations.Clusters.Delete(p.clusterName()).Context(ctx).Do()
+	if err != nil && !isNotFound(err) {
+		return false, fmt.Errorf("Error deleting GKE cluster %s: %w", p.config.Name, err)
+	}
+	return false, nil
+}
+
+func autoscalingChanged(autoscaling *container.NodePoolAutoscaling, config NodePoolConfig) bool {
+	if autoscaling == nil || !autoscaling.Enabled {
+		return config.MaxNodeCount > 0
+	}
+	return autoscaling.MinNodeCount != int64(config.MinNodeCount) || autoscaling.MaxNodeCount != int64(config.MaxNodeCount)
+}
+
+// versionMatches reports whether a GKE version such as 1.15.4-gke.22 satisfies a requested
+// version, which may leave out the patch or GKE release
+func versionMatches(current string, requested string) bool {
+	return current == requested || strings.HasPrefix(current, requested+".") || strings.HasPrefix(current, requested+"-")
+}
+
+// parseInstanceGroupURL returns the zone and name of an instance group manager from its URL
+func parseInstanceGroupURL(url string) (string, string, error) {
+	parts := strings.Split(url, "/")
+	for i := 0; i+3 < len(parts); i++ {
+		if parts[i] == "zones" && parts[i+2] == "instanceGroupManagers" {
+			return parts[i+1], parts[i+3], nil
+		}
+	}
+	return "", "", fmt.Errorf("unexpected instance group URL %s", url)
+}
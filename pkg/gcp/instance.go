@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/cenkalti/backoff"
@@ -43,89 +45,217 @@ func ListInstances(ctx context.Context, project string, zone string, computeServ
 	return instanceNames, nil
 }
 
-// CreateInstance takes in the project and zone to create a standard VM if it doesn't exist and returns an error
-func CreateInstance(ctx context.Context, instanceName string, project string, zone string, computeService *compute.Service) error {
-	if project == "" {
-		project = os.Getenv("PROJECT")
+// ListInstancesByLabel returns the instances in the zone that carry every one of labels
+func ListInstancesByLabel(ctx context.Context, project string, zone string, labels map[string]string, computeService *compute.Service) ([]*compute.Instance, error) {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
 	}
-	if zone == "" {
-		zone = os.Getenv("ZONE")
+	sort.Strings(keys)
+	filters := make([]string, 0, len(keys))
+	for _, key := range keys {
+		filters = append(filters, fmt.Sprintf("(labels.%s = %q)", key, labels[key]))
 	}
-	machineType := "zones/" + zone + "/machineTypes/n2-standard-8"
-	diskParams := &compute.AttachedDiskInitializeParams{
-		DiskSizeGb:  20,
-		SourceImage: "projects/cpsg-ai-kubeflow/global/images/github-action-image-from-snapshot",
+	instances := []*compute.Instance{}
+	err := computeService.Instances.List(project, zone).Filter(strings.Join(filters, " ")).Pages(ctx, func(list *compute.InstanceList) error {
+		instances = append(instances, list.Items...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error listing instances: %w", err)
+	}
+	return instances, nil
+}
+
+// InstanceSpec describes a VM instance created by the provisioner
+type InstanceSpec struct {
+	Name        string
+	MachineType string
+	SourceImage string
+	DiskSizeGb  int64
+	// Network defaults to the default network of the project
+	Network string
+	// ExternalAddress is a reserved static IP; an ephemeral IP is used when empty
+	ExternalAddress string
+	Tags            []string
+	Labels          map[string]string
+	Metadata        map[string]string
+	// ServiceAccount is the email of the service account the instance runs as; it defaults to the
+	// Compute Engine default service account
+	ServiceAccount string
+	Scopes         []string
+}
+
+// newInstance builds the compute API instance for spec in zone
+func newInstance(zone string, spec InstanceSpec) *compute.Instance {
+	network := spec.Network
+	if network == "" {
+		network = "default"
 	}
 	persistentDisk := &compute.AttachedDisk{
-		DeviceName:       "persistent-" + instanceName,
-		Boot:             true,
-		InitializeParams: diskParams,
-		AutoDelete:       true,
-		Type:             "PERSISTENT",
+		DeviceName: "persistent-" + spec.Name,
+		Boot:       true,
+		InitializeParams: &compute.AttachedDiskInitializeParams{
+			DiskSizeGb:  spec.DiskSizeGb,
+			SourceImage: spec.SourceImage,
+		},
+		AutoDelete: true,
+		Type:       "PERSISTENT",
 	}
 	networkAccessConfig := &compute.AccessConfig{
 		Name:        "External NAT",
 		Type:        "ONE_TO_ONE_NAT",
 		NetworkTier: "PREMIUM",
+		NatIP:       spec.ExternalAddress,
 	}
 	networkInterface := &compute.NetworkInterface{
-		Network:       "global/networks/default",
+		Network:       "global/networks/" + network,
 		AccessConfigs: []*compute.AccessConfig{networkAccessConfig},
 	}
 	serviceAccount := &compute.ServiceAccount{
-		Email: "default",
+		Email:  spec.ServiceAccount,
+		Scopes: spec.Scopes,
+	}
+	if serviceAccount.Email == "" {
+		serviceAccount.Email = "default"
+	}
+	metadata := &compute.Metadata{}
+	keys := make([]string, 0, len(spec.Metadata))
+	for key := range spec.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := spec.Metadata[key]
+		metadata.Items = append(metadata.Items, &compute.MetadataItems{Key: key, Value: &value})
+	}
+	return &compute.Instance{
+		Name:              spec.Name,
+		MachineType:       "zones/" + zone + "/machineTypes/" + spec.MachineType,
+		Disks:             []*compute.AttachedDisk{persistentDisk},
+		NetworkInterfaces: []*compute.NetworkInterface{networkInterface},
+		ServiceAccounts:   []*compute.ServiceAccount{serviceAccount},
+		Tags:              &compute.Tags{Items: spec.Tags},
+		Labels:            spec.Labels,
+		Metadata:          metadata,
+	}
+}
+
+// CreateInstance takes in the project and zone to create a standard VM if it doesn't exist and returns an error.
+// It waits for the VM to be RUNNING; the controller calls EnsureInstance instead.
+func CreateInstance(ctx context.Context, instanceName string, project string, zone string, computeService *compute.Service) error {
+	if project == "" {
+		project = os.Getenv("PROJECT")
+	}
+	if zone == "" {
+		zone = os.Getenv("ZONE")
+	}
+	spec := InstanceSpec{
+		Name:        instanceName,
+		MachineType: "n2-standard-8",
+		SourceImage: "projects/cpsg-ai-kubeflow/global/images/github-action-image-from-snapshot",
+		DiskSizeGb:  20,
 		Scopes: []string{
 			"https://www.googleapis.com/auth/devstorage.read_write",
 			"https://www.googleapis.com/auth/logging.write",
 		},
+		Metadata: map[string]string{
+			"ci-instance":    "github-action",
+			"startup-script": "",
+		},
 	}
-	var instanceLabel = "github-action"
-	metadataLabel := &compute.MetadataItems{
-		Key:   "ci-instance",
-		Value: &instanceLabel,
+	waitForCreateOp := func() error {
+		instance, err := EnsureInstance(ctx, project, zone, spec, computeService)
+		if err != nil {
+			return backoff.Permanent(err)
+		}
+		if instance == nil {
+			return fmt.Errorf("VM Instance creation pending")
+		}
+		return nil
 	}
-	startupScript := ""
-	metadataStartupScript := &compute.MetadataItems{
-		Key:   "startup-script",
-		Value: &startupScript,
+	createBackoff := getBackoff(5 * time.Minute)
+	err := backoff.Retry(waitForCreateOp, backoff.WithContext(createBackoff, ctx))
+	if err != nil {
+		return err
 	}
-	// Insert Op to create a new VM if one doesn't exist
-	_, err := computeService.Instances.Get(project, zone, instanceName).Context(ctx).Do()
+	log.Infof("VM Instance Creation Succeeded")
+	return nil
+}
+
+// EnsureInstance creates the instance described by spec unless it exists, without waiting on the operation.
+// It returns the instance once it is RUNNING, or nil while it is still being created.
+func EnsureInstance(ctx context.Context, project string, zone string, spec InstanceSpec, computeService *compute.Service) (*compute.Instance, error) {
+	instance, err := computeService.Instances.Get(project, zone, spec.Name).Context(ctx).Do()
 	if err != nil {
-		log.Infof("Creating VM: %v", instanceName)
-		instance := &compute.Instance{
-			Name:              instanceName,
-			MachineType:       machineType,
-			Disks:             []*compute.AttachedDisk{persistentDisk},
-			NetworkInterfaces: []*compute.NetworkInterface{networkInterface},
-			ServiceAccounts:   []*compute.ServiceAccount{serviceAccount},
-			Metadata: &compute.Metadata{
-				Items: []*compute.MetadataItems{metadataLabel, metadataStartupScript}},
+		if !isNotFound(err) {
+			return nil, fmt.Errorf("Error getting instance %s: %w", spec.Name, err)
 		}
-		_, err := computeService.Instances.Insert(project, zone, instance).Context(ctx).Do()
-		if err != nil {
-			return fmt.Errorf("Error creating new instance: %v", err)
+		log.Infof("Creating VM: %v", spec.Name)
+		_, err := computeService.Instances.Insert(project, zone, newInstance(zone, spec)).Context(ctx).Do()
+		if err != nil && !isAlreadyExists(err) {
+			return nil, fmt.Errorf("Error creating new instance: %w", err)
 		}
-		waitForCreateOp := func() error {
-			getOp, err := computeService.Instances.Get(project, zone, instanceName).Context(ctx).Do()
-			if getOp.Status == "RUNNING" || err != nil {
-				log.Infof("VM Instance creation status: %v", getOp.Status)
-				return nil
-			}
-			log.Infof("VM Instance creation pending, VM status: %v", getOp.Status)
-			return fmt.Errorf("VM Instance creation pending")
+		return nil, nil
+	}
+	if instance.Status != "RUNNING" {
+		log.Infof("VM Instance creation pending, VM status: %v", instance.Status)
+		return nil, nil
+	}
+	return instance, nil
+}
+
+// EnsureInstanceDeleted deletes the instance without waiting on the operation.
+// It returns true once the instance is gone.
+func EnsureInstanceDeleted(ctx context.Context, project string, zone string, instanceName string, computeService *compute.Service) (bool, error) {
+	_, err := computeService.Instances.Get(project, zone, instanceName).Context(ctx).Do()
+	if err != nil {
+		if isNotFound(err) {
+			return true, nil
 		}
-		createBackoff := getBackoff(5 * time.Minute)
-		err = backoff.Retry(waitForCreateOp, createBackoff)
-		if err != nil {
-			return err
+		return false, fmt.Errorf("Error getting instance %s: %w", instanceName, err)
+	}
+	_, err = computeService.Instances.Delete(project, zone, instanceName).Context(ctx).Do()
+	if err != nil && !isNotFound(err) && !isResourceBusy(err) {
+		return false, fmt.Errorf("Error deleting instance %s: %w", instanceName, err)
+	}
+	return false, nil
+}
+
+// EnsureInstanceMetadataRemoved removes a metadata item from the instance without waiting on the operation.
+// It returns true once the instance has no item with the key.
+func EnsureInstanceMetadataRemoved(ctx context.Context, project string, zone string, instanceName string, key string, computeService *compute.Service) (bool, error) {
+	instance, err := computeService.Instances.Get(project, zone, instanceName).Context(ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("Error getting instance %s: %w", instanceName, err)
+	}
+	metadata := instance.Metadata
+	if metadata == nil {
+		return true, nil
+	}
+	items := metadata.Items[:0]
+	for _, item := range metadata.Items {
+		if item.Key != key {
+			items = append(items, item)
 		}
-		log.Infof("VM Instance Creation Succeeded")
 	}
-	return nil
+	if len(items) == len(metadata.Items) {
+		return true, nil
+	}
+	log.Infof("Removing metadata %s of instance %s", key, instanceName)
+	// The fingerprint makes the update fail rather than overwrite a concurrent change
+	_, err = computeService.Instances.SetMetadata(project, zone, instanceName, &compute.Metadata{
+		Fingerprint: metadata.Fingerprint,
+		Items:       items,
+	}).Context(ctx).Do()
+	if err != nil && !isResourceBusy(err) {
+		return false, fmt.Errorf("Error removing metadata %s of instance %s: %w", key, instanceName, err)
+	}
+	return false, nil
 }
 
-// DeleteInstance - Used to delete an Instance
+// DeleteInstance - Used to delete an Instance.
+// It waits for the VM to be gone; the controller calls EnsureInstanceDeleted instead.
 func DeleteInstance(ctx context.Context, instanceName string, project string, zone string, computeService *compute.Service) error {
 	if project == "" {
 		project = os.Getenv("PROJECT")
@@ -133,25 +263,18 @@ func DeleteInstance(ctx context.Context, instanceName string, project string, zo
 	if zone == "" {
 		zone = os.Getenv("ZONE")
 	}
-	_, err := computeService.Instances.Delete(project, zone, instanceName).Context(ctx).Do()
-	if err != nil {
-		return err
-	}
 	waitForDeleteOp := func() error {
-		getOp, err := computeService.Instances.Get(project, zone, instanceName).Context(ctx).Do()
-		if getOp != nil {
-			if getOp.Status == "TERMINATED" || err != nil {
-				log.Infof("VM Instance deletion Status: %v", getOp.Status)
-				return nil
-			}
-		} else {
-			return nil
+		deleted, err := EnsureInstanceDeleted(ctx, project, zone, instanceName, computeService)
+		if err != nil {
+			return backoff.Permanent(err)
+		}
+		if !deleted {
+			return fmt.Errorf("VM Instance deletion pending")
 		}
-		log.Infof("VM Instance deletion pending, VM status: %v", getOp.Status)
-		return fmt.Errorf("VM Instance deletion pending")
+		return nil
 	}
 	deleteBackoff := getBackoff(5 * time.Minute)
-	err = backoff.Retry(waitForDeleteOp, deleteBackoff)
+	err := backoff.Retry(waitForDeleteOp, backoff.WithContext(deleteBackoff, ctx))
 	if err != nil {
 		return err
 	}
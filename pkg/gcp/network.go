@@ -0,0 +1,157 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/compute/metadata"
+	log "github.com/sirupsen/logrus"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// EnsureNetwork creates an auto-mode VPC network unless it exists, without waiting on the operation.
+// It returns true once the network is ready.
+func EnsureNetwork(ctx context.Context, project string, network string, computeService *compute.Service) (bool, error) {
+	_, err := computeService.Networks.Get(project, network).Context(ctx).Do()
+	if err == nil {
+		return true, nil
+	}
+	if !isNotFound(err) {
+		return false, fmt.Errorf("Error getting network %s: %w", network, err)
+	}
+	log.Infof("Creating network: %v", network)
+	_, err = computeService.Networks.Insert(project, &compute.Network{
+		Name:                  network,
+		AutoCreateSubnetworks: true,
+		ForceSendFields:       []string{"AutoCreateSubnetworks"},
+	}).Context(ctx).Do()
+	if err != nil && !isAlreadyExists(err) {
+		return false, fmt.Errorf("Error creating network %s: %w", network, err)
+	}
+	return false, nil
+}
+
+// EnsureFirewall creates the firewall rule unless it exists, without waiting on the operation.
+// The source ranges of an existing rule are updated to those of firewall. It returns true once the rule is ready.
+func EnsureFirewall(ctx context.Context, project string, firewall *compute.Firewall, computeService *compute.Service) (bool, error) {
+	existing, err := computeService.Firewalls.Get(project, firewall.Name).Context(ctx).Do()
+	if err == nil {
+		if sameRanges(existing.SourceRanges, firewall.SourceRanges) {
+			return true, nil
+		}
+		log.Infof("Updating source ranges of firewall %v to %v", firewall.Name, firewall.SourceRanges)
+		_, err := computeService.Firewalls.Patch(project, firewall.Name, &compute.Firewall{SourceRanges: firewall.SourceRanges}).Context(ctx).Do()
+		if err != nil && !isResourceBusy(err) {
+			return false, fmt.Errorf("Error updating firewall %s: %w", firewall.Name, err)
+		}
+		return false, nil
+	}
+	if !isNotFound(err) {
+		return false, fmt.Errorf("Error getting firewall %s: %w", firewall.Name, err)
+	}
+	log.Infof("Creating firewall: %v", firewall.Name)
+	_, err = computeService.Firewalls.Insert(project, firewall).Context(ctx).Do()
+	if err != nil && !isAlreadyExists(err) {
+		return false, fmt.Errorf("Error creating firewall %s: %w", firewall.Name, err)
+	}
+	return false, nil
+}
+
+// sameRanges reports whether two lists hold the same ranges, in any order
+func sameRanges(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	ranges := map[string]bool{}
+	for _, r := range a {
+		ranges[r] = true
+	}
+	for _, r := range b {
+		if !ranges[r] {
+			return false
+		}
+	}
+	return true
+}
+
+// ControllerSourceRanges returns the external IP of the GCE instance the controller runs on as a firewall source range.
+// It fails off GCE and on instances without an external IP, such as those behind Cloud NAT.
+func ControllerSourceRanges() ([]string, error) {
+	if !metadata.OnGCE() {
+		return nil, fmt.Errorf("the controller doesn't run on GCE; set the source ranges of the API server")
+	}
+	ip, err := metadata.ExternalIP()
+	if err != nil || ip == "" {
+		return nil, fmt.Errorf("the controller instance has no external IP; set the source ranges of the API server")
+	}
+	return []string{ip + "/32"}, nil
+}
+
+// EnsureAddress reserves a static external IP unless it exists, without waiting on the operation.
+// It returns the IP once it is reserved, or an empty string while it is pending.
+func EnsureAddress(ctx context.Context, project string, region string, name string, computeService *compute.Service) (string, error) {
+	address, err := computeService.Addresses.Get(project, region, name).Context(ctx).Do()
+	if err == nil {
+		if address.Status == "RESERVING" {
+			return "", nil
+		}
+		return address.Address, nil
+	}
+	if !isNotFound(err) {
+		return "", fmt.Errorf("Error getting address %s: %w", name, err)
+	}
+	log.Infof("Reserving address: %v", name)
+	_, err = computeService.Addresses.Insert(project, region, &compute.Address{Name: name}).Context(ctx).Do()
+	if err != nil && !isAlreadyExists(err) {
+		return "", fmt.Errorf("Error reserving address %s: %w", name, err)
+	}
+	return "", nil
+}
+
+// EnsureNetworkDeleted deletes the network and returns true once it is gone
+func EnsureNetworkDeleted(ctx context.Context, project string, network string, computeService *compute.Service) (bool, error) {
+	return ensureDeleted(network, func() error {
+		_, err := computeService.Networks.Get(project, network).Context(ctx).Do()
+		return err
+	}, func() error {
+		_, err := computeService.Networks.Delete(project, network).Context(ctx).Do()
+		return err
+	})
+}
+
+// EnsureFirewallDeleted deletes the firewall rule and returns true once it is gone
+func EnsureFirewallDeleted(ctx context.Context, project string, firewall string, computeService *compute.Service) (bool, error) {
+	return ensureDeleted(firewall, func() error {
+		_, err := computeService.Firewalls.Get(project, firewall).Context(ctx).Do()
+		return err
+	}, func() error {
+		_, err := computeService.Firewalls.Delete(project, firewall).Context(ctx).Do()
+		return err
+	})
+}
+
+// EnsureAddressDeleted releases the static IP and returns true once it is gone
+func EnsureAddressDeleted(ctx context.Context, project string, region string, name string, computeService *compute.Service) (bool, error) {
+	return ensureDeleted(name, func() error {
+		_, err := computeService.Addresses.Get(project, region, name).Context(ctx).Do()
+		return err
+	}, func() error {
+		_, err := computeService.Addresses.Delete(project, region, name).Context(ctx).Do()
+		return err
+	})
+}
+
+// ensureDeleted issues del unless get reports the resource is gone
+func ensureDeleted(name string, get func() error, del func() error) (bool, error) {
+	if err := get(); err != nil {
+		if isNotFound(err) {
+			return true, nil
+		}
+		return false, fmt.Errorf("Error getting %s: %w", name, err)
+	}
+	log.Infof("Deleting: %v", name)
+	if err := del(); err != nil && !isNotFound(err) && !isResourceBusy(err) {
+		return false, fmt.Errorf("Error deleting %s: %w", name, err)
+	}
+	return false, nil
+}
@@ -0,0 +1,449 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/CiscoAI/kf-cluster-api/pkg/provision"
+	log "github.com/sirupsen/logrus"
+	compute "google.golang.org/api/compute/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Names of the provisioning stages, in the order they run
+const (
+	StageNetwork             = "Network"
+	StageControlPlaneAddress = "ControlPlaneAddress"
+	StageControlPlane        = "ControlPlane"
+	StageAPIServer           = "APIServer"
+	StageWorkers             = "Workers"
+)
+
+const (
+	defaultMachineType = "n1-standard-4"
+	defaultNodeCount   = int32(2)
+	nodeImage          = "projects/ubuntu-os-cloud/global/images/family/ubuntu-1804-lts"
+	// autoModeSubnets covers the subnets of auto-mode VPC networks
+	autoModeSubnets = "10.128.0.0/9"
+	// bootstrapTokenTTL is how long the bootstrap token in the worker metadata can join nodes after it was last renewed
+	bootstrapTokenTTL = 24 * time.Hour
+	// bootstrapTokenRenewal is how much validity the token must have left when workers are created
+	bootstrapTokenRenewal = time.Hour
+)
+
+// NodeScopes are the OAuth scopes of the nodes: they ship logs and metrics and pull images from GCR.
+// Nodes don't call other GCP APIs, as the clusters run without a cloud provider.
+var NodeScopes = []string{
+	"https://www.googleapis.com/auth/logging.write",
+	"https://www.googleapis.com/auth/monitoring.write",
+	"https://www.googleapis.com/auth/devstorage.read_only",
+}
+
+// ClusterConfig describes a kubeadm cluster provisioned on GCE
+type ClusterConfig struct {
+	Name    string
+	Project string
+	Zone    string
+	// Region defaults to the region of Zone
+	Region string
+	// Network defaults to a network dedicated to the cluster, which is deleted with it
+	Network           string
+	NodeCount         int32
+	MachineType       string
+	KubernetesVersion string
+	// SourceRanges are allowed to reach the API server and SSH; they default to ControllerSourceRanges
+	SourceRanges []string
+	// NodeServiceAccount is the service account the instances run as, with NodeScopes;
+	// it defaults to the Compute Engine default service account
+	NodeServiceAccount string
+}
+
+// Provisioner creates a kubeadm cluster from GCE instances, bootstrapped through startup-script metadata
+type Provisioner struct {
+	config         ClusterConfig
+	ownsNetwork    bool
+	pki            *provision.ClusterPKI
+	computeService *compute.Service
+
+	// Observed while the stages run
+	externalAddress string
+	controlPlaneIP  string
+	kubeconfig      []byte
+}
+
+// NewProvisioner returns a Provisioner for config, filling in defaults
+func NewProvisioner(config ClusterConfig, pki *provision.ClusterPKI, computeService *compute.Service) *Provisioner {
+	p := &Provisioner{config: config, pki: pki, computeService: computeService}
+	if p.config.Region == "" {
+		if i := strings.LastIndex(p.config.Zone, "-"); i > 0 {
+			p.config.Region = p.config.Zone[:i]
+		}
+	}
+	if p.config.Network == "" {
+		p.config.Network = config.Name + "-net"
+		p.ownsNetwork = true
+	}
+	if p.config.NodeCount == 0 {
+		p.config.NodeCount = defaultNodeCount
+	}
+	if p.config.MachineType == "" {
+		p.config.MachineType = defaultMachineType
+	}
+	if p.config.KubernetesVersion == "" {
+		p.config.KubernetesVersion = provision.DefaultKubernetesVersion
+	}
+	return p
+}
+
+// Stages returns the provisioning stages in the order they must run
+func (p *Provisioner) Stages() []provision.Stage {
+	return []provision.Stage{
+		{Name: StageNetwork, Run: p.ensureNetwork},
+		{Name: StageControlPlaneAddress, Run: p.ensureControlPlaneAddress},
+		{Name: StageControlPlane, Run: p.ensureControlPlane},
+		{Name: StageAPIServer, Run: p.ensureAPIServer},
+		{Name: StageWorkers, Run: p.ensureWorkers},
+	}
+}
+
+// TeardownStages returns the stages deleting the cluster, in the order they must run
+func (p *Provisioner) TeardownStages() []provision.Stage {
+	return []provision.Stage{
+		{Name: StageWorkers, Run: p.deleteWorkers},
+		{Name: StageControlPlane, Run: func(ctx context.Context) (bool, error) {
+			return EnsureInstanceDeleted(ctx, p.config.Project, p.config.Zone, p.controlPlaneName(), p.computeService)
+		}},
+		{Name: StageControlPlaneAddress, Run: func(ctx context.Context) (bool, error) {
+			return EnsureAddressDeleted(ctx, p.config.Project, p.config.Region, p.controlPlaneName(), p.computeService)
+		}},
+		{Name: StageNetwork, Run: p.deleteNetwork},
+	}
+}
+
+// Kubeconfig returns the admin kubeconfig once the APIServer stage has completed
+func (p *Provisioner) Kubeconfig() []byte {
+	return p.kubeconfig
+}
+
+func (p *Provisioner) tag() string {
+	return "kfcluster-" + p.config.Name
+}
+
+func (p *Provisioner) controlPlaneName() string {
+	return p.config.Name + "-control-plane"
+}
+
+func (p *Provisioner) workerName(i int32) string {
+	return fmt.Sprintf("%s-worker-%d", p.config.Name, i)
+}
+
+// firewalls returns the firewall rules of the cluster, letting sourceRanges reach the API server and SSH
+func (p *Provisioner) firewalls(sourceRanges []string) []*compute.Firewall {
+	networkURL := "global/networks/" + p.config.Network
+	return []*compute.Firewall{
+		{
+			Name:         p.config.Name + "-internal",
+			Network:      networkURL,
+			SourceRanges: []string{autoModeSubnets, provision.PodSubnet},
+			TargetTags:   []string{p.tag()},
+			Allowed: []*compute.FirewallAllowed{
+				{IPProtocol: "tcp"}, {IPProtocol: "udp"}, {IPProtocol: "icmp"}, {IPProtocol: "ipip"},
+			},
+		},
+		{
+			Name:         p.config.Name + "-external",
+			Network:      networkURL,
+			SourceRanges: sourceRanges,
+			TargetTags:   []string{p.tag()},
+			Allowed: []*compute.FirewallAllowed{
+				{IPProtocol: "tcp", Ports: []string{"22", "6443"}},
+			},
+		},
+	}
+}
+
+func (p *Provisioner) ensureNetwork(ctx context.Context) (bool, error) {
+	ready, err := EnsureNetwork(ctx, p.config.Project, p.config.Network, p.computeService)
+	if err != nil || !ready {
+		return false, err
+	}
+	sourceRanges := p.config.SourceRanges
+	if len(sourceRanges) == 0 {
+		sourceRanges, err = ControllerSourceRanges()
+		if err != nil {
+			return false, err
+		}
+	}
+	for _, firewall := range p.firewalls(sourceRanges) {
+		ready, err := EnsureFirewall(ctx, p.config.Project, firewall, p.computeService)
+		if err != nil || !ready {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (p *Provisioner) ensureControlPlaneAddress(ctx context.Context) (bool, error) {
+	address, err := EnsureAddress(ctx, p.config.Project, p.config.Region, p.controlPlaneName(), p.computeService)
+	if err != nil || address == "" {
+		return false, err
+	}
+	p.externalAddress = address
+	return true, nil
+}
+
+func (p *Provisioner) ensureControlPlane(ctx context.Context) (bool, error) {
+	script, err := renderScript(controlPlaneScript, startupScriptValues{
+		ClusterName:       p.config.Name,
+		KubernetesVersion: p.config.KubernetesVersion,
+		BootstrapToken:    p.pki.BootstrapToken,
+		BootstrapTokenTTL: bootstrapTokenTTL.String(),
+		ExternalAddress:   p.externalAddress,
+		PodSubnet:         provision.PodSubnet,
+		CNIManifest:       provision.CNIManifest,
+	})
+	if err != nil {
+		return false, err
+	}
+	instance, err := EnsureInstance(ctx, p.config.Project, p.config.Zone, InstanceSpec{
+		Name:            p.controlPlaneName(),
+		MachineType:     p.config.MachineType,
+		SourceImage:     nodeImage,
+		DiskSizeGb:      50,
+		Network:         p.config.Network,
+		ExternalAddress: p.externalAddress,
+		Tags:            []string{p.tag()},
+		Labels:          map[string]string{"kfcluster": p.config.Name, "role": "control-plane"},
+		ServiceAccount:  p.config.NodeServiceAccount,
+		Scopes:          NodeScopes,
+		// Anyone who can read the instance can read its metadata, so ensureAPIServer removes the CA key
+		// as soon as kubeadm init has copied it to the control plane
+		Metadata: map[string]string{
+			metadataStartupScript: script,
+			metadataCACert:        string(p.pki.CACert),
+			metadataCAKey:         string(p.pki.CAKey),
+		},
+	}, p.computeService)
+	if err != nil || instance == nil {
+		return false, err
+	}
+	if len(instance.NetworkInterfaces) == 0 {
+		return false, fmt.Errorf("control plane instance %s has no network interface", instance.Name)
+	}
+	p.controlPlaneIP = instance.NetworkInterfaces[0].NetworkIP
+	return true, nil
+}
+
+// ensureAPIServer completes once kubeadm init has finished, the API server answers with the minted credentials
+// and the CA key has been removed from the metadata of the control plane
+func (p *Provisioner) ensureAPIServer(ctx context.Context) (bool, error) {
+	kubeconfig, err := p.pki.AdminKubeconfig(p.config.Name, "https://"+p.externalAddress+":6443")
+	if err != nil {
+		return false, err
+	}
+	client, err := p.kubeClient(kubeconfig)
+	if err != nil {
+		return false, err
+	}
+	if _, err := client.Discovery().ServerVersion(); err != nil {
+		log.Infof("API server of %s not reachable yet: %v", p.config.Name, err)
+		return false, nil
+	}
+	removed, err := EnsureInstanceMetadataRemoved(ctx, p.config.Project, p.config.Zone, p.controlPlaneName(), metadataCAKey, p.computeService)
+	if err != nil || !removed {
+		return false, err
+	}
+	p.kubeconfig = kubeconfig
+	return true, nil
+}
+
+// ensureWorkers completes once every worker instance runs and has joined as a Ready node,
+// and the workers beyond the node count have been deleted
+func (p *Provisioner) ensureWorkers(ctx context.Context) (bool, error) {
+	caCertHash, err := p.pki.CACertHash()
+	if err != nil {
+		return false, err
+	}
+	script, err := renderScript(workerScript, startupScriptValues{
+		KubernetesVersion:   p.config.KubernetesVersion,
+		BootstrapToken:      p.pki.BootstrapToken,
+		CACertHash:          caCertHash,
+		ControlPlaneAddress: p.controlPlaneIP,
+	})
+	if err != nil {
+		return false, err
+	}
+	client, err := p.kubeClient(p.kubeconfig)
+	if err != nil {
+		return false, err
+	}
+	// Workers beyond the node count are left over from a larger cluster
+	workers, err := p.workers(ctx)
+	if err != nil {
+		return false, err
+	}
+	desired := map[string]bool{}
+	for i := int32(0); i < p.config.NodeCount; i++ {
+		desired[p.workerName(i)] = true
+	}
+	removed := true
+	for _, worker := range workers {
+		if desired[worker.Name] {
+			continue
+		}
+		gone, err := p.deleteWorker(ctx, client, worker.Name)
+		if err != nil {
+			return false, err
+		}
+		removed = removed && gone
+	}
+	// Workers that don't exist yet join with the bootstrap token, which must still be valid then
+	for _, worker := range workers {
+		delete(desired, worker.Name)
+	}
+	if len(desired) > 0 {
+		if err := p.renewBootstrapToken(client); err != nil {
+			return false, err
+		}
+	}
+	running := removed
+	for i := int32(0); i < p.config.NodeCount; i++ {
+		instance, err := EnsureInstance(ctx, p.config.Project, p.config.Zone, InstanceSpec{
+			Name:           p.workerName(i),
+			MachineType:    p.config.MachineType,
+			SourceImage:    nodeImage,
+			DiskSizeGb:     100,
+			Network:        p.config.Network,
+			Tags:           []string{p.tag()},
+			Labels:         map[string]string{"kfcluster": p.config.Name, "role": "worker"},
+			ServiceAccount: p.config.NodeServiceAccount,
+			Scopes:         NodeScopes,
+			Metadata:       map[string]string{metadataStartupScript: script},
+		}, p.computeService)
+		if err != nil {
+			return false, err
+		}
+		running = running && instance != nil
+	}
+	if !running {
+		return false, nil
+	}
+	nodes, err := client.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		log.Infof("Error listing nodes of %s: %v", p.config.Name, err)
+		return false, nil
+	}
+	ready := int32(0)
+	for _, node := range nodes.Items {
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == corev1.NodeReady && condition.Status == corev1.ConditionTrue {
+				ready++
+			}
+		}
+	}
+	// The control plane node counts towards the Ready nodes too
+	return ready >= p.config.NodeCount+1, nil
+}
+
+// renewBootstrapToken makes the bootstrap token valid for at least bootstrapTokenRenewal, through its Secret
+// in kube-system. Expired tokens are deleted by the cluster, and created again here.
+func (p *Provisioner) renewBootstrapToken(client kubernetes.Interface) error {
+	parts := strings.SplitN(p.pki.BootstrapToken, ".", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("the bootstrap token of %s is malformed", p.config.Name)
+	}
+	expiration := []byte(time.Now().Add(bootstrapTokenTTL).UTC().Format(time.RFC3339))
+	secrets := client.CoreV1().Secrets(metav1.NamespaceSystem)
+	secret, err := secrets.Get("bootstrap-token-"+parts[0], metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		log.Infof("Creating bootstrap token of %s", p.config.Name)
+		_, err = secrets.Create(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "bootstrap-token-" + parts[0], Namespace: metav1.NamespaceSystem},
+			Type:       corev1.SecretTypeBootstrapToken,
+			Data: map[string][]byte{
+				"token-id":                       []byte(parts[0]),
+				"token-secret":                   []byte(parts[1]),
+				"expiration":                     expiration,
+				"usage-bootstrap-authentication": []byte("true"),
+				"usage-bootstrap-signing":        []byte("true"),
+				"auth-extra-groups":              []byte("system:bootstrappers:kubeadm:default-node-token"),
+			},
+		})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if expires, err := time.Parse(time.RFC3339, string(secret.Data["expiration"])); err == nil && time.Until(expires) > bootstrapTokenRenewal {
+		return nil
+	}
+	log.Infof("Renewing bootstrap token of %s", p.config.Name)
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data["expiration"] = expiration
+	_, err = secrets.Update(secret)
+	return err
+}
+
+// workers lists the worker instances of the cluster by label, so that none is missed whatever its index
+func (p *Provisioner) workers(ctx context.Context) ([]*compute.Instance, error) {
+	labels := map[string]string{"kfcluster": p.config.Name, "role": "worker"}
+	return ListInstancesByLabel(ctx, p.config.Project, p.config.Zone, labels, p.computeService)
+}
+
+// deleteWorker deletes a worker instance, then its node once the instance is gone so that the kubelet can't register it again
+func (p *Provisioner) deleteWorker(ctx context.Context, client kubernetes.Interface, name string) (bool, error) {
+	gone, err := EnsureInstanceDeleted(ctx, p.config.Project, p.config.Zone, name, p.computeService)
+	if err != nil || !gone {
+		return false, err
+	}
+	if err := client.CoreV1().Nodes().Delete(name, &metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return false, err
+	}
+	return true, nil
+}
+
+func (p *Provisioner) deleteWorkers(ctx context.Context) (bool, error) {
+	workers, err := p.workers(ctx)
+	if err != nil {
+		return false, err
+	}
+	deleted := true
+	for _, worker := range workers {
+		gone, err := EnsureInstanceDeleted(ctx, p.config.Project, p.config.Zone, worker.Name, p.computeService)
+		if err != nil {
+			return false, err
+		}
+		deleted = deleted && gone
+	}
+	return deleted, nil
+}
+
+func (p *Provisioner) deleteNetwork(ctx context.Context) (bool, error) {
+	for _, firewall := range p.firewalls(nil) {
+		gone, err := EnsureFirewallDeleted(ctx, p.config.Project, firewall.Name, p.computeService)
+		if err != nil || !gone {
+			return false, err
+		}
+	}
+	if !p.ownsNetwork {
+		return true, nil
+	}
+	return EnsureNetworkDeleted(ctx, p.config.Project, p.config.Network, p.computeService)
+}
+
+func (p *Provisioner) kubeClient(kubeconfig []byte) (kubernetes.Interface, error) {
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	restConfig.Timeout = 10 * time.Second
+	return kubernetes.NewForConfig(restConfig)
+}
@@ -0,0 +1,90 @@
+package gcp
+
+import (
+	"bytes"
+	"text/template"
+
+	"github.com/CiscoAI/kf-cluster-api/pkg/provision"
+)
+
+// Instance metadata keys read by the startup scripts
+const (
+	metadataStartupScript = "startup-script"
+	metadataCACert        = "kfcluster-ca-crt"
+	metadataCAKey         = "kfcluster-ca-key"
+)
+
+// blockMetadataScript drops the traffic of pods to the metadata server, so workloads can't get tokens of the
+// node service account or read the instance metadata. Calico attaches pods to cali interfaces, and drops in the
+// mangle table happen before the forwarding rules of Calico accept their traffic. Pods on the host network still
+// reach the metadata server. The rule doesn't survive reboots, so the scripts add it on every boot.
+const blockMetadataScript = `iptables -t mangle -C PREROUTING -i cali+ -d 169.254.169.254/32 -j DROP 2>/dev/null ||
+  iptables -t mangle -I PREROUTING -i cali+ -d 169.254.169.254/32 -j DROP`
+
+// controlPlaneScript runs kubeadm init with the CA generated by the provisioner.
+// Apart from blocking the metadata server, it is a no-op when the node was already initialized,
+// so instance restarts are safe.
+var controlPlaneScript = template.Must(template.New("control-plane").Parse(`#!/bin/bash
+set -euxo pipefail
+` + blockMetadataScript + `
+if [ -f /etc/kubernetes/admin.conf ]; then
+  exit 0
+fi
+` + provision.InstallScript + `
+METADATA=http://metadata.google.internal/computeMetadata/v1/instance/attributes
+mkdir -p /etc/kubernetes/pki
+curl -sf -H "Metadata-Flavor: Google" "${METADATA}/` + metadataCACert + `" > /etc/kubernetes/pki/ca.crt
+curl -sf -H "Metadata-Flavor: Google" "${METADATA}/` + metadataCAKey + `" > /etc/kubernetes/pki/ca.key
+chmod 600 /etc/kubernetes/pki/ca.key
+cat > /etc/kubernetes/kubeadm.yaml <<EOF
+apiVersion: kubeadm.k8s.io/v1beta2
+kind: InitConfiguration
+bootstrapTokens:
+- token: "{{.BootstrapToken}}"
+  ttl: "{{.BootstrapTokenTTL}}"
+---
+apiVersion: kubeadm.k8s.io/v1beta2
+kind: ClusterConfiguration
+clusterName: {{.ClusterName}}
+kubernetesVersion: v{{.KubernetesVersion}}
+apiServer:
+  certSANs:
+  - "{{.ExternalAddress}}"
+networking:
+  podSubnet: {{.PodSubnet}}
+EOF
+kubeadm init --config /etc/kubernetes/kubeadm.yaml
+kubectl --kubeconfig /etc/kubernetes/admin.conf apply -f {{.CNIManifest}}
+`))
+
+// workerScript joins the node to the control plane, pinning the cluster CA
+var workerScript = template.Must(template.New("worker").Parse(`#!/bin/bash
+set -euxo pipefail
+` + blockMetadataScript + `
+if [ -f /etc/kubernetes/kubelet.conf ]; then
+  exit 0
+fi
+` + provision.InstallScript + `
+kubeadm join {{.ControlPlaneAddress}}:6443 --token "{{.BootstrapToken}}" --discovery-token-ca-cert-hash "{{.CACertHash}}"
+`))
+
+// startupScriptValues are the values rendered into the startup scripts
+type startupScriptValues struct {
+	ClusterName         string
+	KubernetesVersion   string
+	BootstrapToken      string
+	BootstrapTokenTTL   string
+	CACertHash          string
+	ExternalAddress     string
+	ControlPlaneAddress string
+	PodSubnet           string
+	CNIManifest         string
+}
+
+func renderScript(script *template.Template, values startupScriptValues) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := script.Execute(buf, values); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,156 @@
+// Package healthz serves the liveness and readiness probes of the controller manager.
+// controller-runtime v0.2 has no health endpoints, so the manager runs this server next to it.
+package healthz
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// Paths of the probes
+const (
+	LivenessPath  = "/healthz"
+	ReadinessPath = "/readyz"
+)
+
+// checkTimeout bounds the checks that reach out to other endpoints
+const checkTimeout = 5 * time.Second
+
+// Checker reports why a component is unhealthy, or nil when it is healthy
+type Checker func(req *http.Request) error
+
+// Ping is a Checker that always passes, which tells that the process serves requests
+func Ping(req *http.Request) error {
+	return nil
+}
+
+type namedCheck struct {
+	name  string
+	check Checker
+}
+
+// Server serves the liveness checks on LivenessPath and the readiness checks on ReadinessPath
+type Server struct {
+	Addr string
+	Log  logr.Logger
+
+	mu        sync.Mutex
+	liveness  []namedCheck
+	readiness []namedCheck
+}
+
+// AddLivenessCheck adds a check that fails the liveness probe
+func (s *Server) AddLivenessCheck(name string, check Checker) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.liveness = append(s.liveness, namedCheck{name: name, check: check})
+}
+
+// AddReadinessCheck adds a check that fails the readiness probe
+func (s *Server) AddReadinessCheck(name string, check Checker) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readiness = append(s.readiness, namedCheck{name: name, check: check})
+}
+
+// Start serves the probes until stop is closed.
+// It is run next to the manager rather than by it, since the manager starts its runnables only once
+// its cache has synced, and the liveness probe must answer before that.
+func (s *Server) Start(stop <-chan struct{}) error {
+	mux := http.NewServeMux()
+	mux.Handle(LivenessPath, s.handler(func() []namedCheck { return s.liveness }))
+	mux.Handle(ReadinessPath, s.handler(func() []namedCheck { return s.readiness }))
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: mux}
+	go func() {
+		<-stop
+		if err := server.Shutdown(context.Background()); err != nil {
+			s.Log.Error(err, "error shutting down the health probe server")
+		}
+	}()
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// handler runs the checks and answers 200 when all of them pass, or 500 listing the ones that failed
+func (s *Server) handler(checks func() []namedCheck) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s.mu.Lock()
+		current := append([]namedCheck{}, checks()...)
+		s.mu.Unlock()
+		var failures []string
+		for _, c := range current {
+			if err := c.check(req); err != nil {
+				s.Log.Info("health check failed", "path", req.URL.Path, "check", c.name, "error", err.Error())
+				failures = append(failures, fmt.Sprintf("%s: %v", c.name, err))
+			}
+		}
+		if len(failures) > 0 {
+			http.Error(w, strings.Join(failures, "\n"), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	})
+}
+
+// CacheSynced tracks the sync of the manager cache. Add it to the manager as a Runnable:
+// the manager starts it once its cache has synced, and Check fails until then.
+type CacheSynced struct {
+	synced int32
+}
+
+// Start marks the cache synced and blocks until stop is closed
+func (c *CacheSynced) Start(stop <-chan struct{}) error {
+	atomic.StoreInt32(&c.synced, 1)
+	<-stop
+	return nil
+}
+
+// NeedLeaderElection tells the manager to start CacheSynced on replicas that aren't the leader too
+func (c *CacheSynced) NeedLeaderElection() bool {
+	return false
+}
+
+// Check fails until the cache has synced
+func (c *CacheSynced) Check(req *http.Request) error {
+	if atomic.LoadInt32(&c.synced) == 0 {
+		return fmt.Errorf("the informer cache has not synced yet")
+	}
+	return nil
+}
+
+// TLSServing returns a Checker that completes a TLS handshake with the server at addr,
+// which tells that it listens and has loaded its certificate
+func TLSServing(addr string) Checker {
+	return func(req *http.Request) error {
+		dialer := &net.Dialer{Timeout: checkTimeout}
+		conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// Func adapts a check that doesn't need the request into a Checker, bounding it with a timeout
+func Func(check func(ctx context.Context) error) Checker {
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), checkTimeout)
+		defer cancel()
+		return check(ctx)
+	}
+}
@@ -0,0 +1,114 @@
+// Package compatibility holds the compatibility matrix of Kubeflow releases: the Kubernetes versions
+// each release supports and the applications it ships.
+// It has no dependencies on the API types so that the API webhook can consult it.
+package compatibility
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// MatrixVersion identifies the revision of the matrix compiled into the binaries; bump it with every change to releases
+const MatrixVersion = "2020.03.1"
+
+// Release describes what a Kubeflow release supports
+type Release struct {
+	Version string
+	// MinKubernetes and MaxKubernetes bound the supported Kubernetes minor versions, inclusive
+	MinKubernetes string
+	MaxKubernetes string
+	// Apps are the names of the applications of the release's KfDef, sorted
+	Apps []string
+}
+
+// Applications shared by the releases
+var (
+	apps06 = []string{
+		"application", "application-crds", "argo", "centraldashboard", "istio", "istio-crds", "istio-install",
+		"jupyter-web-app", "katib", "metacontroller", "metadata", "notebook-controller", "pipeline", "profiles",
+		"pytorch-job-crds", "pytorch-operator", "seldon-core-operator", "spartakus", "tensorboard",
+		"tf-job-crds", "tf-job-operator", "webhook",
+	}
+	apps07 = append([]string{
+		"cert-manager", "cert-manager-crds", "kfserving", "kfserving-crds", "knative-crds", "knative-install",
+	}, apps06...)
+	apps10 = append([]string{"kubeflow-roles", "mpi-job"}, apps07...)
+)
+
+// releases are the known Kubeflow releases, oldest first
+var releases = []Release{
+	{Version: "v0.6", MinKubernetes: "1.11", MaxKubernetes: "1.14", Apps: sorted(apps06)},
+	{Version: "v0.7", MinKubernetes: "1.12", MaxKubernetes: "1.15", Apps: sorted(apps07)},
+	{Version: "v1.0", MinKubernetes: "1.14", MaxKubernetes: "1.15", Apps: sorted(apps10)},
+}
+
+// latest is the Kubeflow version that stands for the newest release
+const latest = "latest"
+
+func sorted(values []string) []string {
+	result := append([]string{}, values...)
+	sort.Strings(result)
+	return result
+}
+
+// Releases returns the known releases, oldest first
+func Releases() []Release {
+	result := make([]Release, len(releases))
+	for i, release := range releases {
+		result[i] = release
+		result[i].Apps = append([]string{}, release.Apps...)
+	}
+	return result
+}
+
+// FindRelease returns the release a Kubeflow version belongs to, matching on its minor version.
+// "latest" resolves to the newest release.
+func FindRelease(kfVersion string) (*Release, error) {
+	if kfVersion == latest {
+		release := releases[len(releases)-1]
+		return &release, nil
+	}
+	v, err := version.ParseGeneric(kfVersion)
+	if err != nil {
+		return nil, err
+	}
+	for _, release := range releases {
+		r := version.MustParseGeneric(release.Version)
+		if r.Major() == v.Major() && r.Minor() == v.Minor() {
+			found := release
+			return &found, nil
+		}
+	}
+	return nil, fmt.Errorf("Kubeflow %s is not a known release", kfVersion)
+}
+
+// CheckKubernetes checks that the release supports a Kubernetes version
+func (r *Release) CheckKubernetes(kubernetesVersion string) error {
+	v, err := version.ParseGeneric(kubernetesVersion)
+	if err != nil {
+		return fmt.Errorf("Kubernetes version %q is invalid: %v", kubernetesVersion, err)
+	}
+	minor := version.MustParseGeneric(fmt.Sprintf("%d.%d", v.Major(), v.Minor()))
+	if minor.LessThan(version.MustParseGeneric(r.MinKubernetes)) || version.MustParseGeneric(r.MaxKubernetes).LessThan(minor) {
+		return fmt.Errorf("Kubeflow %s supports Kubernetes %s to %s, not %s", r.Version, r.MinKubernetes, r.MaxKubernetes, kubernetesVersion)
+	}
+	return nil
+}
+
+// CheckApps checks that the release ships every app
+func (r *Release) CheckApps(apps []string) error {
+	var unknown []string
+	for _, app := range apps {
+		i := sort.SearchStrings(r.Apps, app)
+		if i == len(r.Apps) || r.Apps[i] != app {
+			unknown = append(unknown, app)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("Kubeflow %s has no app %s", r.Version, strings.Join(unknown, ", "))
+	}
+	return nil
+}
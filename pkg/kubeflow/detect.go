@@ -0,0 +1,106 @@
+package kubeflow
+
+import (
+	"sort"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Namespace is where Kubeflow is installed
+const Namespace = "kubeflow"
+
+// versionLabel is the recommended label Kubeflow components carry their version in
+const versionLabel = "app.kubernetes.io/version"
+
+// kfDefVersions are the versions of the KfDef API written by kfctl, newest first
+var kfDefVersions = []schema.GroupVersionResource{
+	{Group: "kfdef.apps.kubeflow.org", Version: "v1", Resource: "kfdefs"},
+	{Group: "kfdef.apps.kubeflow.org", Version: "v1beta1", Resource: "kfdefs"},
+	{Group: "kfdef.apps.kubeflow.org", Version: "v1alpha1", Resource: "kfdefs"},
+}
+
+// Installation describes the Kubeflow found on a cluster
+type Installation struct {
+	Version string
+	Apps    []string
+}
+
+// DetectInstallation finds the Kubeflow installed on the cluster of kubeconfig.
+// It reads the KfDef kfctl leaves on the cluster, and falls back to the deployments of the Kubeflow namespace.
+// It returns nil when Kubeflow isn't installed.
+func DetectInstallation(kubeconfig []byte) (*Installation, error) {
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	restConfig.Timeout = 30 * time.Second
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	for _, gvr := range kfDefVersions {
+		kfDefs, err := dynamicClient.Resource(gvr).Namespace(metav1.NamespaceAll).List(metav1.ListOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if len(kfDefs.Items) > 0 {
+			return installationFromKfDef(&kfDefs.Items[0]), nil
+		}
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	deployments, err := client.AppsV1().Deployments(Namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if len(deployments.Items) == 0 {
+		return nil, nil
+	}
+	installation := &Installation{}
+	versions := map[string]int{}
+	for _, deployment := range deployments.Items {
+		installation.Apps = append(installation.Apps, deployment.Name)
+		if version := deployment.Labels[versionLabel]; version != "" {
+			versions[version]++
+		}
+	}
+	// Components may lag behind; the version most of them carry is the installed one
+	for version, count := range versions {
+		if count > versions[installation.Version] || (count == versions[installation.Version] && version > installation.Version) {
+			installation.Version = version
+		}
+	}
+	sort.Strings(installation.Apps)
+	return installation, nil
+}
+
+// installationFromKfDef reads the version and applications of a KfDef
+func installationFromKfDef(kfDef *unstructured.Unstructured) *Installation {
+	installation := &Installation{}
+	installation.Version, _, _ = unstructured.NestedString(kfDef.Object, "spec", "version")
+	if installation.Version == "" {
+		installation.Version = kfDef.GetLabels()[versionLabel]
+	}
+	applications, _, _ := unstructured.NestedSlice(kfDef.Object, "spec", "applications")
+	for _, application := range applications {
+		if app, ok := application.(map[string]interface{}); ok {
+			if name, ok := app["name"].(string); ok {
+				installation.Apps = append(installation.Apps, name)
+			}
+		}
+	}
+	sort.Strings(installation.Apps)
+	return installation
+}
@@ -15,9 +15,3 @@ func DeleteKubeflow(kfCluster *cluster.KfCluster) error {
 
 	return nil
 }
-
-// UpgradeKubeflow - takes in a Kubeflow cluster and upgrades Kubeflow on it
-func UpgradeKubeflow(kfCluster *cluster.KfCluster) error {
-
-	return nil
-}
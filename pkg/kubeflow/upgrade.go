@@ -0,0 +1,80 @@
+package kubeflow
+
+import (
+	"fmt"
+
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// upgradePhases are the phases run by upgrade Jobs, in order
+var upgradePhases = []cluster.KubeflowUpgradePhase{
+	cluster.UpgradePreCheck,
+	cluster.UpgradeBackup,
+	cluster.UpgradeApply,
+	cluster.UpgradeHealthCheck,
+}
+
+// NeedsUpgrade reports whether the installed Kubeflow differs from spec.kf_version.
+// Nothing is upgraded while the installed version is unknown or the spec follows "latest",
+// and a failed or rolled back upgrade isn't retried until spec.kf_version changes again.
+func NeedsUpgrade(kfCluster *cluster.KfCluster) bool {
+	want := kfCluster.Spec.KfVersion
+	if want == "" || want == cluster.LatestKfVersion || kfCluster.Status.InstalledVersion == "" {
+		return false
+	}
+	if sameVersion(want, kfCluster.Status.InstalledVersion) {
+		return false
+	}
+	if upgrade := kfCluster.Status.Upgrade; upgrade != nil && (upgrade.Phase == cluster.UpgradeFailed || upgrade.Phase == cluster.UpgradeRolledBack) && sameVersion(upgrade.ToVersion, want) {
+		return false
+	}
+	return true
+}
+
+// PreUpgradeCheck checks that an upgrade between the versions is supported
+func PreUpgradeCheck(from, to string) error {
+	fromVersion, err := version.ParseGeneric(from)
+	if err != nil {
+		return fmt.Errorf("installed Kubeflow version %q can't be upgraded: %v", from, err)
+	}
+	toVersion, err := version.ParseGeneric(to)
+	if err != nil {
+		return fmt.Errorf("Kubeflow version %q is invalid: %v", to, err)
+	}
+	if toVersion.LessThan(fromVersion) {
+		return fmt.Errorf("Kubeflow %s is older than the installed %s; downgrades are not upgrades", to, from)
+	}
+	if toVersion.Major() != fromVersion.Major() && !(fromVersion.Major() == 0 && toVersion.Major() == 1) {
+		return fmt.Errorf("upgrading Kubeflow from %s to %s crosses a major version", from, to)
+	}
+	if toVersion.Major() == fromVersion.Major() && toVersion.Minor() > fromVersion.Minor()+1 {
+		return fmt.Errorf("upgrading Kubeflow from %s to %s skips a minor version", from, to)
+	}
+	return nil
+}
+
+// NextUpgradePhase returns the phase that follows a completed one
+func NextUpgradePhase(phase cluster.KubeflowUpgradePhase) cluster.KubeflowUpgradePhase {
+	for i, p := range upgradePhases {
+		if p == phase && i+1 < len(upgradePhases) {
+			return upgradePhases[i+1]
+		}
+	}
+	return cluster.UpgradeSucceeded
+}
+
+// UpgradePhases returns the phases run by upgrade Jobs, in order
+func UpgradePhases() []cluster.KubeflowUpgradePhase {
+	return append([]cluster.KubeflowUpgradePhase{}, upgradePhases...)
+}
+
+// sameVersion compares versions that may differ in their "v" prefix
+func sameVersion(a, b string) bool {
+	va, errA := version.ParseGeneric(a)
+	vb, errB := version.ParseGeneric(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return va.String() == vb.String()
+}
@@ -0,0 +1,53 @@
+package kubernetes
+
+import (
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// adoptBackoffLimit is how often a failed discovery is retried before the Job fails
+const adoptBackoffLimit = int32(3)
+
+// AdoptJobName returns the name of the Job discovering the existing cluster of a KfCluster
+func AdoptJobName(kfCluster *cluster.KfCluster) string {
+	return kfCluster.Name + "-adopt"
+}
+
+// CreateAdoptJob returns the Job that finds an existing kops cluster, exports its kubeconfig to the
+// provisioner volume and reports the Kubeflow installed on it in its termination message.
+// The Job runs the provisioner pod, so it shares its credentials and volume claim.
+func CreateAdoptJob(kfCluster *cluster.KfCluster, opts DeploymentOptions) (*batchv1.Job, *corev1.PersistentVolumeClaim) {
+	labels := map[string]string{"kfcluster": kfCluster.Name}
+	podSpec, volumeClaim := createPodSpecAndVolumeClaim(kfCluster, opts)
+	applyProvisionerConfig(kfCluster, opts.Provisioner, podSpec)
+	podSpec.RestartPolicy = corev1.RestartPolicyNever
+	container := &podSpec.Containers[0]
+	container.Args = []string{"/adopt_entrypoint.sh"}
+	container.TerminationMessagePolicy = corev1.TerminationMessageReadFile
+	clusterName := kfCluster.Name
+	if kfCluster.Spec.Adopt != nil && kfCluster.Spec.Adopt.ClusterName != "" {
+		clusterName = kfCluster.Spec.Adopt.ClusterName
+	}
+	if _, ok := opts.ConfigOverrides["CLUSTER_NAME"]; !ok || clusterName != kfCluster.Name {
+		container.Env = append(container.Env, corev1.EnvVar{Name: "CLUSTER_NAME", Value: clusterName})
+	}
+	container.Env = append(container.Env, corev1.EnvVar{Name: "KFCLUSTER_NAME", Value: kfCluster.Name})
+	backoffLimit := adoptBackoffLimit
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      AdoptJobName(kfCluster),
+			Namespace: kfCluster.Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec:       *podSpec,
+			},
+		},
+	}
+	return job, volumeClaim
+}
@@ -1,20 +1,51 @@
 package kubernetes
 
 import (
+	"strconv"
+
 	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
-	"github.com/CiscoAI/kf-cluster-api/pkg/version"
 	"k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// DeploymentOptions holds the values resolved by the controller that shape the provisioner resources
+type DeploymentOptions struct {
+	// ConfigOverrides holds the data of the KfCluster ConfigMap; keys present there take precedence over the typed spec
+	ConfigOverrides map[string]string
+	// Provisioner holds the controller-wide defaults for the provisioner pod
+	Provisioner ProvisionerConfig
+	// Volume holds the resolved settings of the provisioner volume claim
+	Volume VolumeConfig
+	// SecretHash is the digest of the injected Secrets, see HashSecrets
+	SecretHash string
+}
+
+// installedMarkerPath is the file the provisioner creates on its volume once kfctl apply has installed Kubeflow
+const installedMarkerPath = "/mnt/volume/kubeflow-installed"
+
 // CreateDeployment bootstraps k8s resources needed for a Kubeflow install
-func CreateDeployment(kfCluster *cluster.KfCluster) (*v1.Deployment, *corev1.PersistentVolumeClaim) {
+func CreateDeployment(kfCluster *cluster.KfCluster, opts DeploymentOptions) (*v1.Deployment, *corev1.PersistentVolumeClaim) {
 	labels := map[string]string{"kfcluster": kfCluster.Name}
 	labelSelector := &metav1.LabelSelector{MatchLabels: labels}
 	replicas := int32(1)
-	kfPodSpec, kfVolumeClaim := createPodSpecAndVolumeClaim(kfCluster)
+	if StopProvisionerForUpgrade(kfCluster) {
+		replicas = 0
+	}
+	kfPodSpec, kfVolumeClaim := createPodSpecAndVolumeClaim(kfCluster, opts)
+	applyKindSidecar(kfCluster, kfPodSpec)
+	applyProvisionerConfig(kfCluster, opts.Provisioner, kfPodSpec)
+	// The provisioner only turns ready once Kubeflow is installed, which is how the controller learns about it
+	kfPodSpec.Containers[0].ReadinessProbe = &corev1.Probe{
+		Handler: corev1.Handler{
+			Exec: &corev1.ExecAction{Command: []string{"test", "-f", installedMarkerPath}},
+		},
+		PeriodSeconds: 30,
+	}
+	templateAnnotations := map[string]string{}
+	if opts.SecretHash != "" {
+		templateAnnotations[SecretHashAnnotation] = opts.SecretHash
+	}
 	deployment := &v1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      kfCluster.Name,
@@ -24,7 +55,7 @@ func CreateDeployment(kfCluster *cluster.KfCluster) (*v1.Deployment, *corev1.Per
 			Replicas: &replicas,
 			Selector: labelSelector,
 			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				ObjectMeta: metav1.ObjectMeta{Labels: labels, Annotations: templateAnnotations},
 				Spec:       *kfPodSpec,
 			},
 		},
@@ -32,49 +63,34 @@ func CreateDeployment(kfCluster *cluster.KfCluster) (*v1.Deployment, *corev1.Per
 	return deployment, kfVolumeClaim
 }
 
-func createPodSpecAndVolumeClaim(kfCluster *cluster.KfCluster) (*corev1.PodSpec, *corev1.PersistentVolumeClaim) {
+func createPodSpecAndVolumeClaim(kfCluster *cluster.KfCluster, opts DeploymentOptions) (*corev1.PodSpec, *corev1.PersistentVolumeClaim) {
 	volumes := []corev1.Volume{}
 	volumeMounts := []corev1.VolumeMount{}
-	readOnlyMode := int32(444)
 	requiredConfigMap := false
 	var entrypointScript string
-	if len(kfCluster.Spec.Secrets) > 0 {
-		for _, secret := range kfCluster.Spec.Secrets {
-			volumeSecret := &corev1.SecretVolumeSource{
-				SecretName:  secret,
-				DefaultMode: &readOnlyMode,
-			}
-			volumes = append(volumes, corev1.Volume{
-				Name: secret,
-				VolumeSource: corev1.VolumeSource{
-					Secret: volumeSecret,
-				},
-			})
-			volumeMounts = append(volumeMounts, corev1.VolumeMount{
-				Name:      secret,
-				ReadOnly:  true,
-				MountPath: "/etc/" + secret,
-			})
-		}
+	volumeConfig := opts.Volume
+	if volumeConfig.AccessMode == "" {
+		volumeConfig.AccessMode = corev1.ReadWriteOnce
+	}
+	if volumeConfig.Size.IsZero() {
+		volumeConfig.Size = defaultVolumeSize
 	}
-	// TODO(swiftdiaries): Programmatically get default StorageClass instead of hard-coding
-	defaultStorageClass := "standard"
-	resourceReq := make(map[corev1.ResourceName]resource.Quantity)
-	resourceSize := int64(10)
-	resourceReq[corev1.ResourceStorage] = *resource.NewQuantity(resourceSize, "Gi")
 	defaultVolumeClaim := &corev1.PersistentVolumeClaim{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      kfCluster.Name,
 			Namespace: kfCluster.Namespace,
 		},
 		Spec: corev1.PersistentVolumeClaimSpec{
-			StorageClassName: &defaultStorageClass,
-			AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+			AccessModes: []corev1.PersistentVolumeAccessMode{volumeConfig.AccessMode},
 			Resources: corev1.ResourceRequirements{
-				Requests: resourceReq,
+				Requests: corev1.ResourceList{corev1.ResourceStorage: volumeConfig.Size},
 			},
 		},
 	}
+	// An unset class leaves the choice to the DefaultStorageClass admission plugin
+	if volumeConfig.StorageClassName != "" {
+		defaultVolumeClaim.Spec.StorageClassName = &volumeConfig.StorageClassName
+	}
 	defaultVolume := corev1.Volume{
 		Name: kfCluster.Name,
 		VolumeSource: corev1.VolumeSource{
@@ -93,16 +109,17 @@ func createPodSpecAndVolumeClaim(kfCluster *cluster.KfCluster) (*corev1.PodSpec,
 	volumeMounts = append(volumeMounts, defaultVolumeMount)
 	if kfCluster.Spec.Platform == cluster.KfGcp {
 		entrypointScript = "/gcp_entrypoint.sh"
+	} else if kfCluster.Spec.Platform == cluster.KfKind {
+		entrypointScript = "/kind_entrypoint.sh"
 	} else if kfCluster.Spec.Platform == cluster.KfGeneric {
 		entrypointScript = "/generic_entrypoint.sh"
 	}
 	containers := []corev1.Container{
 		corev1.Container{
-			Name:            kfCluster.Name,
-			Image:           "ciscoai/kf-clusterctl:" + version.Version,
-			ImagePullPolicy: "Always",
-			Command:         []string{"sh"},
-			Args:            []string{entrypointScript},
+			Name:    kfCluster.Name,
+			Command: []string{"sh"},
+			Args:    []string{entrypointScript},
+			Env:     platformEnv(kfCluster, opts.ConfigOverrides),
 			EnvFrom: []corev1.EnvFromSource{
 				corev1.EnvFromSource{
 					ConfigMapRef: &corev1.ConfigMapEnvSource{
@@ -120,5 +137,58 @@ func createPodSpecAndVolumeClaim(kfCluster *cluster.KfCluster) (*corev1.PodSpec,
 		Containers: containers,
 		Volumes:    volumes,
 	}
+	injectSecrets(kfCluster, podSpec)
+	applyGCPAuth(kfCluster, podSpec)
 	return podSpec, defaultVolumeClaim
 }
+
+// platformEnv renders the typed platform settings of a KfCluster as env vars for the provisioner.
+// Settings that are empty, or that are overridden by a key in configOverrides, are left out
+// so that the ConfigMap mounted through EnvFrom supplies them instead.
+func platformEnv(kfCluster *cluster.KfCluster, configOverrides map[string]string) []corev1.EnvVar {
+	env := []corev1.EnvVar{}
+	addEnv := func(name string, value string) {
+		if value == "" {
+			return
+		}
+		if _, ok := configOverrides[name]; ok {
+			return
+		}
+		env = append(env, corev1.EnvVar{Name: name, Value: value})
+	}
+	if gcp := kfCluster.Spec.GCP; gcp != nil && kfCluster.Spec.Platform == cluster.KfGcp {
+		addEnv("PROJECT", gcp.Project)
+		addEnv("ZONE", gcp.Zone)
+		addEnv("REGION", gcp.Region)
+		addEnv("NETWORK", gcp.Network)
+		if gcp.NodeCount > 0 {
+			addEnv("NODE_COUNT", strconv.Itoa(int(gcp.NodeCount)))
+		}
+		addEnv("MACHINE_TYPE", gcp.MachineType)
+		addEnv("KOPS_STATE_STORE", gcp.KopsStateStore)
+		addEnv("KUBERNETES_VERSION", kfCluster.Spec.KubernetesVersion)
+	}
+	if kfCluster.Spec.Platform == cluster.KfKind {
+		addEnv("CLUSTER_NAME", kfCluster.Name)
+		kind := kfCluster.Spec.Kind
+		if kind != nil && kind.NodeImage != "" {
+			addEnv("KIND_NODE_IMAGE", kind.NodeImage)
+		} else if kfCluster.Spec.KubernetesVersion != "" {
+			addEnv("KIND_NODE_IMAGE", kindNodeImage(kfCluster.Spec.KubernetesVersion))
+		}
+		if kind != nil && kind.Workers > 0 {
+			addEnv("KIND_WORKERS", strconv.Itoa(int(kind.Workers)))
+		}
+	}
+	if generic := kfCluster.Spec.Generic; generic != nil && kfCluster.Spec.Platform == cluster.KfGeneric {
+		if ref := generic.KubeconfigSecretRef; ref != nil {
+			if _, ok := configOverrides["KUBECONFIG_DATA"]; !ok {
+				env = append(env, corev1.EnvVar{
+					Name:      "KUBECONFIG_DATA",
+					ValueFrom: &corev1.EnvVarSource{SecretKeyRef: ref.DeepCopy()},
+				})
+			}
+		}
+	}
+	return env
+}
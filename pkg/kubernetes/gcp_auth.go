@@ -0,0 +1,104 @@
+package kubernetes
+
+import (
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WorkloadIdentityAnnotation binds a Kubernetes service account to a GCP service account
+const WorkloadIdentityAnnotation = "iam.gke.io/gcp-service-account"
+
+const (
+	gcpKeyVolume    = "gcp-key"
+	gcpKeyMountPath = "/etc/kfcluster/gcp"
+	gcpKeyFile      = "key.json"
+)
+
+// GCPAuthMode returns the authentication mode of a GCP KfCluster.
+// It is empty when the spec sets no auth, leaving the legacy key from the ConfigMap in charge.
+func GCPAuthMode(kfCluster *cluster.KfCluster) cluster.GCPAuthMode {
+	gcp := kfCluster.Spec.GCP
+	if kfCluster.Spec.Platform != cluster.KfGcp || gcp == nil || gcp.Auth == nil {
+		return ""
+	}
+	if gcp.Auth.Mode == "" {
+		return cluster.GCPAuthWorkloadIdentity
+	}
+	return gcp.Auth.Mode
+}
+
+// ProvisionerServiceAccountName returns the name of the Kubernetes service account the controller
+// manages for Workload Identity, or an empty string when the controller doesn't manage one.
+// A service account set in Spec.Provisioner is left to the user to annotate.
+func ProvisionerServiceAccountName(kfCluster *cluster.KfCluster) string {
+	if GCPAuthMode(kfCluster) != cluster.GCPAuthWorkloadIdentity {
+		return ""
+	}
+	if kfCluster.Spec.Provisioner != nil && kfCluster.Spec.Provisioner.ServiceAccountName != "" {
+		return ""
+	}
+	return kfCluster.Name + "-provisioner"
+}
+
+// CreateServiceAccount returns the Workload Identity service account of the provisioner,
+// or nil when the controller doesn't manage one
+func CreateServiceAccount(kfCluster *cluster.KfCluster) *corev1.ServiceAccount {
+	name := ProvisionerServiceAccountName(kfCluster)
+	if name == "" {
+		return nil
+	}
+	return &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: kfCluster.Namespace,
+			Labels:    map[string]string{"kfcluster": kfCluster.Name},
+			Annotations: map[string]string{
+				WorkloadIdentityAnnotation: kfCluster.Spec.GCP.Auth.ServiceAccount,
+			},
+		},
+	}
+}
+
+// applyGCPAuth wires the GCP credentials of the KfCluster into the provisioner pod
+func applyGCPAuth(kfCluster *cluster.KfCluster, podSpec *corev1.PodSpec) {
+	mode := GCPAuthMode(kfCluster)
+	if mode == "" {
+		return
+	}
+	auth := kfCluster.Spec.GCP.Auth
+	container := &podSpec.Containers[0]
+	container.Env = append(container.Env, corev1.EnvVar{Name: "GCP_AUTH_MODE", Value: string(mode)})
+	if auth.ServiceAccount != "" {
+		container.Env = append(container.Env, corev1.EnvVar{Name: "GCP_SERVICE_ACCOUNT", Value: auth.ServiceAccount})
+	}
+	switch mode {
+	case cluster.GCPAuthWorkloadIdentity:
+		if name := ProvisionerServiceAccountName(kfCluster); name != "" {
+			podSpec.ServiceAccountName = name
+		}
+	case cluster.GCPAuthKeyFile:
+		if auth.KeySecretRef == nil {
+			return
+		}
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: gcpKeyVolume,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName:  auth.KeySecretRef.Name,
+					Items:       []corev1.KeyToPath{{Key: auth.KeySecretRef.Key, Path: gcpKeyFile}},
+					DefaultMode: &secretFileMode,
+				},
+			},
+		})
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      gcpKeyVolume,
+			ReadOnly:  true,
+			MountPath: gcpKeyMountPath,
+		})
+		container.Env = append(container.Env, corev1.EnvVar{
+			Name:  "GOOGLE_APPLICATION_CREDENTIALS",
+			Value: gcpKeyMountPath + "/" + gcpKeyFile,
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package kubernetes
+
+import (
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// JobTerminationMessage returns the termination message of the pod of a Job that terminated last
+func JobTerminationMessage(pods []corev1.Pod) string {
+	message := ""
+	var latest *corev1.ContainerStateTerminated
+	for _, pod := range pods {
+		for _, status := range pod.Status.ContainerStatuses {
+			terminated := status.State.Terminated
+			if terminated != nil && (latest == nil || latest.FinishedAt.Before(&terminated.FinishedAt)) {
+				latest = terminated
+				message = terminated.Message
+			}
+		}
+	}
+	return message
+}
+
+// JobFinished returns the condition that finished a Job, or nil while it runs
+func JobFinished(job *batchv1.Job) *batchv1.JobCondition {
+	for i := range job.Status.Conditions {
+		condition := &job.Status.Conditions[i]
+		if condition.Status == corev1.ConditionTrue && (condition.Type == batchv1.JobComplete || condition.Type == batchv1.JobFailed) {
+			return condition
+		}
+	}
+	return nil
+}
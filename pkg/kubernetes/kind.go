@@ -0,0 +1,61 @@
+package kubernetes
+
+import (
+	"strings"
+
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// DefaultDindImage is the Docker-in-Docker image the kind nodes run in
+	DefaultDindImage = "docker:19.03-dind"
+	dindVolume       = "dind-storage"
+	// dockerHost is where the provisioner reaches the Docker daemon of the sidecar
+	dockerHost = "tcp://localhost:2375"
+)
+
+// applyKindSidecar adds the Docker-in-Docker sidecar that hosts the kind nodes of a kind KfCluster.
+// The sidecar runs privileged as root, overriding the restricted pod security context.
+// The provisioner gets the pod IP so it can publish a kubeconfig that is reachable from outside the pod.
+func applyKindSidecar(kfCluster *cluster.KfCluster, podSpec *corev1.PodSpec) {
+	if kfCluster.Spec.Platform != cluster.KfKind {
+		return
+	}
+	image := DefaultDindImage
+	if kfCluster.Spec.Kind != nil && kfCluster.Spec.Kind.DindImage != "" {
+		image = kfCluster.Spec.Kind.DindImage
+	}
+	privileged := true
+	runAsNonRoot := false
+	runAsRoot := int64(0)
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name:         dindVolume,
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	})
+	podSpec.Containers = append(podSpec.Containers, corev1.Container{
+		Name:  "dind",
+		Image: image,
+		Args:  []string{"--host=" + dockerHost},
+		// An empty cert dir makes the daemon listen without TLS, which is only reachable inside the pod
+		Env: []corev1.EnvVar{{Name: "DOCKER_TLS_CERTDIR", Value: ""}},
+		SecurityContext: &corev1.SecurityContext{
+			Privileged:   &privileged,
+			RunAsNonRoot: &runAsNonRoot,
+			RunAsUser:    &runAsRoot,
+		},
+		VolumeMounts: []corev1.VolumeMount{{Name: dindVolume, MountPath: "/var/lib/docker"}},
+	})
+	container := &podSpec.Containers[0]
+	container.Env = append(container.Env,
+		corev1.EnvVar{Name: "DOCKER_HOST", Value: dockerHost},
+		corev1.EnvVar{Name: "POD_IP", ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.podIP"},
+		}},
+	)
+}
+
+// kindNodeImage returns the kind node image of a Kubernetes version
+func kindNodeImage(kubernetesVersion string) string {
+	return "kindest/node:v" + strings.TrimPrefix(kubernetesVersion, "v")
+}
@@ -0,0 +1,84 @@
+package kubernetes
+
+import (
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	"github.com/CiscoAI/kf-cluster-api/pkg/version"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// provisionerUID is the non-root user the kf-clusterctl image runs as
+const provisionerUID = int64(1000)
+
+// ProvisionerConfig holds the controller-wide defaults for the provisioner pod.
+// Each field can be overridden per KfCluster through Spec.Provisioner.
+type ProvisionerConfig struct {
+	Image            string
+	ImagePullPolicy  corev1.PullPolicy
+	ImagePullSecrets []string
+	Resources        corev1.ResourceRequirements
+}
+
+// DefaultProvisionerImage returns the kf-clusterctl image matching this controller's version
+func DefaultProvisionerImage() string {
+	return "ciscoai/kf-clusterctl:" + version.Version
+}
+
+// applyProvisionerConfig sets the image, resources, scheduling and security settings on the provisioner pod.
+// Values from the KfCluster spec take precedence over the controller-wide defaults.
+func applyProvisionerConfig(kfCluster *cluster.KfCluster, config ProvisionerConfig, podSpec *corev1.PodSpec) {
+	container := &podSpec.Containers[0]
+	container.Image = config.Image
+	if container.Image == "" {
+		container.Image = DefaultProvisionerImage()
+	}
+	container.ImagePullPolicy = config.ImagePullPolicy
+	if container.ImagePullPolicy == "" {
+		container.ImagePullPolicy = corev1.PullAlways
+	}
+	container.Resources = *config.Resources.DeepCopy()
+	for _, secret := range config.ImagePullSecrets {
+		podSpec.ImagePullSecrets = append(podSpec.ImagePullSecrets, corev1.LocalObjectReference{Name: secret})
+	}
+
+	runAsNonRoot := true
+	runAsUser := provisionerUID
+	allowPrivilegeEscalation := false
+	podSpec.SecurityContext = &corev1.PodSecurityContext{
+		RunAsNonRoot: &runAsNonRoot,
+		RunAsUser:    &runAsUser,
+		FSGroup:      &runAsUser,
+	}
+	container.SecurityContext = &corev1.SecurityContext{
+		AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
+		},
+	}
+
+	provisioner := kfCluster.Spec.Provisioner
+	if provisioner == nil {
+		return
+	}
+	if provisioner.Image != "" {
+		container.Image = provisioner.Image
+	}
+	if provisioner.ImagePullPolicy != "" {
+		container.ImagePullPolicy = provisioner.ImagePullPolicy
+	}
+	if provisioner.Resources != nil {
+		container.Resources = *provisioner.Resources.DeepCopy()
+	}
+	podSpec.ImagePullSecrets = append(podSpec.ImagePullSecrets, provisioner.ImagePullSecrets...)
+	if len(provisioner.NodeSelector) > 0 {
+		podSpec.NodeSelector = make(map[string]string, len(provisioner.NodeSelector))
+		for key, value := range provisioner.NodeSelector {
+			podSpec.NodeSelector[key] = value
+		}
+	}
+	for _, toleration := range provisioner.Tolerations {
+		podSpec.Tolerations = append(podSpec.Tolerations, *toleration.DeepCopy())
+	}
+	if provisioner.ServiceAccountName != "" {
+		podSpec.ServiceAccountName = provisioner.ServiceAccountName
+	}
+}
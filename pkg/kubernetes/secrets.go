@@ -1,3 +1,194 @@
 package kubernetes
 
-//TODO(swiftdiaries): Inject secrets from spec to KfCluster
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// SecretHashAnnotation is set on the provisioner pod template so that rotating a secret rolls the pod
+const SecretHashAnnotation = "kfcluster.kubeflow.org/secret-hash"
+
+// SecretsMountPath is where the files of Spec.SecretRefs are projected
+const SecretsMountPath = "/etc/kfcluster/secrets"
+
+const projectedSecretsVolume = "kfcluster-secrets"
+
+// secretFileMode is readable by the owner and the pod fsGroup only
+var secretFileMode = int32(0440)
+
+// SecretNames returns the names of all Secrets a KfCluster injects into its provisioner, sorted and deduplicated
+func SecretNames(kfCluster *cluster.KfCluster) []string {
+	seen := map[string]bool{}
+	names := []string{}
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, name := range kfCluster.Spec.Secrets {
+		add(name)
+	}
+	for _, ref := range kfCluster.Spec.SecretRefs {
+		add(ref.Name)
+	}
+	for _, ref := range secretKeyRefs(kfCluster) {
+		add(ref.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// VerifySecrets checks that every Secret and key referenced by the KfCluster is present in secrets
+func VerifySecrets(kfCluster *cluster.KfCluster, secrets map[string]*corev1.Secret) error {
+	for _, name := range SecretNames(kfCluster) {
+		if secrets[name] == nil {
+			return fmt.Errorf("secret %s not found", name)
+		}
+	}
+	hasKey := func(name, key string) error {
+		if _, ok := secrets[name].Data[key]; !ok {
+			return fmt.Errorf("secret %s has no key %s", name, key)
+		}
+		return nil
+	}
+	for _, ref := range kfCluster.Spec.SecretRefs {
+		for _, env := range ref.Env {
+			if err := hasKey(ref.Name, env.Key); err != nil {
+				return err
+			}
+		}
+		for _, file := range ref.Files {
+			if err := hasKey(ref.Name, file.Key); err != nil {
+				return err
+			}
+		}
+	}
+	for _, ref := range secretKeyRefs(kfCluster) {
+		if err := hasKey(ref.Name, ref.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// secretKeyRefs returns the single-key Secret references of the platform settings
+func secretKeyRefs(kfCluster *cluster.KfCluster) []*corev1.SecretKeySelector {
+	refs := []*corev1.SecretKeySelector{}
+	if generic := kfCluster.Spec.Generic; generic != nil && generic.KubeconfigSecretRef != nil {
+		refs = append(refs, generic.KubeconfigSecretRef)
+	}
+	if GCPAuthMode(kfCluster) == cluster.GCPAuthKeyFile && kfCluster.Spec.GCP.Auth.KeySecretRef != nil {
+		refs = append(refs, kfCluster.Spec.GCP.Auth.KeySecretRef)
+	}
+	if metal := kfCluster.Spec.Metal; metal != nil && kfCluster.Spec.Platform == cluster.KfMetal {
+		if metal.SSHKeySecretRef != nil {
+			refs = append(refs, metal.SSHKeySecretRef)
+		}
+		if metal.KnownHostsSecretRef != nil {
+			refs = append(refs, metal.KnownHostsSecretRef)
+		}
+	}
+	return refs
+}
+
+// HashSecrets returns a stable digest of the data of the given Secrets.
+// Secrets missing from the map are skipped.
+func HashSecrets(names []string, secrets map[string]*corev1.Secret) string {
+	hash := sha256.New()
+	for _, name := range names {
+		secret := secrets[name]
+		if secret == nil {
+			continue
+		}
+		keys := make([]string, 0, len(secret.Data))
+		for key := range secret.Data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(hash, "%s\n", name)
+		for _, key := range keys {
+			fmt.Fprintf(hash, "%s=%x\n", key, secret.Data[key])
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// secretVolumeName returns the name of the volume mounting a whole Secret. Secret names may hold dots
+// and run to 253 characters, which volume names, being DNS-1123 labels, can't, so the name is hashed.
+func secretVolumeName(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return "secret-" + hex.EncodeToString(hash[:])[:10]
+}
+
+// injectSecrets adds the env vars, volumes and mounts for the KfCluster secrets to the provisioner pod.
+// Whole Secrets from Spec.Secrets keep their /etc/<secret> mount; keys from Spec.SecretRefs are
+// exposed as env vars or projected together into a single volume at SecretsMountPath.
+func injectSecrets(kfCluster *cluster.KfCluster, podSpec *corev1.PodSpec) {
+	container := &podSpec.Containers[0]
+	for _, secret := range kfCluster.Spec.Secrets {
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: secretVolumeName(secret),
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName:  secret,
+					DefaultMode: &secretFileMode,
+				},
+			},
+		})
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      secretVolumeName(secret),
+			ReadOnly:  true,
+			MountPath: "/etc/" + secret,
+		})
+	}
+
+	projections := []corev1.VolumeProjection{}
+	for _, ref := range kfCluster.Spec.SecretRefs {
+		for _, env := range ref.Env {
+			container.Env = append(container.Env, corev1.EnvVar{
+				Name: env.Name,
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: ref.Name},
+						Key:                  env.Key,
+					},
+				},
+			})
+		}
+		if len(ref.Files) == 0 {
+			continue
+		}
+		items := []corev1.KeyToPath{}
+		for _, file := range ref.Files {
+			items = append(items, corev1.KeyToPath{Key: file.Key, Path: file.Path, Mode: file.Mode})
+		}
+		projections = append(projections, corev1.VolumeProjection{
+			Secret: &corev1.SecretProjection{
+				LocalObjectReference: corev1.LocalObjectReference{Name: ref.Name},
+				Items:                items,
+			},
+		})
+	}
+	if len(projections) > 0 {
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: projectedSecretsVolume,
+			VolumeSource: corev1.VolumeSource{
+				Projected: &corev1.ProjectedVolumeSource{
+					Sources:     projections,
+					DefaultMode: &secretFileMode,
+				},
+			},
+		})
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      projectedSecretsVolume,
+			ReadOnly:  true,
+			MountPath: SecretsMountPath,
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package kubernetes
+
+import (
+	"strings"
+
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	defaultStorageClassAnnotation     = "storageclass.kubernetes.io/is-default-class"
+	betaDefaultStorageClassAnnotation = "storageclass.beta.kubernetes.io/is-default-class"
+)
+
+// defaultVolumeSize is the size of the provisioner volume when the spec doesn't set one
+var defaultVolumeSize = resource.MustParse("10Gi")
+
+// readWriteManyProvisioners lists volume plugins known to support ReadWriteMany
+var readWriteManyProvisioners = []string{
+	"kubernetes.io/azure-file",
+	"kubernetes.io/cephfs",
+	"kubernetes.io/glusterfs",
+	"kubernetes.io/nfs",
+	"kubernetes.io/portworx-volume",
+	"kubernetes.io/quobyte",
+	"cephfs.csi.ceph.com",
+	"efs.csi.aws.com",
+	"filestore.csi.storage.gke.io",
+	"file.csi.azure.com",
+	"nfs.csi.k8s.io",
+}
+
+// VolumeConfig holds the resolved settings of the provisioner volume claim
+type VolumeConfig struct {
+	StorageClassName string
+	AccessMode       corev1.PersistentVolumeAccessMode
+	Size             resource.Quantity
+}
+
+// DefaultStorageClass returns the StorageClass annotated as the cluster default, or nil if there is none
+func DefaultStorageClass(classes []storagev1.StorageClass) *storagev1.StorageClass {
+	for i := range classes {
+		annotations := classes[i].Annotations
+		if annotations[defaultStorageClassAnnotation] == "true" || annotations[betaDefaultStorageClassAnnotation] == "true" {
+			return &classes[i]
+		}
+	}
+	return nil
+}
+
+// SupportsReadWriteMany reports whether volumes of the StorageClass can be mounted read-write by many nodes
+func SupportsReadWriteMany(class *storagev1.StorageClass) bool {
+	for _, provisioner := range readWriteManyProvisioners {
+		if strings.EqualFold(class.Provisioner, provisioner) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveVolumeConfig picks the StorageClass, access mode and size of the provisioner volume.
+// An empty class name in the spec selects the cluster default. ReadWriteMany is preferred unless
+// the spec asks otherwise, falling back to ReadWriteOnce when the class doesn't support it
+// or couldn't be resolved, since most volume plugins only offer ReadWriteOnce.
+// The returned StorageClass is nil when no class could be resolved.
+func ResolveVolumeConfig(kfCluster *cluster.KfCluster, classes []storagev1.StorageClass) (VolumeConfig, *storagev1.StorageClass) {
+	config := VolumeConfig{
+		AccessMode: corev1.ReadWriteMany,
+		Size:       defaultVolumeSize,
+	}
+	storage := kfCluster.Spec.Storage
+	if storage != nil && storage.Size != nil {
+		config.Size = storage.Size.DeepCopy()
+	}
+	var class *storagev1.StorageClass
+	if storage != nil && storage.StorageClassName != "" {
+		config.StorageClassName = storage.StorageClassName
+		for i := range classes {
+			if classes[i].Name == storage.StorageClassName {
+				class = &classes[i]
+			}
+		}
+	} else if class = DefaultStorageClass(classes); class != nil {
+		config.StorageClassName = class.Name
+	}
+	if storage != nil && storage.AccessMode != "" {
+		config.AccessMode = storage.AccessMode
+	} else if class == nil || !SupportsReadWriteMany(class) {
+		config.AccessMode = corev1.ReadWriteOnce
+	}
+	return config, class
+}
@@ -0,0 +1,39 @@
+package kubernetes
+
+import (
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TeardownJobName returns the name of the Job deleting the kops cluster of a KfCluster
+func TeardownJobName(kfCluster *cluster.KfCluster) string {
+	return kfCluster.Name + "-teardown"
+}
+
+// CreateTeardownJob returns the Job that deletes the kops cluster of a KfCluster being deleted.
+// The Job runs the provisioner pod, so it shares its credentials and the kops state.
+func CreateTeardownJob(kfCluster *cluster.KfCluster, opts DeploymentOptions) *batchv1.Job {
+	podSpec, _, _ := createJobPodSpec(kfCluster, opts)
+	container := &podSpec.Containers[0]
+	container.Args = []string{"/gcp_teardown_entrypoint.sh"}
+	container.TerminationMessagePolicy = corev1.TerminationMessageReadFile
+	container.Env = append(container.Env, corev1.EnvVar{Name: "KFCLUSTER_NAME", Value: kfCluster.Name})
+	labels := map[string]string{"kfcluster-teardown": kfCluster.Name}
+	backoffLimit := upgradeBackoffLimit
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      TeardownJobName(kfCluster),
+			Namespace: kfCluster.Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec:       *podSpec,
+			},
+		},
+	}
+}
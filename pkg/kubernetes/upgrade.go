@@ -0,0 +1,148 @@
+package kubernetes
+
+import (
+	"strings"
+
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// UpgradeVersionAnnotation records the Kubeflow or Kubernetes version an upgrade Job upgrades to
+	UpgradeVersionAnnotation = "kfcluster.kubeflow.org/upgrade-version"
+	// kubeconfigMountPath is where the published kubeconfig Secret is mounted in Jobs
+	kubeconfigMountPath = "/etc/kfcluster/kubeconfig"
+	upgradeBackoffLimit = int32(1)
+)
+
+// StopProvisionerForUpgrade reports whether the provisioner Deployment is scaled down while upgrade Jobs run.
+// The Jobs mount the provisioner volume claim, which a ReadWriteOnce volume only attaches on one node, and a
+// provisioner rerunning the install would race the upgrade. The kind provisioner pod runs the cluster itself,
+// so it keeps running and the Jobs are scheduled next to it instead.
+func StopProvisionerForUpgrade(kfCluster *cluster.KfCluster) bool {
+	if kfCluster.Spec.Platform == cluster.KfKind {
+		return false
+	}
+	if upgrade := kfCluster.Status.Upgrade; upgrade != nil && !upgrade.IsFinished() {
+		return true
+	}
+	upgrade := kfCluster.Status.KubernetesUpgrade
+	return upgrade != nil && upgrade.Phase == cluster.KubernetesUpgradeInProgress
+}
+
+// createJobPodSpec returns the pod spec of the provisioner for a Job, without the kind sidecar, and the labels
+// of the Job. The labels differ from those of the provisioner Deployment, whose selector would match the Job pods.
+func createJobPodSpec(kfCluster *cluster.KfCluster, opts DeploymentOptions) (*corev1.PodSpec, *corev1.PersistentVolumeClaim, map[string]string) {
+	podSpec, volumeClaim := createPodSpecAndVolumeClaim(kfCluster, opts)
+	applyProvisionerConfig(kfCluster, opts.Provisioner, podSpec)
+	podSpec.RestartPolicy = corev1.RestartPolicyNever
+	if kfCluster.Spec.Platform == cluster.KfKind {
+		// Pods on the node of the provisioner pod can mount its volume claim whatever its access mode
+		podSpec.Affinity = &corev1.Affinity{
+			PodAffinity: &corev1.PodAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{{
+					LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"kfcluster": kfCluster.Name}},
+					TopologyKey:   "kubernetes.io/hostname",
+				}},
+			},
+		}
+	}
+	return podSpec, volumeClaim, map[string]string{"kfcluster-upgrade": kfCluster.Name}
+}
+
+// UpgradeJobName returns the name of the Job running a phase of a Kubeflow upgrade
+func UpgradeJobName(kfCluster *cluster.KfCluster, phase cluster.KubeflowUpgradePhase) string {
+	return kfCluster.Name + "-upgrade-" + strings.ToLower(string(phase))
+}
+
+// CreateUpgradeJob returns the Job running one phase of the Kubeflow upgrade recorded in the status,
+// and the volume claim the kustomize directory and backups live on.
+// The Job reaches the cluster through the published kubeconfig Secret, or the kubeconfig on the volume.
+func CreateUpgradeJob(kfCluster *cluster.KfCluster, opts DeploymentOptions, phase cluster.KubeflowUpgradePhase) (*batchv1.Job, *corev1.PersistentVolumeClaim) {
+	podSpec, volumeClaim, labels := createJobPodSpec(kfCluster, opts)
+	container := &podSpec.Containers[0]
+	container.Args = []string{"/upgrade_entrypoint.sh", string(phase)}
+	container.TerminationMessagePolicy = corev1.TerminationMessageReadFile
+	upgrade := kfCluster.Status.Upgrade
+	container.Env = append(container.Env,
+		corev1.EnvVar{Name: "KFCLUSTER_NAME", Value: kfCluster.Name},
+		corev1.EnvVar{Name: "KF_FROM_VERSION", Value: upgrade.FromVersion},
+		corev1.EnvVar{Name: "KF_TO_VERSION", Value: upgrade.ToVersion},
+	)
+	if kfCluster.Status.KubeconfigSecret != "" {
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: "kubeconfig",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: kfCluster.Status.KubeconfigSecret},
+			},
+		})
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      "kubeconfig",
+			ReadOnly:  true,
+			MountPath: kubeconfigMountPath,
+		})
+		container.Env = append(container.Env, corev1.EnvVar{Name: "KUBECONFIG", Value: kubeconfigMountPath + "/kubeconfig"})
+	}
+	backoffLimit := upgradeBackoffLimit
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        UpgradeJobName(kfCluster, phase),
+			Namespace:   kfCluster.Namespace,
+			Labels:      labels,
+			Annotations: map[string]string{UpgradeVersionAnnotation: upgrade.ToVersion},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec:       *podSpec,
+			},
+		},
+	}
+	return job, volumeClaim
+}
+
+// KubernetesUpgradeJobName returns the name of the Job rolling a kops cluster to a new Kubernetes version
+func KubernetesUpgradeJobName(kfCluster *cluster.KfCluster) string {
+	return kfCluster.Name + "-kubernetes-upgrade"
+}
+
+// CreateKubernetesUpgradeJob returns the Job that updates the kops cluster spec to the Kubernetes version
+// of the upgrade recorded in the status and rolls its instance groups one node at a time.
+// The Job runs the provisioner pod, so it shares its credentials and the kops state.
+func CreateKubernetesUpgradeJob(kfCluster *cluster.KfCluster, opts DeploymentOptions) (*batchv1.Job, *corev1.PersistentVolumeClaim) {
+	podSpec, volumeClaim, labels := createJobPodSpec(kfCluster, opts)
+	container := &podSpec.Containers[0]
+	container.Args = []string{"/kubernetes_upgrade_entrypoint.sh"}
+	container.TerminationMessagePolicy = corev1.TerminationMessageReadFile
+	toVersion := kfCluster.Status.KubernetesUpgrade.ToVersion
+	env := container.Env[:0]
+	for _, envVar := range container.Env {
+		if envVar.Name != "KUBERNETES_VERSION" {
+			env = append(env, envVar)
+		}
+	}
+	container.Env = append(env,
+		corev1.EnvVar{Name: "KFCLUSTER_NAME", Value: kfCluster.Name},
+		corev1.EnvVar{Name: "KUBERNETES_VERSION", Value: toVersion},
+	)
+	backoffLimit := upgradeBackoffLimit
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        KubernetesUpgradeJobName(kfCluster),
+			Namespace:   kfCluster.Namespace,
+			Labels:      labels,
+			Annotations: map[string]string{UpgradeVersionAnnotation: toVersion},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec:       *podSpec,
+			},
+		},
+	}
+	return job, volumeClaim
+}
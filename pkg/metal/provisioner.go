@@ -0,0 +1,275 @@
+package metal
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/CiscoAI/kf-cluster-api/pkg/provision"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Names of the provisioning stages, in the order they run
+const (
+	StageHosts        = "Hosts"
+	StageControlPlane = "ControlPlane"
+	StageAPIServer    = "APIServer"
+	StageWorkers      = "Workers"
+)
+
+// Names of the jobs run on the hosts
+const (
+	jobInit = "kubeadm-init"
+	jobJoin = "kubeadm-join"
+)
+
+// ClusterConfig describes a kubeadm cluster on pre-provisioned hosts
+type ClusterConfig struct {
+	Name string
+	// Hosts are host or host:port SSH addresses; the first one becomes the control plane
+	Hosts []string
+	// ControlPlaneEndpoint is the address of the API server; defaults to the host of the first address
+	ControlPlaneEndpoint string
+	KubernetesVersion    string
+	// SkipInstall leaves out installing Docker and kubeadm, for hosts that come with them
+	SkipInstall           bool
+	IgnorePreflightErrors []string
+	SSH                   SSHConfig
+}
+
+// Provisioner runs kubeadm init and join on the hosts over SSH.
+// The kubeadm runs are detached from the SSH sessions, so its stages never block on them.
+type Provisioner struct {
+	config ClusterConfig
+	pki    *provision.ClusterPKI
+
+	// Observed while the stages run
+	kubeconfig []byte
+}
+
+// NewProvisioner returns a Provisioner for config, filling in defaults
+func NewProvisioner(config ClusterConfig, pki *provision.ClusterPKI) *Provisioner {
+	p := &Provisioner{config: config, pki: pki}
+	if p.config.ControlPlaneEndpoint == "" && len(p.config.Hosts) > 0 {
+		p.config.ControlPlaneEndpoint = hostOf(p.config.Hosts[0])
+	}
+	if p.config.KubernetesVersion == "" {
+		p.config.KubernetesVersion = provision.DefaultKubernetesVersion
+	}
+	if p.config.SSH.User == "" {
+		p.config.SSH.User = "root"
+	}
+	return p
+}
+
+// Stages returns the provisioning stages in the order they must run
+func (p *Provisioner) Stages() []provision.Stage {
+	return []provision.Stage{
+		{Name: StageHosts, Run: p.checkHosts},
+		{Name: StageControlPlane, Run: p.ensureControlPlane},
+		{Name: StageAPIServer, Run: p.ensureAPIServer},
+		{Name: StageWorkers, Run: p.ensureWorkers},
+	}
+}
+
+// TeardownStages returns the stages resetting the hosts, in the order they must run.
+// The hosts themselves are left in place.
+func (p *Provisioner) TeardownStages() []provision.Stage {
+	return []provision.Stage{
+		{Name: StageWorkers, Run: func(ctx context.Context) (bool, error) {
+			return p.reset(p.workers())
+		}},
+		{Name: StageControlPlane, Run: func(ctx context.Context) (bool, error) {
+			return p.reset(p.config.Hosts[:1])
+		}},
+	}
+}
+
+// Kubeconfig returns the admin kubeconfig once the APIServer stage has completed
+func (p *Provisioner) Kubeconfig() []byte {
+	return p.kubeconfig
+}
+
+func (p *Provisioner) workers() []string {
+	if len(p.config.Hosts) < 2 {
+		return nil
+	}
+	return p.config.Hosts[1:]
+}
+
+func (p *Provisioner) scriptValues() (scriptValues, error) {
+	caCertHash, err := p.pki.CACertHash()
+	if err != nil {
+		return scriptValues{}, err
+	}
+	return scriptValues{
+		Install:               !p.config.SkipInstall,
+		ClusterName:           p.config.Name,
+		KubernetesVersion:     p.config.KubernetesVersion,
+		BootstrapToken:        p.pki.BootstrapToken,
+		CACert:                string(p.pki.CACert),
+		CAKey:                 string(p.pki.CAKey),
+		CACertHash:            caCertHash,
+		ControlPlaneEndpoint:  p.config.ControlPlaneEndpoint,
+		PodSubnet:             provision.PodSubnet,
+		CNIManifest:           provision.CNIManifest,
+		IgnorePreflightErrors: strings.Join(p.config.IgnorePreflightErrors, ","),
+	}, nil
+}
+
+// checkHosts completes once every host accepts SSH connections and can run scripts as root
+func (p *Provisioner) checkHosts(ctx context.Context) (bool, error) {
+	for _, host := range p.config.Hosts {
+		client, err := dial(host, p.config.SSH)
+		if err != nil {
+			return false, err
+		}
+		_, err = client.run("true")
+		client.close()
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (p *Provisioner) ensureControlPlane(ctx context.Context) (bool, error) {
+	values, err := p.scriptValues()
+	if err != nil {
+		return false, err
+	}
+	script, err := renderScript(initScript, values)
+	if err != nil {
+		return false, err
+	}
+	return p.ensureJob(p.config.Hosts[0], jobInit, script)
+}
+
+// ensureAPIServer completes once the API server answers with the minted credentials
+func (p *Provisioner) ensureAPIServer(ctx context.Context) (bool, error) {
+	server := "https://" + net.JoinHostPort(p.config.ControlPlaneEndpoint, "6443")
+	kubeconfig, err := p.pki.AdminKubeconfig(p.config.Name, server)
+	if err != nil {
+		return false, err
+	}
+	client, err := kubeClient(kubeconfig)
+	if err != nil {
+		return false, err
+	}
+	if _, err := client.Discovery().ServerVersion(); err != nil {
+		log.Infof("API server of %s not reachable yet: %v", p.config.Name, err)
+		return false, nil
+	}
+	p.kubeconfig = kubeconfig
+	return true, nil
+}
+
+// ensureWorkers completes once every worker has joined and every host is a Ready node
+func (p *Provisioner) ensureWorkers(ctx context.Context) (bool, error) {
+	values, err := p.scriptValues()
+	if err != nil {
+		return false, err
+	}
+	script, err := renderScript(joinScript, values)
+	if err != nil {
+		return false, err
+	}
+	joined := true
+	for _, host := range p.workers() {
+		done, err := p.ensureJob(host, jobJoin, script)
+		if err != nil {
+			return false, err
+		}
+		joined = joined && done
+	}
+	if !joined {
+		return false, nil
+	}
+	client, err := kubeClient(p.kubeconfig)
+	if err != nil {
+		return false, err
+	}
+	nodes, err := client.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		log.Infof("Error listing nodes of %s: %v", p.config.Name, err)
+		return false, nil
+	}
+	ready := 0
+	for _, node := range nodes.Items {
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == corev1.NodeReady && condition.Status == corev1.ConditionTrue {
+				ready++
+			}
+		}
+	}
+	return ready >= len(p.config.Hosts), nil
+}
+
+// ensureJob starts a job on the host unless it runs or has completed, and returns true once it succeeded.
+// A failed job is reported with the end of its log and started again on the next call.
+func (p *Provisioner) ensureJob(host string, job string, script string) (bool, error) {
+	client, err := dial(host, p.config.SSH)
+	if err != nil {
+		return false, err
+	}
+	defer client.close()
+	status, err := client.run(jobStatusScript(job))
+	if err != nil {
+		return false, err
+	}
+	switch strings.TrimSpace(status) {
+	case jobDone:
+		return true, nil
+	case jobRunning:
+		log.Infof("%s is running on %s", job, host)
+		return false, nil
+	case jobFailed:
+		output, _ := client.run(jobLogScript(job))
+		return false, fmt.Errorf("%s failed on %s: %s", job, host, output)
+	}
+	log.Infof("Starting %s on %s", job, host)
+	if _, err := client.run(startJobScript(job, script)); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// reset runs kubeadm reset on the hosts and returns true once all of them are reset
+func (p *Provisioner) reset(hosts []string) (bool, error) {
+	for _, host := range hosts {
+		client, err := dial(host, p.config.SSH)
+		if err != nil {
+			return false, err
+		}
+		log.Infof("Resetting %s", host)
+		_, err = client.run(resetScript)
+		client.close()
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func kubeClient(kubeconfig []byte) (kubernetes.Interface, error) {
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	restConfig.Timeout = 10 * time.Second
+	return kubernetes.NewForConfig(restConfig)
+}
+
+// hostOf returns the host of a host or host:port address
+func hostOf(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
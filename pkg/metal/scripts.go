@@ -0,0 +1,171 @@
+package metal
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/CiscoAI/kf-cluster-api/pkg/provision"
+)
+
+// jobDir holds the scripts, logs and state markers of the jobs run on a host
+const jobDir = "/var/lib/kfcluster"
+
+// Job states reported by jobStatusScript
+const (
+	jobIdle    = "idle"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// initScript runs kubeadm init with the CA generated by the controller
+var initScript = template.Must(template.New("init").Parse(`#!/bin/bash
+set -euxo pipefail
+if [ -f /etc/kubernetes/admin.conf ]; then
+  exit 0
+fi
+{{if .Install}}` + provision.InstallScript + `{{end}}
+# Clean up after an earlier failed attempt
+kubeadm reset -f
+mkdir -p /etc/kubernetes/pki
+cat > /etc/kubernetes/pki/ca.crt <<'EOF'
+{{.CACert}}EOF
+cat > /etc/kubernetes/pki/ca.key <<'EOF'
+{{.CAKey}}EOF
+chmod 600 /etc/kubernetes/pki/ca.key
+cat > /etc/kubernetes/kubeadm.yaml <<EOF
+apiVersion: kubeadm.k8s.io/v1beta2
+kind: InitConfiguration
+bootstrapTokens:
+- token: "{{.BootstrapToken}}"
+  ttl: "0s"
+---
+apiVersion: kubeadm.k8s.io/v1beta2
+kind: ClusterConfiguration
+clusterName: {{.ClusterName}}
+kubernetesVersion: v{{.KubernetesVersion}}
+controlPlaneEndpoint: "{{.ControlPlaneEndpoint}}:6443"
+apiServer:
+  certSANs:
+  - "{{.ControlPlaneEndpoint}}"
+networking:
+  podSubnet: {{.PodSubnet}}
+EOF
+kubeadm init --config /etc/kubernetes/kubeadm.yaml{{if .IgnorePreflightErrors}} --ignore-preflight-errors={{.IgnorePreflightErrors}}{{end}}
+kubectl --kubeconfig /etc/kubernetes/admin.conf apply -f {{.CNIManifest}}
+`))
+
+// joinScript joins the host to the control plane, pinning the cluster CA
+var joinScript = template.Must(template.New("join").Parse(`#!/bin/bash
+set -euxo pipefail
+if [ -f /etc/kubernetes/kubelet.conf ]; then
+  exit 0
+fi
+{{if .Install}}` + provision.InstallScript + `{{end}}
+kubeadm reset -f
+kubeadm join {{.ControlPlaneEndpoint}}:6443 --token "{{.BootstrapToken}}" --discovery-token-ca-cert-hash "{{.CACertHash}}"{{if .IgnorePreflightErrors}} --ignore-preflight-errors={{.IgnorePreflightErrors}}{{end}}
+`))
+
+// upgradeControlPlaneScript upgrades the control plane with kubeadm, then the kubelet of its node
+var upgradeControlPlaneScript = template.Must(template.New("upgrade-control-plane").Parse(`#!/bin/bash
+set -euxo pipefail
+{{if .Install}}` + upgradeKubeadmScript + `{{end}}
+kubeadm upgrade apply v{{.KubernetesVersion}} -y
+{{if .Install}}` + upgradeKubeletScript + `{{end}}
+`))
+
+// upgradeNodeScript upgrades the kubelet configuration and kubelet of a drained worker
+var upgradeNodeScript = template.Must(template.New("upgrade-node").Parse(`#!/bin/bash
+set -euxo pipefail
+{{if .Install}}` + upgradeKubeadmScript + `{{end}}
+kubeadm upgrade node
+{{if .Install}}` + upgradeKubeletScript + `{{end}}
+`))
+
+// upgradeKubeadmScript installs the kubeadm of the target version; a fragment expecting a KubernetesVersion value
+const upgradeKubeadmScript = `
+export DEBIAN_FRONTEND=noninteractive
+apt-get update
+apt-mark unhold kubeadm
+apt-get install -y kubeadm={{.KubernetesVersion}}-00
+apt-mark hold kubeadm
+`
+
+// upgradeKubeletScript installs and restarts the kubelet of the target version
+const upgradeKubeletScript = `
+apt-mark unhold kubelet kubectl
+apt-get install -y kubelet={{.KubernetesVersion}}-00 kubectl={{.KubernetesVersion}}-00
+apt-mark hold kubelet kubectl
+systemctl daemon-reload
+systemctl restart kubelet
+`
+
+// drainScript evicts the pods of a node ahead of its upgrade; it runs on the control plane host
+func drainScript(node string) string {
+	return fmt.Sprintf("#!/bin/bash\nset -eux\nkubectl --kubeconfig /etc/kubernetes/admin.conf drain %s --ignore-daemonsets --delete-local-data --force --timeout=10m\n", node)
+}
+
+// resetScript removes the node state kubeadm created, and the job state of the controller
+const resetScript = `#!/bin/bash
+set -eux
+if command -v kubeadm > /dev/null; then
+  kubeadm reset -f
+fi
+rm -rf ` + jobDir + `
+`
+
+// scriptValues are the values rendered into the scripts
+type scriptValues struct {
+	Install               bool
+	ClusterName           string
+	KubernetesVersion     string
+	BootstrapToken        string
+	CACert                string
+	CAKey                 string
+	CACertHash            string
+	ControlPlaneEndpoint  string
+	PodSubnet             string
+	CNIManifest           string
+	IgnorePreflightErrors string
+}
+
+func renderScript(script *template.Template, values scriptValues) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := script.Execute(buf, values); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// jobStatusScript prints the state of a job started with startJobScript
+func jobStatusScript(job string) string {
+	return fmt.Sprintf(`DIR=%[1]s
+if [ -f $DIR/%[2]s.done ]; then echo %[3]s
+elif [ -f $DIR/%[2]s.failed ]; then echo %[4]s
+elif [ -f $DIR/%[2]s.pid ] && kill -0 "$(cat $DIR/%[2]s.pid)" 2> /dev/null; then echo %[5]s
+else echo %[6]s
+fi
+`, jobDir, job, jobDone, jobFailed, jobRunning, jobIdle)
+}
+
+// startJobScript writes script to the host and runs it detached from the SSH session,
+// so that a long kubeadm run survives the session and is checked on by later reconciles
+func startJobScript(job string, script string) string {
+	return fmt.Sprintf(`set -e
+DIR=%[1]s
+mkdir -p $DIR
+rm -f $DIR/%[2]s.done $DIR/%[2]s.failed
+cat > $DIR/%[2]s.sh <<'KFCLUSTER_JOB'
+%[3]s
+KFCLUSTER_JOB
+nohup bash -c "bash $DIR/%[2]s.sh > $DIR/%[2]s.log 2>&1 && touch $DIR/%[2]s.done || touch $DIR/%[2]s.failed" > /dev/null 2>&1 &
+echo $! > $DIR/%[2]s.pid
+`, jobDir, job, strings.TrimRight(script, "\n"))
+}
+
+// jobLogScript prints the end of the log of a job and clears its failure so it can be retried
+func jobLogScript(job string) string {
+	return fmt.Sprintf("tail -n 20 %[1]s/%[2]s.log\nrm -f %[1]s/%[2]s.failed\n", jobDir, job)
+}
@@ -0,0 +1,124 @@
+package metal
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	defaultSSHPort = "22"
+	sshTimeout     = 10 * time.Second
+)
+
+// SSHConfig holds the credentials used to reach the hosts
+type SSHConfig struct {
+	// User needs passwordless sudo unless it is root
+	User       string
+	PrivateKey []byte
+	// KnownHosts holds known_hosts lines the host keys are verified against
+	KnownHosts []byte
+	// InsecureSkipHostKeyVerification accepts any host key when KnownHosts is empty
+	InsecureSkipHostKeyVerification bool
+}
+
+// clientConfig returns the SSH client configuration of config
+func (c SSHConfig) clientConfig() (*ssh.ClientConfig, error) {
+	signer, err := ssh.ParsePrivateKey(c.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing SSH private key: %v", err)
+	}
+	var hostKeyCallback ssh.HostKeyCallback
+	if len(c.KnownHosts) > 0 {
+		hostKeyCallback, err = knownHostsCallback(c.KnownHosts)
+		if err != nil {
+			return nil, err
+		}
+	} else if c.InsecureSkipHostKeyVerification {
+		log.Warn("Host keys are not verified; anyone on the network path can impersonate the hosts")
+		hostKeyCallback = ssh.InsecureIgnoreHostKey()
+	} else {
+		return nil, fmt.Errorf("Known hosts are required to verify the host keys")
+	}
+	return &ssh.ClientConfig{
+		User:            c.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         sshTimeout,
+	}, nil
+}
+
+// knownHostsCallback parses known_hosts lines; the knownhosts package only reads them from files
+func knownHostsCallback(knownHosts []byte) (ssh.HostKeyCallback, error) {
+	file, err := ioutil.TempFile("", "known_hosts")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	if _, err := file.Write(knownHosts); err != nil {
+		return nil, err
+	}
+	callback, err := knownhosts.New(file.Name())
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing known hosts: %v", err)
+	}
+	return callback, nil
+}
+
+// hostClient runs scripts on a host as root
+type hostClient struct {
+	address string
+	root    bool
+	client  *ssh.Client
+}
+
+// dial connects to a host given as host or host:port
+func dial(address string, config SSHConfig) (*hostClient, error) {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, defaultSSHPort)
+	}
+	clientConfig, err := config.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := ssh.Dial("tcp", address, clientConfig)
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to %s: %v", address, err)
+	}
+	return &hostClient{address: address, root: config.User == "root", client: client}, nil
+}
+
+// run pipes script to bash on the host, through sudo unless connected as root, and returns its output
+func (h *hostClient) run(script string) (string, error) {
+	session, err := h.client.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("Error opening SSH session to %s: %v", h.address, err)
+	}
+	defer session.Close()
+	output := &bytes.Buffer{}
+	session.Stdin = strings.NewReader(script)
+	session.Stdout = output
+	session.Stderr = output
+	command := "bash -s"
+	if !h.root {
+		command = "sudo -n bash -s"
+	}
+	if err := session.Run(command); err != nil {
+		log.Debugf("Script on %s failed: %s", h.address, output.String())
+		return output.String(), fmt.Errorf("Error running script on %s: %v", h.address, err)
+	}
+	return output.String(), nil
+}
+
+func (h *hostClient) close() {
+	h.client.Close()
+}
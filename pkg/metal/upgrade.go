@@ -0,0 +1,126 @@
+package metal
+
+import (
+	"context"
+	"strings"
+
+	"github.com/CiscoAI/kf-cluster-api/pkg/provision"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Names of the upgrade stages, in the order they run
+const (
+	StageControlPlaneUpgrade = "ControlPlaneUpgrade"
+	StageWorkersUpgrade      = "WorkersUpgrade"
+)
+
+// UpgradeStages returns the stages moving the cluster to the configured Kubernetes version:
+// the control plane first, then the workers one at a time, each drained before its upgrade.
+// They complete right away on nodes that run the version.
+func (p *Provisioner) UpgradeStages() []provision.Stage {
+	return []provision.Stage{
+		{Name: StageControlPlaneUpgrade, Run: p.upgradeControlPlane},
+		{Name: StageWorkersUpgrade, Run: p.upgradeWorkers},
+	}
+}
+
+// upgradeJob names the jobs of the upgrade to the configured version, so an earlier upgrade doesn't count as done
+func (p *Provisioner) upgradeJob(job string) string {
+	return job + "-" + p.config.KubernetesVersion
+}
+
+func (p *Provisioner) gitVersion() string {
+	return "v" + strings.TrimPrefix(p.config.KubernetesVersion, "v")
+}
+
+// upgradeControlPlane runs kubeadm upgrade apply on the control plane host unless the API server runs the version
+func (p *Provisioner) upgradeControlPlane(ctx context.Context) (bool, error) {
+	client, err := kubeClient(p.kubeconfig)
+	if err != nil {
+		return false, err
+	}
+	serverVersion, err := client.Discovery().ServerVersion()
+	if err != nil {
+		log.Infof("API server of %s not reachable: %v", p.config.Name, err)
+		return false, nil
+	}
+	if serverVersion.GitVersion == p.gitVersion() {
+		return true, nil
+	}
+	values, err := p.scriptValues()
+	if err != nil {
+		return false, err
+	}
+	script, err := renderScript(upgradeControlPlaneScript, values)
+	if err != nil {
+		return false, err
+	}
+	return p.ensureJob(p.config.Hosts[0], p.upgradeJob("kubeadm-upgrade"), script)
+}
+
+// upgradeWorkers upgrades one worker at a time: it drains the node, upgrades it and makes it schedulable again.
+// It completes once every worker node runs the kubelet of the version.
+func (p *Provisioner) upgradeWorkers(ctx context.Context) (bool, error) {
+	client, err := kubeClient(p.kubeconfig)
+	if err != nil {
+		return false, err
+	}
+	values, err := p.scriptValues()
+	if err != nil {
+		return false, err
+	}
+	script, err := renderScript(upgradeNodeScript, values)
+	if err != nil {
+		return false, err
+	}
+	for _, host := range p.workers() {
+		nodeName, err := p.nodeName(host)
+		if err != nil {
+			return false, err
+		}
+		node, err := client.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+		if err != nil {
+			log.Infof("Error getting node %s of %s: %v", nodeName, p.config.Name, err)
+			return false, nil
+		}
+		if node.Status.NodeInfo.KubeletVersion == p.gitVersion() && !node.Spec.Unschedulable {
+			continue
+		}
+		// The jobs are marked done per version, so a node that went through them only gets uncordoned
+		drained, err := p.ensureJob(p.config.Hosts[0], p.upgradeJob("drain-"+nodeName), drainScript(nodeName))
+		if err != nil || !drained {
+			return false, err
+		}
+		upgraded, err := p.ensureJob(host, p.upgradeJob("kubeadm-upgrade-node"), script)
+		if err != nil || !upgraded {
+			return false, err
+		}
+		if node.Spec.Unschedulable {
+			log.Infof("Uncordoning node %s of %s", nodeName, p.config.Name)
+			node.Spec.Unschedulable = false
+			if _, err := client.CoreV1().Nodes().Update(node); err != nil {
+				return false, err
+			}
+		}
+		// Hosts that come with their own packages keep their kubelet, so only wait on the ones installed here
+		if node.Status.NodeInfo.KubeletVersion != p.gitVersion() && !p.config.SkipInstall {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// nodeName returns the name the host registered its node under, which kubeadm takes from the hostname
+func (p *Provisioner) nodeName(host string) (string, error) {
+	client, err := dial(host, p.config.SSH)
+	if err != nil {
+		return "", err
+	}
+	defer client.close()
+	output, err := client.run("hostname")
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(strings.TrimSpace(output)), nil
+}
@@ -0,0 +1,169 @@
+// Package adopt takes over existing clusters, whatever their platform, without provisioning them
+// or installing Kubeflow again.
+package adopt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	"github.com/CiscoAI/kf-cluster-api/pkg/kubeflow"
+	"github.com/CiscoAI/kf-cluster-api/pkg/kubernetes"
+	"github.com/CiscoAI/kf-cluster-api/pkg/provider"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Provider adopts a cluster from a kubeconfig, or discovers a kops cluster from a Job running the provisioner.
+// Adopted clusters are released, not deleted, with their KfCluster.
+type Provider struct {
+	provider.Options
+
+	// Observed by EnsureInfrastructure
+	kubeconfig []byte
+}
+
+var _ provider.Provider = &Provider{}
+
+// New returns the Provider for adopted clusters
+func New(opts provider.Options) provider.Provider {
+	return &Provider{Options: opts}
+}
+
+// discovery is the termination message of the adopt Job
+type discovery struct {
+	Version string   `json:"version"`
+	Apps    []string `json:"apps"`
+}
+
+// EnsureInfrastructure finds the cluster and the Kubeflow installed on it, and returns true once both are known
+func (p *Provider) EnsureInfrastructure(ctx context.Context, kfCluster *cluster.KfCluster) (bool, error) {
+	if kfCluster.Spec.Adopt != nil && kfCluster.Spec.Adopt.KubeconfigSecretRef != nil {
+		return p.adoptKubeconfig(ctx, kfCluster)
+	}
+	if kfCluster.Spec.Platform == cluster.KfGcp {
+		return p.adoptKops(ctx, kfCluster)
+	}
+	err := provider.Terminalf("spec.adopt.kubeconfig_secret_ref is required to adopt a %s cluster", kfCluster.Spec.Platform)
+	kfCluster.Status.SetCondition(cluster.Adopted, corev1.ConditionFalse, "KubeconfigRequired", err.Error())
+	return false, err
+}
+
+// adoptKubeconfig connects to the cluster with the kubeconfig from the spec
+func (p *Provider) adoptKubeconfig(ctx context.Context, kfCluster *cluster.KfCluster) (bool, error) {
+	kubeconfig, err := provider.SecretKey(ctx, p.Options, kfCluster.Namespace, kfCluster.Spec.Adopt.KubeconfigSecretRef)
+	if err != nil {
+		kfCluster.Status.SetCondition(cluster.Adopted, corev1.ConditionFalse, "KubeconfigNotFound", err.Error())
+		return false, err
+	}
+	installation, err := kubeflow.DetectInstallation(kubeconfig)
+	if err != nil {
+		kfCluster.Status.SetCondition(cluster.Adopted, corev1.ConditionFalse, "ClusterUnreachable", err.Error())
+		return false, err
+	}
+	p.kubeconfig = kubeconfig
+	if installation == nil {
+		setInstallation(kfCluster, "", nil)
+	} else {
+		setInstallation(kfCluster, installation.Version, installation.Apps)
+	}
+	return true, nil
+}
+
+// adoptKops runs the adopt Job until it reports the kops cluster and the Kubeflow installed on it
+func (p *Provider) adoptKops(ctx context.Context, kfCluster *cluster.KfCluster) (bool, error) {
+	log := p.Log.WithValues("kfcluster", kfCluster.Namespace+"/"+kfCluster.Name)
+	configOverrides, err := provider.ConfigOverrides(ctx, p.Options, kfCluster)
+	if err != nil {
+		return false, err
+	}
+	storageClasses := &storagev1.StorageClassList{}
+	if err := p.Client.List(ctx, storageClasses); err != nil {
+		return false, err
+	}
+	volumeConfig, _ := kubernetes.ResolveVolumeConfig(kfCluster, storageClasses.Items)
+	job, volumeClaim := kubernetes.CreateAdoptJob(kfCluster, kubernetes.DeploymentOptions{
+		ConfigOverrides: configOverrides,
+		Provisioner:     p.Provisioner,
+		Volume:          volumeConfig,
+	})
+	if _, err := provider.ApplyIfMissing(ctx, p.Options, kfCluster, volumeClaim); err != nil {
+		return false, err
+	}
+	existing := &batchv1.Job{}
+	if err := p.Client.Get(ctx, types.NamespacedName{Name: job.Name, Namespace: job.Namespace}, existing); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return false, err
+		}
+		log.Info("Creating adopt job for KfCluster")
+		if err := provider.Apply(ctx, p.Options, kfCluster, job); err != nil {
+			return false, err
+		}
+		kfCluster.Status.SetCondition(cluster.Adopted, corev1.ConditionFalse, "Discovering", "looking for the kops cluster")
+		return false, nil
+	}
+	if finished := kubernetes.JobFinished(existing); finished != nil {
+		pods := &corev1.PodList{}
+		if err := p.Client.List(ctx, pods, client.InNamespace(existing.Namespace), client.MatchingLabels{"job-name": existing.Name}); err != nil {
+			return false, err
+		}
+		message := kubernetes.JobTerminationMessage(pods.Items)
+		switch finished.Type {
+		case batchv1.JobFailed:
+			err := fmt.Errorf("kops cluster discovery failed: %s", strings.TrimSpace(message))
+			kfCluster.Status.SetCondition(cluster.Adopted, corev1.ConditionFalse, "DiscoveryFailed", err.Error())
+			return false, err
+		case batchv1.JobComplete:
+			result := discovery{}
+			if err := json.Unmarshal([]byte(message), &result); err != nil {
+				return false, fmt.Errorf("invalid result of kops cluster discovery %q: %v", message, err)
+			}
+			kfCluster.Status.KubeconfigPath = "/mnt/volume/" + kfCluster.Name + "/kubeconfig"
+			setInstallation(kfCluster, result.Version, result.Apps)
+			return true, nil
+		}
+	}
+	kfCluster.Status.SetCondition(cluster.Adopted, corev1.ConditionFalse, "Discovering", "looking for the kops cluster")
+	return false, nil
+}
+
+// setInstallation records the Kubeflow found on the adopted cluster
+func setInstallation(kfCluster *cluster.KfCluster, version string, apps []string) {
+	kfCluster.Status.InstalledVersion = version
+	kfCluster.Status.InstalledApps = apps
+	message := "no Kubeflow installation was found"
+	if version != "" || len(apps) > 0 {
+		message = fmt.Sprintf("found Kubeflow %s with %d applications", version, len(apps))
+	}
+	kfCluster.Status.SetCondition(cluster.Adopted, corev1.ConditionTrue, "ClusterAdopted", message)
+}
+
+// GetKubeconfig returns the kubeconfig the cluster was adopted with; kops kubeconfigs stay on the provisioner volume
+func (p *Provider) GetKubeconfig(ctx context.Context, kfCluster *cluster.KfCluster) ([]byte, error) {
+	return p.kubeconfig, nil
+}
+
+// Upgrade has nothing to do: adopted clusters keep the versions they run
+func (p *Provider) Upgrade(ctx context.Context, kfCluster *cluster.KfCluster) (bool, error) {
+	return true, nil
+}
+
+// Teardown releases the cluster; only the adopt Job and volume are garbage collected with the KfCluster
+func (p *Provider) Teardown(ctx context.Context, kfCluster *cluster.KfCluster) (bool, error) {
+	return true, nil
+}
+
+// Status reports the Adopted condition
+func (p *Provider) Status(ctx context.Context, kfCluster *cluster.KfCluster) (provider.Status, error) {
+	condition := kfCluster.Status.GetCondition(cluster.Adopted)
+	if condition == nil {
+		return provider.Status{Reason: "Pending", Message: "the cluster has not been adopted"}, nil
+	}
+	return provider.Status{Ready: condition.Status == corev1.ConditionTrue, Reason: condition.Reason, Message: condition.Message}, nil
+}
@@ -0,0 +1,106 @@
+package provider
+
+import (
+	"context"
+
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// FieldManager is the server-side apply field manager of the objects the controller creates for KfClusters
+const FieldManager = "kf-cluster-controller"
+
+// Object is a Kubernetes object the controller creates for a KfCluster
+type Object interface {
+	metav1.Object
+	runtime.Object
+}
+
+// Apply makes obj owned by the KfCluster and applies it with server-side apply.
+// obj holds the fields the controller manages, no more: fields others set on the object are preserved,
+// and fields the controller stops setting are removed. Fields obj leaves empty, such as a zero strategy or
+// empty resources, aren't sent, so the controller doesn't own them. Applying an unchanged obj doesn't write.
+// On return, obj holds the live object. Server-side apply needs a management cluster running Kubernetes 1.16 or later.
+func Apply(ctx context.Context, opts Options, kfCluster *cluster.KfCluster, obj Object) error {
+	gvk, err := apiutil.GVKForObject(obj, opts.Scheme)
+	if err != nil {
+		return err
+	}
+	if err := ctrl.SetControllerReference(kfCluster, obj, opts.Scheme); err != nil {
+		return err
+	}
+	applied, err := ownedFields(obj)
+	if err != nil {
+		return err
+	}
+	// The apply request carries the type of the object, which typed objects leave out
+	applied.SetGroupVersionKind(gvk)
+	if err := opts.Client.Patch(ctx, applied, client.Apply, client.FieldOwner(FieldManager), client.ForceOwnership); err != nil {
+		return err
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(applied.Object, obj)
+}
+
+// ownedFields returns the fields obj sets. Typed objects serialize empty structs and null timestamps,
+// which server-side apply would record as owned, so those are dropped along with the status.
+func ownedFields(obj Object) (*unstructured.Unstructured, error) {
+	fields, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	delete(fields, "status")
+	return &unstructured.Unstructured{Object: pruneEmpty(fields).(map[string]interface{})}, nil
+}
+
+// presenceFields are the fields whose empty value means something, such as an emptyDir volume source
+var presenceFields = map[string]bool{"emptyDir": true}
+
+// pruneEmpty removes nil values and empty maps and lists from value. Zero scalars, such as 0 replicas, are kept.
+func pruneEmpty(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, field := range value {
+			if field = pruneEmpty(field); field == nil && presenceFields[key] {
+				value[key] = map[string]interface{}{}
+			} else if field == nil {
+				delete(value, key)
+			} else {
+				value[key] = field
+			}
+		}
+		if len(value) == 0 {
+			return nil
+		}
+		return value
+	case []interface{}:
+		items := make([]interface{}, 0, len(value))
+		for _, item := range value {
+			if item = pruneEmpty(item); item != nil {
+				items = append(items, item)
+			}
+		}
+		if len(items) == 0 {
+			return nil
+		}
+		return items
+	}
+	return value
+}
+
+// ApplyIfMissing applies obj like Apply unless it exists, for objects that can't change once created, such as Jobs.
+// It returns true when obj was applied.
+func ApplyIfMissing(ctx context.Context, opts Options, kfCluster *cluster.KfCluster, obj Object) (bool, error) {
+	existing := obj.DeepCopyObject()
+	if err := opts.Client.Get(ctx, client.ObjectKey{Name: obj.GetName(), Namespace: obj.GetNamespace()}, existing); err == nil {
+		return false, nil
+	} else if !apierrors.IsNotFound(err) {
+		return false, err
+	}
+	return true, Apply(ctx, opts, kfCluster, obj)
+}
@@ -0,0 +1,188 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	"github.com/CiscoAI/kf-cluster-api/pkg/provider/providertest"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+)
+
+func testScheme(t *testing.T) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := cluster.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return scheme
+}
+
+func testKfCluster() *cluster.KfCluster {
+	return &cluster.KfCluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", UID: "test-uid"}}
+}
+
+func testDeployment(image string) *appsv1.Deployment {
+	replicas := int32(0)
+	labels := map[string]string{"kfcluster": "test"}
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "test", Image: image}},
+					Volumes: []corev1.Volume{{
+						Name:         "scratch",
+						VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+					}},
+				},
+			},
+		},
+	}
+}
+
+func TestOwnedFieldsLeavesOutEmptyFields(t *testing.T) {
+	applied, err := ownedFields(testDeployment("kf-clusterctl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replicas, ok, _ := unstructured.NestedInt64(applied.Object, "spec", "replicas"); !ok || replicas != 0 {
+		t.Errorf("spec.replicas = %v, %v; want 0", replicas, ok)
+	}
+	for _, path := range [][]string{
+		{"status"},
+		{"metadata", "creationTimestamp"},
+		{"spec", "strategy"},
+		{"spec", "template", "metadata", "creationTimestamp"},
+	} {
+		if _, ok, _ := unstructured.NestedFieldNoCopy(applied.Object, path...); ok {
+			t.Errorf("%v is set; want it left out", path)
+		}
+	}
+	containers, _, _ := unstructured.NestedSlice(applied.Object, "spec", "template", "spec", "containers")
+	if _, ok := containers[0].(map[string]interface{})["resources"]; ok {
+		t.Errorf("container resources are set; want them left out")
+	}
+	volumes, _, _ := unstructured.NestedSlice(applied.Object, "spec", "template", "spec", "volumes")
+	if _, ok := volumes[0].(map[string]interface{})["emptyDir"]; !ok {
+		t.Errorf("volume emptyDir is left out; want it set")
+	}
+}
+
+func TestApplyUnchangedDoesNotWrite(t *testing.T) {
+	scheme := testScheme(t)
+	c := providertest.NewClient(scheme)
+	opts := Options{Client: c, Scheme: scheme}
+	ctx := context.Background()
+	kfCluster := testKfCluster()
+
+	created := testDeployment("kf-clusterctl")
+	if err := Apply(ctx, opts, kfCluster, created); err != nil {
+		t.Fatal(err)
+	}
+	c.Reset()
+	applied := testDeployment("kf-clusterctl")
+	if err := Apply(ctx, opts, kfCluster, applied); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Writes) != 0 {
+		t.Errorf("applying an unchanged object wrote %v", c.Writes)
+	}
+	if applied.ResourceVersion != created.ResourceVersion {
+		t.Errorf("resourceVersion = %q; want %q", applied.ResourceVersion, created.ResourceVersion)
+	}
+	if applied.Spec.Template.Spec.Containers[0].TerminationMessagePath == "" {
+		t.Errorf("container terminationMessagePath is empty; want the live object with its defaults")
+	}
+	owned, err := c.ManagedFields(applied, FieldManager)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range owned {
+		if path == "spec.strategy.type" || path == "spec.revisionHistoryLimit" {
+			t.Errorf("%s is owned by %s; want the defaulted fields left to the API server", path, FieldManager)
+		}
+	}
+}
+
+func TestApplyChangedWrites(t *testing.T) {
+	scheme := testScheme(t)
+	c := providertest.NewClient(scheme)
+	opts := Options{Client: c, Scheme: scheme}
+	ctx := context.Background()
+	kfCluster := testKfCluster()
+
+	created := testDeployment("kf-clusterctl:v1")
+	if err := Apply(ctx, opts, kfCluster, created); err != nil {
+		t.Fatal(err)
+	}
+	c.Reset()
+	applied := testDeployment("kf-clusterctl:v2")
+	if err := Apply(ctx, opts, kfCluster, applied); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Writes) != 1 {
+		t.Errorf("applying a changed object wrote %v; want one update", c.Writes)
+	}
+	if applied.ResourceVersion == created.ResourceVersion {
+		t.Errorf("resourceVersion = %q; want it bumped", applied.ResourceVersion)
+	}
+	if image := applied.Spec.Template.Spec.Containers[0].Image; image != "kf-clusterctl:v2" {
+		t.Errorf("image = %q; want kf-clusterctl:v2", image)
+	}
+}
+
+func TestApplyRemovesDroppedFields(t *testing.T) {
+	scheme := testScheme(t)
+	c := providertest.NewClient(scheme)
+	opts := Options{Client: c, Scheme: scheme}
+	ctx := context.Background()
+	kfCluster := testKfCluster()
+
+	created := testDeployment("kf-clusterctl")
+	created.Spec.Template.Annotations = map[string]string{"kfcluster.kubeflow.org/secret-hash": "abc"}
+	created.Spec.Template.Spec.NodeSelector = map[string]string{"pool": "provisioners", "zone": "a"}
+	created.Spec.Template.Spec.Tolerations = []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpExists}}
+	created.Spec.Template.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "registry"}}
+	if err := Apply(ctx, opts, kfCluster, created); err != nil {
+		t.Fatal(err)
+	}
+	// A field set by someone else is kept
+	edited := created.DeepCopy()
+	edited.Annotations = map[string]string{"owner": "platform-team"}
+	if err := c.Update(ctx, edited); err != nil {
+		t.Fatal(err)
+	}
+
+	applied := testDeployment("kf-clusterctl")
+	applied.Spec.Template.Spec.NodeSelector = map[string]string{"pool": "provisioners"}
+	if err := Apply(ctx, opts, kfCluster, applied); err != nil {
+		t.Fatal(err)
+	}
+	podSpec := applied.Spec.Template.Spec
+	if len(podSpec.NodeSelector) != 1 || podSpec.NodeSelector["pool"] != "provisioners" {
+		t.Errorf("nodeSelector = %v; want only pool=provisioners", podSpec.NodeSelector)
+	}
+	if len(podSpec.Tolerations) != 0 {
+		t.Errorf("tolerations = %v; want them removed", podSpec.Tolerations)
+	}
+	if len(podSpec.ImagePullSecrets) != 0 {
+		t.Errorf("imagePullSecrets = %v; want them removed", podSpec.ImagePullSecrets)
+	}
+	if _, ok := applied.Spec.Template.Annotations["kfcluster.kubeflow.org/secret-hash"]; ok {
+		t.Errorf("template annotations = %v; want the secret hash removed", applied.Spec.Template.Annotations)
+	}
+	if applied.Annotations["owner"] != "platform-team" {
+		t.Errorf("annotations = %v; want the owner annotation kept", applied.Annotations)
+	}
+}
@@ -0,0 +1,39 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+)
+
+// terminalError is an error retrying can't fix, such as a spec the platform rejects
+type terminalError struct {
+	err error
+}
+
+func (e *terminalError) Error() string {
+	return e.err.Error()
+}
+
+func (e *terminalError) Unwrap() error {
+	return e.err
+}
+
+// Terminal marks err as one retrying can't fix; the reconciler fails the KfCluster on it right away.
+// Errors that aren't marked are retried.
+func Terminal(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &terminalError{err: err}
+}
+
+// Terminalf returns a terminal error with a formatted message
+func Terminalf(format string, args ...interface{}) error {
+	return Terminal(fmt.Errorf(format, args...))
+}
+
+// IsTerminal returns true for errors marked with Terminal, including when they are wrapped
+func IsTerminal(err error) bool {
+	var terminal *terminalError
+	return errors.As(err, &terminal)
+}
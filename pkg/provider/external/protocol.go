@@ -0,0 +1,74 @@
+// Package external calls out-of-tree providers shipped as executables.
+//
+// For every operation the controller runs the provider executable, writes a Request as JSON to its stdin
+// and reads a Response as JSON from its stdout. Anything written to stderr is logged when the call fails.
+// A provider reports progress by returning Done false; the operation is called again on a later reconcile,
+// so every operation must be idempotent and must not block on the platform. Calls are killed after
+// DefaultTimeout: an operation that takes longer is started in the background and polled through Done.
+// A provider running as a sidecar ships a small client executable on a volume shared with the controller.
+package external
+
+import (
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// APIVersion is the version of the protocol; requests and responses of other versions are rejected
+const APIVersion = "provider.cluster.kubeflow.org/v1alpha1"
+
+// Operation names what a request asks the provider to do
+type Operation string
+
+// Operations of the protocol
+const (
+	// OperationCreate creates the cluster or brings it in line with the spec
+	OperationCreate Operation = "create"
+	// OperationKubeconfig returns the kubeconfig of a created cluster
+	OperationKubeconfig Operation = "kubeconfig"
+	// OperationUpgrade moves the cluster to the versions in the spec
+	OperationUpgrade Operation = "upgrade"
+	// OperationDelete deletes the cluster
+	OperationDelete Operation = "delete"
+	// OperationStatus summarizes the state of the cluster
+	OperationStatus Operation = "status"
+)
+
+// Request is written to the stdin of the provider
+type Request struct {
+	APIVersion string    `json:"apiVersion"`
+	Operation  Operation `json:"operation"`
+	// Cluster is the KfCluster being reconciled, with the conditions the provider returned before
+	Cluster *cluster.KfCluster `json:"cluster"`
+	// Secrets holds the values of spec.external.secret_refs, keyed by Secret key
+	Secrets map[string][]byte `json:"secrets,omitempty"`
+}
+
+// Response is read from the stdout of the provider
+type Response struct {
+	APIVersion string `json:"apiVersion"`
+	// Done reports that a create, upgrade or delete has completed
+	Done bool `json:"done,omitempty"`
+	// Error fails the operation; it is retried on a later reconcile
+	Error string `json:"error,omitempty"`
+	// Kubeconfig answers a kubeconfig operation; it is base64 encoded in JSON
+	Kubeconfig []byte `json:"kubeconfig,omitempty"`
+	// Status answers a status operation
+	Status *Status `json:"status,omitempty"`
+	// Conditions are set on the KfCluster status, so that providers can report their own stages
+	Conditions []Condition `json:"conditions,omitempty"`
+}
+
+// Status summarizes the state of a cluster
+type Status struct {
+	Ready   bool   `json:"ready"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// Condition is a typed condition reported by a provider
+type Condition struct {
+	Type    cluster.KfClusterConditionType `json:"type"`
+	Status  corev1.ConditionStatus         `json:"status"`
+	Reason  string                         `json:"reason,omitempty"`
+	Message string                         `json:"message,omitempty"`
+}
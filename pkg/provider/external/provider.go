@@ -0,0 +1,164 @@
+package external
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	"github.com/CiscoAI/kf-cluster-api/pkg/provider"
+)
+
+// DefaultTimeout bounds a single call to a provider. Calls run inside a reconcile and hold up its worker,
+// so providers start long operations in the background and report them with Done false.
+const DefaultTimeout = 30 * time.Second
+
+// Provider runs an out-of-tree provider executable for every operation
+type Provider struct {
+	provider.Options
+	// Path is the provider executable
+	Path string
+	// Timeout bounds a single call; defaults to DefaultTimeout
+	Timeout time.Duration
+}
+
+var _ provider.Provider = &Provider{}
+
+// Register makes platform available to KfClusters, served by the provider executable at path
+func Register(platform cluster.KfPlatform, path string) error {
+	for _, registered := range provider.Platforms() {
+		if registered == platform {
+			return fmt.Errorf("platform %q already has a provider", platform)
+		}
+	}
+	if _, err := exec.LookPath(path); err != nil {
+		return fmt.Errorf("provider executable for platform %q: %v", platform, err)
+	}
+	provider.Register(platform, func(opts provider.Options) provider.Provider {
+		return &Provider{Options: opts, Path: path}
+	})
+	cluster.RegisterExternalPlatform(platform)
+	return nil
+}
+
+// ParseProviders parses a comma separated list of platform=path pairs
+func ParseProviders(value string) (map[cluster.KfPlatform]string, error) {
+	providers := map[cluster.KfPlatform]string{}
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid external provider %q, expected platform=path", pair)
+		}
+		providers[cluster.KfPlatform(parts[0])] = parts[1]
+	}
+	return providers, nil
+}
+
+// EnsureInfrastructure calls the create operation
+func (p *Provider) EnsureInfrastructure(ctx context.Context, kfCluster *cluster.KfCluster) (bool, error) {
+	response, err := p.call(ctx, OperationCreate, kfCluster)
+	if err != nil {
+		return false, err
+	}
+	return response.Done, nil
+}
+
+// GetKubeconfig calls the kubeconfig operation
+func (p *Provider) GetKubeconfig(ctx context.Context, kfCluster *cluster.KfCluster) ([]byte, error) {
+	response, err := p.call(ctx, OperationKubeconfig, kfCluster)
+	if err != nil {
+		return nil, err
+	}
+	return response.Kubeconfig, nil
+}
+
+// Upgrade calls the upgrade operation
+func (p *Provider) Upgrade(ctx context.Context, kfCluster *cluster.KfCluster) (bool, error) {
+	response, err := p.call(ctx, OperationUpgrade, kfCluster)
+	if err != nil {
+		return false, err
+	}
+	return response.Done, nil
+}
+
+// Teardown calls the delete operation
+func (p *Provider) Teardown(ctx context.Context, kfCluster *cluster.KfCluster) (bool, error) {
+	response, err := p.call(ctx, OperationDelete, kfCluster)
+	if err != nil {
+		return false, err
+	}
+	return response.Done, nil
+}
+
+// Status calls the status operation
+func (p *Provider) Status(ctx context.Context, kfCluster *cluster.KfCluster) (provider.Status, error) {
+	response, err := p.call(ctx, OperationStatus, kfCluster)
+	if err != nil {
+		return provider.Status{}, err
+	}
+	if response.Status == nil {
+		return provider.Status{}, fmt.Errorf("provider %s returned no status", p.Path)
+	}
+	return provider.Status{Ready: response.Status.Ready, Reason: response.Status.Reason, Message: response.Status.Message}, nil
+}
+
+// call runs the provider for one operation and applies the conditions it returns
+func (p *Provider) call(ctx context.Context, operation Operation, kfCluster *cluster.KfCluster) (*Response, error) {
+	log := p.Log.WithValues("kfcluster", kfCluster.Namespace+"/"+kfCluster.Name, "operation", operation)
+	request := Request{APIVersion: APIVersion, Operation: operation, Cluster: kfCluster}
+	if kfCluster.Spec.External != nil && len(kfCluster.Spec.External.SecretRefs) > 0 {
+		request.Secrets = map[string][]byte{}
+		for i := range kfCluster.Spec.External.SecretRefs {
+			ref := &kfCluster.Spec.External.SecretRefs[i]
+			value, err := provider.SecretKey(ctx, p.Options, kfCluster.Namespace, ref)
+			if err != nil {
+				return nil, err
+			}
+			request.Secrets[ref.Key] = value
+		}
+	}
+	input, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, p.Path)
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		log.Info("external provider failed", "stderr", stderr.String())
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("provider %s didn't answer %s within %s; it must return done false instead of waiting on the platform",
+				p.Path, operation, timeout)
+		}
+		return nil, fmt.Errorf("provider %s failed on %s: %v", p.Path, operation, err)
+	}
+	response := &Response{}
+	if err := json.Unmarshal(stdout.Bytes(), response); err != nil {
+		return nil, fmt.Errorf("provider %s returned an invalid response to %s: %v", p.Path, operation, err)
+	}
+	if response.APIVersion != APIVersion {
+		return nil, provider.Terminalf("provider %s answered with protocol %q, expected %q", p.Path, response.APIVersion, APIVersion)
+	}
+	for _, condition := range response.Conditions {
+		kfCluster.Status.SetCondition(condition.Type, condition.Status, condition.Reason, condition.Message)
+	}
+	if response.Error != "" {
+		return nil, fmt.Errorf("provider %s failed on %s: %s", p.Path, operation, response.Error)
+	}
+	return response, nil
+}
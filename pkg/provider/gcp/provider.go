@@ -0,0 +1,152 @@
+// Package gcp provides clusters on Compute Engine, either created by kops from a provisioner pod
+// or bootstrapped with kubeadm by the controller itself.
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	gcpclient "github.com/CiscoAI/kf-cluster-api/pkg/gcp"
+	"github.com/CiscoAI/kf-cluster-api/pkg/provider"
+	"github.com/CiscoAI/kf-cluster-api/pkg/provider/pod"
+)
+
+func init() {
+	provider.Register(cluster.KfGcp, New)
+}
+
+// Provider picks the provisioner pod or the staged kubeadm provisioner by the bootstrap of each KfCluster
+type Provider struct {
+	pod    provider.Provider
+	staged provider.Provider
+}
+
+var _ provider.Provider = &Provider{}
+
+// New returns the Provider for GCP
+func New(opts provider.Options) provider.Provider {
+	return &Provider{
+		pod: pod.New(opts),
+		staged: &provider.StagedProvider{
+			Options: opts,
+			NewStages: func(ctx context.Context, kfCluster *cluster.KfCluster) (provider.Stages, error) {
+				return newProvisioner(ctx, opts, kfCluster)
+			},
+			IsTerminal: gcpclient.IsTerminal,
+		},
+	}
+}
+
+func (p *Provider) delegate(kfCluster *cluster.KfCluster) provider.Provider {
+	if kfCluster.Spec.GCP != nil && kfCluster.Spec.GCP.Bootstrap == cluster.GCPBootstrapKubeadm {
+		return p.staged
+	}
+	return p.pod
+}
+
+// EnsureInfrastructure creates the instances and cluster
+func (p *Provider) EnsureInfrastructure(ctx context.Context, kfCluster *cluster.KfCluster) (bool, error) {
+	return p.delegate(kfCluster).EnsureInfrastructure(ctx, kfCluster)
+}
+
+// GetKubeconfig returns the kubeconfig of a kubeadm cluster
+func (p *Provider) GetKubeconfig(ctx context.Context, kfCluster *cluster.KfCluster) ([]byte, error) {
+	return p.delegate(kfCluster).GetKubeconfig(ctx, kfCluster)
+}
+
+// Upgrade moves the cluster to the versions in the spec
+func (p *Provider) Upgrade(ctx context.Context, kfCluster *cluster.KfCluster) (bool, error) {
+	return p.delegate(kfCluster).Upgrade(ctx, kfCluster)
+}
+
+// Teardown deletes the instances and network resources of a kubeadm cluster
+func (p *Provider) Teardown(ctx context.Context, kfCluster *cluster.KfCluster) (bool, error) {
+	return p.delegate(kfCluster).Teardown(ctx, kfCluster)
+}
+
+// Status summarizes the state of the cluster
+func (p *Provider) Status(ctx context.Context, kfCluster *cluster.KfCluster) (provider.Status, error) {
+	return p.delegate(kfCluster).Status(ctx, kfCluster)
+}
+
+// newProvisioner builds the kubeadm provisioner for a KfCluster from its spec, ConfigMap overrides and persisted PKI
+func newProvisioner(ctx context.Context, opts provider.Options, kfCluster *cluster.KfCluster) (*gcpclient.Provisioner, error) {
+	configOverrides, err := provider.ConfigOverrides(ctx, opts, kfCluster)
+	if err != nil {
+		return nil, err
+	}
+	setting := func(key string, value string) string {
+		if override, ok := configOverrides[key]; ok {
+			return override
+		}
+		return value
+	}
+	spec := kfCluster.Spec.GCP
+	config := gcpclient.ClusterConfig{
+		Name:        kfCluster.Name,
+		Project:     setting("PROJECT", spec.Project),
+		Zone:        setting("ZONE", spec.Zone),
+		Region:      setting("REGION", spec.Region),
+		Network:     setting("NETWORK", spec.Network),
+		MachineType: setting("MACHINE_TYPE", spec.MachineType),
+		NodeCount:   spec.NodeCount,
+		// SourceRanges default to the controller's external IP in the Network stage
+		SourceRanges: spec.APISourceRanges,
+		// KubernetesVersion is fixed once the instances run it; see the webhook
+		KubernetesVersion:  kfCluster.Spec.KubernetesVersion,
+		NodeServiceAccount: spec.NodeServiceAccount,
+	}
+	if nodeCount, ok := configOverrides["NODE_COUNT"]; ok {
+		count, err := strconv.Atoi(nodeCount)
+		if err != nil {
+			return nil, fmt.Errorf("invalid NODE_COUNT %q in config map: %v", nodeCount, err)
+		}
+		config.NodeCount = int32(count)
+	}
+	if config.Project == "" || config.Zone == "" {
+		return nil, provider.Terminalf("a GCP project and zone are required")
+	}
+	auth, err := AuthConfig(ctx, opts, kfCluster.Namespace, spec.Auth)
+	if err != nil {
+		return nil, err
+	}
+	computeService, err := gcpclient.GetClient(ctx, auth)
+	if err != nil {
+		return nil, err
+	}
+	pki, err := provider.ClusterPKI(ctx, opts, kfCluster)
+	if err != nil {
+		return nil, err
+	}
+	return gcpclient.NewProvisioner(config, pki, computeService), nil
+}
+
+// AuthConfig returns the credentials the controller uses for a KfCluster's GCP API calls.
+// With a GCP service account in the spec the controller impersonates it, whatever the mode the
+// provisioner pod uses; otherwise the controller's own Application Default Credentials apply.
+// The service account must be allowed for the namespace, which the webhook checks too, so that
+// KfClusters admitted before it was revoked can't use it either.
+func AuthConfig(ctx context.Context, opts provider.Options, namespace string, auth *cluster.GCPAuthSpec) (gcpclient.AuthConfig, error) {
+	if auth == nil {
+		return gcpclient.AuthConfig{}, nil
+	}
+	switch auth.Mode {
+	case "", cluster.GCPAuthWorkloadIdentity, cluster.GCPAuthImpersonation:
+		if !cluster.GCPServiceAccountAllowed(namespace, auth.ServiceAccount) {
+			return gcpclient.AuthConfig{}, provider.Terminalf("GCP service account %s is not allowed in namespace %s", auth.ServiceAccount, namespace)
+		}
+		return gcpclient.AuthConfig{ImpersonateServiceAccount: auth.ServiceAccount}, nil
+	case cluster.GCPAuthKeyFile:
+		if auth.KeySecretRef == nil {
+			return gcpclient.AuthConfig{}, nil
+		}
+		key, err := provider.SecretKey(ctx, opts, namespace, auth.KeySecretRef)
+		if err != nil {
+			return gcpclient.AuthConfig{}, err
+		}
+		return gcpclient.AuthConfig{CredentialsJSON: key}, nil
+	}
+	return gcpclient.AuthConfig{}, nil
+}
@@ -0,0 +1,51 @@
+// Package generic provides clusters managed outside the controller, where only Kubeflow is installed.
+package generic
+
+import (
+	"context"
+
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	"github.com/CiscoAI/kf-cluster-api/pkg/provider"
+)
+
+func init() {
+	provider.Register(cluster.KfGeneric, New)
+}
+
+// Provider leaves the cluster alone
+type Provider struct {
+	provider.Options
+}
+
+var _ provider.Provider = &Provider{}
+
+// New returns the Provider for existing clusters
+func New(opts provider.Options) provider.Provider {
+	return &Provider{Options: opts}
+}
+
+// EnsureInfrastructure has nothing to create
+func (p *Provider) EnsureInfrastructure(ctx context.Context, kfCluster *cluster.KfCluster) (bool, error) {
+	p.Log.Info("Reconciling KfCluster on k8s", "kfcluster", kfCluster.Namespace+"/"+kfCluster.Name)
+	return true, nil
+}
+
+// GetKubeconfig returns nil: the controller isn't given access to the cluster
+func (p *Provider) GetKubeconfig(ctx context.Context, kfCluster *cluster.KfCluster) ([]byte, error) {
+	return nil, nil
+}
+
+// Upgrade has nothing to do
+func (p *Provider) Upgrade(ctx context.Context, kfCluster *cluster.KfCluster) (bool, error) {
+	return true, nil
+}
+
+// Teardown has nothing to do: the cluster outlives its KfCluster
+func (p *Provider) Teardown(ctx context.Context, kfCluster *cluster.KfCluster) (bool, error) {
+	return true, nil
+}
+
+// Status always reports the cluster ready
+func (p *Provider) Status(ctx context.Context, kfCluster *cluster.KfCluster) (provider.Status, error) {
+	return provider.Status{Ready: true, Reason: "ExternallyManaged", Message: "the cluster is managed outside the controller"}, nil
+}
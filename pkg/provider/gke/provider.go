@@ -0,0 +1,67 @@
+// Package gke provides GKE clusters created through the Container API.
+package gke
+
+import (
+	"context"
+
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	gcpclient "github.com/CiscoAI/kf-cluster-api/pkg/gcp"
+	"github.com/CiscoAI/kf-cluster-api/pkg/provider"
+	"github.com/CiscoAI/kf-cluster-api/pkg/provider/gcp"
+)
+
+func init() {
+	provider.Register(cluster.KfGke, New)
+}
+
+// New returns the staged Provider for GKE
+func New(opts provider.Options) provider.Provider {
+	return &provider.StagedProvider{
+		Options: opts,
+		NewStages: func(ctx context.Context, kfCluster *cluster.KfCluster) (provider.Stages, error) {
+			return newProvisioner(ctx, opts, kfCluster)
+		},
+		IsTerminal: gcpclient.IsTerminal,
+	}
+}
+
+// newProvisioner builds the GKE provisioner for a KfCluster from its spec
+func newProvisioner(ctx context.Context, opts provider.Options, kfCluster *cluster.KfCluster) (*gcpclient.GKEProvisioner, error) {
+	spec := kfCluster.Spec.GKE
+	if spec == nil || spec.Project == "" || spec.Location == "" {
+		return nil, provider.Terminalf("a GKE project and location are required")
+	}
+	config := gcpclient.GKEConfig{
+		Name:               kfCluster.Name,
+		Project:            spec.Project,
+		Location:           spec.Location,
+		Network:            spec.Network,
+		Version:            kfCluster.DesiredKubernetesVersion(),
+		NodeServiceAccount: spec.NodeServiceAccount,
+	}
+	for _, pool := range spec.NodePools {
+		config.NodePools = append(config.NodePools, gcpclient.NodePoolConfig{
+			Name:         pool.Name,
+			MachineType:  pool.MachineType,
+			NodeCount:    pool.NodeCount,
+			DiskSizeGb:   pool.DiskSizeGb,
+			MinNodeCount: pool.MinNodeCount,
+			MaxNodeCount: pool.MaxNodeCount,
+			Preemptible:  pool.Preemptible,
+			Labels:       pool.Labels,
+		})
+	}
+	auth, err := gcp.AuthConfig(ctx, opts, kfCluster.Namespace, spec.Auth)
+	if err != nil {
+		return nil, err
+	}
+	containerService, err := gcpclient.GetContainerClient(ctx, auth)
+	if err != nil {
+		return nil, err
+	}
+	computeService, err := gcpclient.GetClient(ctx, auth)
+	if err != nil {
+		return nil, err
+	}
+	return gcpclient.NewGKEProvisioner(config, auth, containerService, computeService), nil
+}
@@ -0,0 +1,86 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	"github.com/CiscoAI/kf-cluster-api/pkg/kubernetes"
+	"github.com/CiscoAI/kf-cluster-api/pkg/provision"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ConfigOverrides returns the data of the ConfigMap named in the KfCluster spec.
+// A missing ConfigMap yields no overrides; the provisioner pod reports it when it fails to start.
+func ConfigOverrides(ctx context.Context, opts Options, kfCluster *cluster.KfCluster) (map[string]string, error) {
+	if kfCluster.Spec.ConfigMapName == "" {
+		return nil, nil
+	}
+	configMap := &corev1.ConfigMap{}
+	err := opts.Client.Get(ctx, types.NamespacedName{Name: kfCluster.Spec.ConfigMapName, Namespace: kfCluster.Namespace}, configMap)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return configMap.Data, nil
+}
+
+// ProvisionerStopped reports whether upgrade Jobs can start: the provisioner Deployment is scaled down while they run,
+// see kubernetes.StopProvisionerForUpgrade, and its pods must be gone before a Job mounts the volume claim
+func ProvisionerStopped(ctx context.Context, opts Options, kfCluster *cluster.KfCluster) (bool, error) {
+	if !kubernetes.StopProvisionerForUpgrade(kfCluster) {
+		return true, nil
+	}
+	pods := &corev1.PodList{}
+	if err := opts.Client.List(ctx, pods, client.InNamespace(kfCluster.Namespace), client.MatchingLabels{"kfcluster": kfCluster.Name}); err != nil {
+		return false, err
+	}
+	return len(pods.Items) == 0, nil
+}
+
+// SecretKey returns the value of a Secret key in the namespace of a KfCluster
+func SecretKey(ctx context.Context, opts Options, namespace string, ref *corev1.SecretKeySelector) ([]byte, error) {
+	secret := &corev1.Secret{}
+	if err := opts.Client.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: namespace}, secret); err != nil {
+		return nil, err
+	}
+	value, ok := secret.Data[ref.Key]
+	if !ok {
+		return nil, fmt.Errorf("secret %s has no key %s", ref.Name, ref.Key)
+	}
+	return value, nil
+}
+
+// ClusterPKI returns the cluster CA and bootstrap token of a KfCluster, generating and
+// persisting them on first use so that provisioning can resume across reconciles
+func ClusterPKI(ctx context.Context, opts Options, kfCluster *cluster.KfCluster) (*provision.ClusterPKI, error) {
+	secret := &corev1.Secret{}
+	key := types.NamespacedName{Name: kfCluster.Name + "-pki", Namespace: kfCluster.Namespace}
+	if err := opts.Client.Get(ctx, key, secret); err == nil {
+		return provision.ClusterPKIFromData(secret.Data)
+	} else if !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	pki, err := provision.NewClusterPKI(kfCluster.Name)
+	if err != nil {
+		return nil, err
+	}
+	secret = &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.Name,
+			Namespace: key.Namespace,
+			Labels:    map[string]string{"kfcluster": kfCluster.Name},
+		},
+		Data: pki.Data(),
+	}
+	if err := Apply(ctx, opts, kfCluster, secret); err != nil {
+		return nil, err
+	}
+	return pki, nil
+}
@@ -0,0 +1,13 @@
+// Package kind provides kind clusters for local development, created by a provisioner pod
+// running Docker in Docker next to kf-clusterctl.
+package kind
+
+import (
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	"github.com/CiscoAI/kf-cluster-api/pkg/provider"
+	"github.com/CiscoAI/kf-cluster-api/pkg/provider/pod"
+)
+
+func init() {
+	provider.Register(cluster.KfKind, pod.New)
+}
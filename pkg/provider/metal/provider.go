@@ -0,0 +1,61 @@
+// Package metal provides kubeadm clusters on existing hosts reached over SSH.
+package metal
+
+import (
+	"context"
+
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	"github.com/CiscoAI/kf-cluster-api/pkg/metal"
+	"github.com/CiscoAI/kf-cluster-api/pkg/provider"
+)
+
+func init() {
+	provider.Register(cluster.KfMetal, New)
+}
+
+// New returns the staged Provider for metal hosts
+func New(opts provider.Options) provider.Provider {
+	return &provider.StagedProvider{
+		Options: opts,
+		NewStages: func(ctx context.Context, kfCluster *cluster.KfCluster) (provider.Stages, error) {
+			return newProvisioner(ctx, opts, kfCluster)
+		},
+	}
+}
+
+// newProvisioner builds the SSH provisioner for a KfCluster from its spec, SSH key Secret and persisted PKI
+func newProvisioner(ctx context.Context, opts provider.Options, kfCluster *cluster.KfCluster) (*metal.Provisioner, error) {
+	spec := kfCluster.Spec.Metal
+	if spec == nil || len(spec.Hosts) == 0 || spec.SSHKeySecretRef == nil {
+		return nil, provider.Terminalf("metal hosts and an SSH key are required")
+	}
+	privateKey, err := provider.SecretKey(ctx, opts, kfCluster.Namespace, spec.SSHKeySecretRef)
+	if err != nil {
+		return nil, err
+	}
+	var knownHosts []byte
+	if spec.KnownHostsSecretRef != nil {
+		knownHosts, err = provider.SecretKey(ctx, opts, kfCluster.Namespace, spec.KnownHostsSecretRef)
+		if err != nil {
+			return nil, err
+		}
+	}
+	pki, err := provider.ClusterPKI(ctx, opts, kfCluster)
+	if err != nil {
+		return nil, err
+	}
+	return metal.NewProvisioner(metal.ClusterConfig{
+		Name:                  kfCluster.Name,
+		Hosts:                 spec.Hosts,
+		ControlPlaneEndpoint:  spec.ControlPlaneEndpoint,
+		KubernetesVersion:     kfCluster.Spec.KubernetesVersion,
+		SkipInstall:           spec.SkipInstall,
+		IgnorePreflightErrors: spec.IgnorePreflightErrors,
+		SSH: metal.SSHConfig{
+			User:                            spec.User,
+			PrivateKey:                      privateKey,
+			KnownHosts:                      knownHosts,
+			InsecureSkipHostKeyVerification: spec.InsecureSkipHostKeyVerification,
+		},
+	}, pki), nil
+}
@@ -0,0 +1,200 @@
+// Package pod provides clusters through a provisioner pod running kf-clusterctl,
+// which creates the cluster and installs Kubeflow on it from inside the management cluster.
+package pod
+
+import (
+	"context"
+	"fmt"
+
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	"github.com/CiscoAI/kf-cluster-api/pkg/kubernetes"
+	"github.com/CiscoAI/kf-cluster-api/pkg/provider"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Provider runs the provisioner Deployment and its volume claim.
+// The kubeconfig stays on the provisioner volume, so GetKubeconfig returns nil.
+type Provider struct {
+	provider.Options
+}
+
+var _ provider.Provider = &Provider{}
+
+// New returns the Provider for provisioner pods
+func New(opts provider.Options) provider.Provider {
+	return &Provider{Options: opts}
+}
+
+// EnsureInfrastructure keeps the provisioner Deployment and volume claim in line with the spec
+// and returns true once the provisioner is available
+func (p *Provider) EnsureInfrastructure(ctx context.Context, kfCluster *cluster.KfCluster) (bool, error) {
+	log := p.Log.WithValues("kfcluster", kfCluster.Namespace+"/"+kfCluster.Name)
+	log.Info("Reconciling KfCluster provisioner", "platform", kfCluster.Spec.Platform)
+	configOverrides, err := provider.ConfigOverrides(ctx, p.Options, kfCluster)
+	if err != nil {
+		log.Error(err, "error getting KfCluster config map")
+		return false, err
+	}
+	secrets, err := p.getSecrets(ctx, kfCluster)
+	if err != nil {
+		log.Error(err, "error getting KfCluster secrets")
+		return false, err
+	}
+	if err := kubernetes.VerifySecrets(kfCluster, secrets); err != nil {
+		log.Info("waiting for KfCluster secrets", "reason", err.Error())
+		kfCluster.Status.SetCondition(cluster.SecretsReady, corev1.ConditionFalse, "SecretNotFound", err.Error())
+		return false, nil
+	}
+	kfCluster.Status.SetCondition(cluster.SecretsReady, corev1.ConditionTrue, "SecretsFound", "all secrets are present")
+	if err := p.reconcileServiceAccount(ctx, kfCluster); err != nil {
+		return false, err
+	}
+	storageClasses := &storagev1.StorageClassList{}
+	if err := p.Client.List(ctx, storageClasses); err != nil {
+		log.Error(err, "error listing storage classes")
+		return false, err
+	}
+	volumeConfig, storageClass := kubernetes.ResolveVolumeConfig(kfCluster, storageClasses.Items)
+	deployment, kfVolumeClaim := kubernetes.CreateDeployment(kfCluster, kubernetes.DeploymentOptions{
+		ConfigOverrides: configOverrides,
+		Provisioner:     p.Provisioner,
+		Volume:          volumeConfig,
+		SecretHash:      kubernetes.HashSecrets(kubernetes.SecretNames(kfCluster), secrets),
+	})
+	if deployment == nil {
+		log.Info("Deploymeny spec wasn't generated")
+		return false, fmt.Errorf("error generating deployment spec")
+	}
+	if kfVolumeClaim == nil {
+		log.Info("VolumeClaim spec wasn't generated")
+		return false, fmt.Errorf("error generating volumeclaim spec")
+	}
+	if err := p.reconcileVolumeClaim(ctx, kfCluster, kfVolumeClaim); err != nil {
+		log.Error(err, "error applying volume claim")
+		return false, err
+	}
+	volumeStatus, volumeReason, volumeMessage := volumeBoundCondition(kfVolumeClaim, volumeConfig, storageClass)
+	kfCluster.Status.SetCondition(cluster.VolumeBound, volumeStatus, volumeReason, volumeMessage)
+	if err := provider.Apply(ctx, p.Options, kfCluster, deployment); err != nil {
+		log.Error(err, "error applying deployment")
+		return false, err
+	}
+	kfCluster.Status.KubeconfigPath = "/mnt/volume/" + kfCluster.Name + "/kubeconfig"
+
+	kfCluster.Status.RemoveUntypedConditions()
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == appsv1.DeploymentAvailable {
+			kfCluster.Status.SetCondition(cluster.ProvisionerAvailable, condition.Status, condition.Reason, condition.Message)
+			log.Info("provisioner condition", "available", condition.Status, "reason", condition.Reason)
+		}
+	}
+	return deploymentAvailable(deployment), nil
+}
+
+// reconcileVolumeClaim applies the volume claim of the provisioner. Once the claim exists only its storage request
+// can grow, so the rest of its spec is applied as it was created. On return, claim holds the live object.
+func (p *Provider) reconcileVolumeClaim(ctx context.Context, kfCluster *cluster.KfCluster, claim *corev1.PersistentVolumeClaim) error {
+	existing := &corev1.PersistentVolumeClaim{}
+	if err := p.Client.Get(ctx, types.NamespacedName{Name: claim.Name, Namespace: claim.Namespace}, existing); err == nil {
+		claim.Spec.AccessModes = existing.Spec.AccessModes
+		claim.Spec.StorageClassName = existing.Spec.StorageClassName
+		if size := existing.Spec.Resources.Requests[corev1.ResourceStorage]; size.Cmp(claim.Spec.Resources.Requests[corev1.ResourceStorage]) > 0 {
+			claim.Spec.Resources.Requests[corev1.ResourceStorage] = size
+		}
+	} else if !apierrors.IsNotFound(err) {
+		return err
+	}
+	return provider.Apply(ctx, p.Options, kfCluster, claim)
+}
+
+// GetKubeconfig returns nil: the provisioner writes the kubeconfig to its volume, see Status.KubeconfigPath
+func (p *Provider) GetKubeconfig(ctx context.Context, kfCluster *cluster.KfCluster) ([]byte, error) {
+	return nil, nil
+}
+
+// Status reports whether the provisioner Deployment is available
+func (p *Provider) Status(ctx context.Context, kfCluster *cluster.KfCluster) (provider.Status, error) {
+	if condition := kfCluster.Status.GetCondition(cluster.SecretsReady); condition != nil && condition.Status != corev1.ConditionTrue {
+		return provider.Status{Reason: condition.Reason, Message: condition.Message}, nil
+	}
+	deployment := &appsv1.Deployment{}
+	if err := p.Client.Get(ctx, types.NamespacedName{Name: kfCluster.Name, Namespace: kfCluster.Namespace}, deployment); err != nil {
+		if apierrors.IsNotFound(err) {
+			return provider.Status{Reason: "ProvisionerPending", Message: "the provisioner has not been created"}, nil
+		}
+		return provider.Status{}, err
+	}
+	if !deploymentAvailable(deployment) {
+		return provider.Status{Reason: "ProvisionerUnavailable", Message: fmt.Sprintf("provisioner %s is not available", deployment.Name)}, nil
+	}
+	return provider.Status{Ready: true, Reason: "ProvisionerAvailable", Message: fmt.Sprintf("provisioner %s is available", deployment.Name)}, nil
+}
+
+func deploymentAvailable(deployment *appsv1.Deployment) bool {
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == appsv1.DeploymentAvailable && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// volumeBoundCondition derives the VolumeBound condition from the provisioner volume claim
+func volumeBoundCondition(claim *corev1.PersistentVolumeClaim, volumeConfig kubernetes.VolumeConfig, storageClass *storagev1.StorageClass) (corev1.ConditionStatus, string, string) {
+	if claim.Status.Phase == corev1.ClaimBound {
+		return corev1.ConditionTrue, "ClaimBound", fmt.Sprintf("volume claim %s is bound", claim.Name)
+	}
+	if claim.Status.Phase == corev1.ClaimLost {
+		return corev1.ConditionFalse, "ClaimLost", fmt.Sprintf("volume claim %s lost its volume", claim.Name)
+	}
+	if volumeConfig.StorageClassName == "" {
+		return corev1.ConditionFalse, "NoDefaultStorageClass",
+			"the cluster has no default StorageClass; set spec.storage.storage_class_name"
+	}
+	if storageClass == nil {
+		return corev1.ConditionFalse, "StorageClassNotFound",
+			fmt.Sprintf("StorageClass %s does not exist", volumeConfig.StorageClassName)
+	}
+	if storageClass.VolumeBindingMode != nil && *storageClass.VolumeBindingMode == storagev1.VolumeBindingWaitForFirstConsumer {
+		return corev1.ConditionUnknown, "WaitForFirstConsumer",
+			fmt.Sprintf("volume claim %s is bound once the provisioner pod is scheduled", claim.Name)
+	}
+	return corev1.ConditionFalse, "ClaimPending",
+		fmt.Sprintf("volume claim %s is pending with %s access on StorageClass %s", claim.Name, volumeConfig.AccessMode, storageClass.Name)
+}
+
+// reconcileServiceAccount keeps the Workload Identity service account of the provisioner bound
+// to the GCP service account from the spec
+func (p *Provider) reconcileServiceAccount(ctx context.Context, kfCluster *cluster.KfCluster) error {
+	log := p.Log.WithValues("kfcluster", kfCluster.Namespace+"/"+kfCluster.Name)
+	serviceAccount := kubernetes.CreateServiceAccount(kfCluster)
+	if serviceAccount == nil {
+		return nil
+	}
+	if err := provider.Apply(ctx, p.Options, kfCluster, serviceAccount); err != nil {
+		log.Error(err, "error applying service account")
+		return err
+	}
+	return nil
+}
+
+// getSecrets fetches the Secrets injected into the provisioner, keyed by name.
+// Secrets that don't exist are left out of the map.
+func (p *Provider) getSecrets(ctx context.Context, kfCluster *cluster.KfCluster) (map[string]*corev1.Secret, error) {
+	secrets := map[string]*corev1.Secret{}
+	for _, name := range kubernetes.SecretNames(kfCluster) {
+		secret := &corev1.Secret{}
+		if err := p.Client.Get(ctx, types.NamespacedName{Name: name, Namespace: kfCluster.Namespace}, secret); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		secrets[name] = secret
+	}
+	return secrets, nil
+}
@@ -0,0 +1,101 @@
+package pod
+
+import (
+	"context"
+	"testing"
+
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	"github.com/CiscoAI/kf-cluster-api/pkg/provider"
+	"github.com/CiscoAI/kf-cluster-api/pkg/provider/providertest"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func TestEnsureInfrastructureIsIdempotent(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := cluster.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	kfCluster := &cluster.KfCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", UID: "test-uid"},
+		Spec: cluster.KfClusterSpec{
+			Platform: cluster.KfGcp,
+			GCP: &cluster.GCPSpec{
+				Project: "test-project",
+				Zone:    "us-west1-b",
+				Auth: &cluster.GCPAuthSpec{
+					Mode:           cluster.GCPAuthWorkloadIdentity,
+					ServiceAccount: "provisioner@test-project.iam.gserviceaccount.com",
+				},
+			},
+			Secrets: []string{"test-credentials"},
+		},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-credentials", Namespace: "default"},
+		Data:       map[string][]byte{"token": []byte("secret")},
+	}
+	storageClass := &storagev1.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "standard",
+			Annotations: map[string]string{"storageclass.kubernetes.io/is-default-class": "true"},
+		},
+		Provisioner: "kubernetes.io/gce-pd",
+	}
+	c := providertest.NewClient(scheme, kfCluster, secret, storageClass)
+	p := New(provider.Options{Client: c, Scheme: scheme, Log: logf.NullLogger{}})
+	ctx := context.Background()
+
+	if _, err := p.EnsureInfrastructure(ctx, kfCluster); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{
+		"create ServiceAccount default/test-provisioner": true,
+		"create PersistentVolumeClaim default/test":      true,
+		"create Deployment default/test":                 true,
+	}
+	if len(c.Writes) != len(want) {
+		t.Errorf("first reconcile wrote %v; want the service account, volume claim and deployment", c.Writes)
+	}
+	for _, write := range c.Writes {
+		if !want[write] {
+			t.Errorf("first reconcile wrote %q; want the service account, volume claim and deployment", write)
+		}
+	}
+	c.Reset()
+	if _, err := p.EnsureInfrastructure(ctx, kfCluster); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Writes) != 0 {
+		t.Errorf("second reconcile wrote %v; want no writes", c.Writes)
+	}
+
+	// Fields the controller stops setting are removed from the live objects
+	kfCluster.Spec.Secrets = nil
+	if _, err := p.EnsureInfrastructure(ctx, kfCluster); err != nil {
+		t.Fatal(err)
+	}
+	deployment := &appsv1.Deployment{}
+	if err := c.Get(ctx, types.NamespacedName{Name: "test", Namespace: "default"}, deployment); err != nil {
+		t.Fatal(err)
+	}
+	for _, volume := range deployment.Spec.Template.Spec.Volumes {
+		if volume.Secret != nil {
+			t.Errorf("volume %s mounts secret %s; want it removed with the secret", volume.Name, volume.Secret.SecretName)
+		}
+	}
+	for _, mount := range deployment.Spec.Template.Spec.Containers[0].VolumeMounts {
+		if mount.MountPath == "/etc/test-credentials" {
+			t.Errorf("volume mount %s is kept; want it removed with the secret", mount.Name)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package pod
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	"github.com/CiscoAI/kf-cluster-api/pkg/kubernetes"
+	"github.com/CiscoAI/kf-cluster-api/pkg/provider"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Teardown deletes the kops cluster of a gcp KfCluster from a Job running the provisioner image, and returns true
+// once the Job succeeded. Other platforms have nothing to delete: the kind cluster runs inside the provisioner pod
+// and generic clusters existed before the KfCluster. The provisioner resources are owned by the KfCluster
+// and garbage collected with it.
+func (p *Provider) Teardown(ctx context.Context, kfCluster *cluster.KfCluster) (bool, error) {
+	if kfCluster.Spec.Platform != cluster.KfGcp {
+		return true, nil
+	}
+	log := p.Log.WithValues("kfcluster", kfCluster.Namespace+"/"+kfCluster.Name)
+	jobOpts, err := p.jobOptions(ctx, kfCluster)
+	if err != nil {
+		return false, err
+	}
+	job := kubernetes.CreateTeardownJob(kfCluster, jobOpts)
+	existing := &batchv1.Job{}
+	if err := p.Client.Get(ctx, types.NamespacedName{Name: job.Name, Namespace: job.Namespace}, existing); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return false, err
+		}
+		// The provisioner would go on creating the cluster while the Job deletes it
+		stopped, err := p.stopProvisioner(ctx, kfCluster)
+		if err != nil {
+			return false, err
+		}
+		if !stopped {
+			log.Info("Waiting for the provisioner to stop before deleting the kops cluster")
+			return false, nil
+		}
+		log.Info("Deleting the kops cluster")
+		return false, provider.Apply(ctx, p.Options, kfCluster, job)
+	}
+	finished := kubernetes.JobFinished(existing)
+	if finished == nil {
+		return false, nil
+	}
+	pods := &corev1.PodList{}
+	if err := p.Client.List(ctx, pods, client.InNamespace(existing.Namespace), client.MatchingLabels{"job-name": existing.Name}); err != nil {
+		return false, err
+	}
+	message := strings.TrimSpace(kubernetes.JobTerminationMessage(pods.Items))
+	if finished.Type == batchv1.JobFailed {
+		// Deleting the Job retries the teardown on the next reconcile
+		if err := p.Client.Delete(ctx, existing, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil {
+			return false, err
+		}
+		return false, fmt.Errorf("deleting the kops cluster failed: %s", message)
+	}
+	log.Info("Deleted the kops cluster", "message", message)
+	return true, nil
+}
+
+// stopProvisioner deletes the provisioner Deployment and reports whether its pods are gone
+func (p *Provider) stopProvisioner(ctx context.Context, kfCluster *cluster.KfCluster) (bool, error) {
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: kfCluster.Name, Namespace: kfCluster.Namespace}}
+	if err := p.Client.Delete(ctx, deployment, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !apierrors.IsNotFound(err) {
+		return false, err
+	}
+	pods := &corev1.PodList{}
+	if err := p.Client.List(ctx, pods, client.InNamespace(kfCluster.Namespace), client.MatchingLabels{"kfcluster": kfCluster.Name}); err != nil {
+		return false, err
+	}
+	return len(pods.Items) == 0, nil
+}
@@ -0,0 +1,67 @@
+package pod
+
+import (
+	"context"
+	"testing"
+
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	"github.com/CiscoAI/kf-cluster-api/pkg/kubernetes"
+	"github.com/CiscoAI/kf-cluster-api/pkg/provider"
+	"github.com/CiscoAI/kf-cluster-api/pkg/provider/providertest"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func TestTeardownWaitsForTheKopsDeleteJob(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := cluster.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	kfCluster := &cluster.KfCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", UID: "test-uid"},
+		Spec: cluster.KfClusterSpec{
+			Platform: cluster.KfGcp,
+			GCP:      &cluster.GCPSpec{Project: "test-project", Zone: "us-west1-b"},
+		},
+	}
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	c := providertest.NewClient(scheme, kfCluster, deployment)
+	p := New(provider.Options{Client: c, Scheme: scheme, Log: logf.NullLogger{}})
+	ctx := context.Background()
+
+	if done, err := p.Teardown(ctx, kfCluster); err != nil || done {
+		t.Fatalf("Teardown() = %v, %v; want false while the Job runs", done, err)
+	}
+	if err := c.Get(ctx, types.NamespacedName{Name: "test", Namespace: "default"}, &appsv1.Deployment{}); !apierrors.IsNotFound(err) {
+		t.Errorf("getting the provisioner deployment returned %v; want it deleted", err)
+	}
+	key := types.NamespacedName{Name: kubernetes.TeardownJobName(kfCluster), Namespace: "default"}
+	job := &batchv1.Job{}
+	if err := c.Get(ctx, key, job); err != nil {
+		t.Fatal(err)
+	}
+	if args := job.Spec.Template.Spec.Containers[0].Args; len(args) != 1 || args[0] != "/gcp_teardown_entrypoint.sh" {
+		t.Errorf("teardown Job args = %v; want the teardown entrypoint", args)
+	}
+	if done, err := p.Teardown(ctx, kfCluster); err != nil || done {
+		t.Fatalf("Teardown() = %v, %v; want false while the Job runs", done, err)
+	}
+
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+	if err := c.Client.Update(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	if done, err := p.Teardown(ctx, kfCluster); err != nil || !done {
+		t.Errorf("Teardown() = %v, %v; want true once the Job completed", done, err)
+	}
+}
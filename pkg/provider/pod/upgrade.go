@@ -0,0 +1,91 @@
+package pod
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	"github.com/CiscoAI/kf-cluster-api/pkg/kubernetes"
+	"github.com/CiscoAI/kf-cluster-api/pkg/provider"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Upgrade rolls a kops cluster to the Kubernetes version of the upgrade in progress, from a Job running
+// the provisioner image. Other spec changes roll out with the provisioner Deployment.
+func (p *Provider) Upgrade(ctx context.Context, kfCluster *cluster.KfCluster) (bool, error) {
+	upgrade := kfCluster.Status.KubernetesUpgrade
+	if upgrade == nil || upgrade.Phase != cluster.KubernetesUpgradeInProgress {
+		return true, nil
+	}
+	if kfCluster.Spec.Platform != cluster.KfGcp {
+		return false, provider.Terminalf("the Kubernetes version of %s clusters can't be changed in place", kfCluster.Spec.Platform)
+	}
+	log := p.Log.WithValues("kfcluster", kfCluster.Namespace+"/"+kfCluster.Name)
+	jobOpts, err := p.jobOptions(ctx, kfCluster)
+	if err != nil {
+		return false, err
+	}
+	job, _ := kubernetes.CreateKubernetesUpgradeJob(kfCluster, jobOpts)
+	existing := &batchv1.Job{}
+	if err := p.Client.Get(ctx, types.NamespacedName{Name: job.Name, Namespace: job.Namespace}, existing); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return false, err
+		}
+		stopped, err := provider.ProvisionerStopped(ctx, p.Options, kfCluster)
+		if err != nil {
+			return false, err
+		}
+		if !stopped {
+			log.Info("Waiting for the provisioner to stop before upgrading Kubernetes")
+			return false, nil
+		}
+		log.Info("Starting Kubernetes upgrade", "from", upgrade.FromVersion, "to", upgrade.ToVersion)
+		return false, provider.Apply(ctx, p.Options, kfCluster, job)
+	}
+	if existing.Annotations[kubernetes.UpgradeVersionAnnotation] != upgrade.ToVersion {
+		// Left over from an upgrade to another version; it is recreated on the next reconcile
+		return false, p.Client.Delete(ctx, existing, client.PropagationPolicy(metav1.DeletePropagationBackground))
+	}
+	finished := kubernetes.JobFinished(existing)
+	if finished == nil {
+		return false, nil
+	}
+	if finished.Type == batchv1.JobFailed {
+		pods := &corev1.PodList{}
+		if err := p.Client.List(ctx, pods, client.InNamespace(existing.Namespace), client.MatchingLabels{"job-name": existing.Name}); err != nil {
+			return false, err
+		}
+		message := strings.TrimSpace(kubernetes.JobTerminationMessage(pods.Items))
+		// Deleting the Job retries the upgrade on the next reconcile
+		if err := p.Client.Delete(ctx, existing, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil {
+			return false, err
+		}
+		return false, fmt.Errorf("upgrading Kubernetes to %s failed: %s", upgrade.ToVersion, message)
+	}
+	return true, nil
+}
+
+// jobOptions resolves the settings of Jobs running the provisioner pod, like the provisioner Deployment
+func (p *Provider) jobOptions(ctx context.Context, kfCluster *cluster.KfCluster) (kubernetes.DeploymentOptions, error) {
+	configOverrides, err := provider.ConfigOverrides(ctx, p.Options, kfCluster)
+	if err != nil {
+		return kubernetes.DeploymentOptions{}, err
+	}
+	storageClasses := &storagev1.StorageClassList{}
+	if err := p.Client.List(ctx, storageClasses); err != nil {
+		return kubernetes.DeploymentOptions{}, err
+	}
+	volumeConfig, _ := kubernetes.ResolveVolumeConfig(kfCluster, storageClasses.Items)
+	return kubernetes.DeploymentOptions{
+		ConfigOverrides: configOverrides,
+		Provisioner:     p.Provisioner,
+		Volume:          volumeConfig,
+	}, nil
+}
@@ -0,0 +1,94 @@
+// Package provider defines the interface between the KfCluster reconciler and the platforms it provisions on.
+// Each platform lives in its own package, which registers a Factory for its KfPlatform from an init function.
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	"github.com/CiscoAI/kf-cluster-api/pkg/kubernetes"
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Provider provisions the clusters of one platform.
+// A Provider is created for every reconcile, so it may keep what it observes between its calls.
+// Methods that wait on the platform never block: they return false and are called again later.
+// Providers record their progress in the typed conditions of the KfCluster status, which the reconciler persists.
+type Provider interface {
+	// EnsureInfrastructure creates the cluster or brings it in line with the spec, and returns true once it is ready
+	EnsureInfrastructure(ctx context.Context, kfCluster *cluster.KfCluster) (bool, error)
+	// GetKubeconfig returns the kubeconfig of the cluster, or nil when the controller has no access to it
+	GetKubeconfig(ctx context.Context, kfCluster *cluster.KfCluster) ([]byte, error)
+	// Upgrade moves the cluster to the versions in the spec and returns true once it runs them
+	Upgrade(ctx context.Context, kfCluster *cluster.KfCluster) (bool, error)
+	// Teardown deletes what the provider created for the cluster and returns true once it is gone
+	Teardown(ctx context.Context, kfCluster *cluster.KfCluster) (bool, error)
+	// Status summarizes the state of the cluster
+	Status(ctx context.Context, kfCluster *cluster.KfCluster) (Status, error)
+}
+
+// Status summarizes the state of a cluster, reported in the InfrastructureReady condition
+type Status struct {
+	Ready   bool
+	Reason  string
+	Message string
+	// RefreshAfter is how soon a ready cluster needs to be reconciled again, such as to renew the credentials
+	// of its kubeconfig; zero when it doesn't
+	RefreshAfter time.Duration
+}
+
+// Options holds what the controller shares with providers
+type Options struct {
+	Client client.Client
+	Scheme *runtime.Scheme
+	Log    logr.Logger
+	// Provisioner holds the controller-wide defaults for provisioner pods
+	Provisioner kubernetes.ProvisionerConfig
+}
+
+// Factory creates the Provider of a platform
+type Factory func(opts Options) Provider
+
+var (
+	registryLock sync.RWMutex
+	registry     = map[cluster.KfPlatform]Factory{}
+)
+
+// Register makes a platform available to the reconciler; registering a platform twice panics
+func Register(platform cluster.KfPlatform, factory Factory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if _, ok := registry[platform]; ok {
+		panic(fmt.Sprintf("provider for platform %q is already registered", platform))
+	}
+	registry[platform] = factory
+}
+
+// New returns the Provider registered for platform
+func New(platform cluster.KfPlatform, opts Options) (Provider, error) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	factory, ok := registry[platform]
+	if !ok {
+		return nil, fmt.Errorf("no provider is registered for platform %q", platform)
+	}
+	return factory(opts), nil
+}
+
+// Platforms returns the registered platforms in alphabetical order
+func Platforms() []cluster.KfPlatform {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	platforms := make([]cluster.KfPlatform, 0, len(registry))
+	for platform := range registry {
+		platforms = append(platforms, platform)
+	}
+	sort.Slice(platforms, func(i, j int) bool { return platforms[i] < platforms[j] })
+	return platforms
+}
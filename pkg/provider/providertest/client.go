@@ -0,0 +1,268 @@
+// Package providertest provides a client for testing providers without a management cluster
+package providertest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// updateManager owns the fields written by creates and updates that don't name a field manager
+const updateManager = "update"
+
+// Client is a fake client that behaves like the API server where providers rely on it.
+// The fake client of controller-runtime can't apply, so Client implements server-side apply itself:
+// it tracks the fields each field manager owns, removes the fields a manager stops applying unless another
+// manager owns them, and applies ServerDefaults. Lists are owned as a whole, like atomic lists.
+// Like the API server, Client bumps the resource version only when a write changes the object.
+type Client struct {
+	client.Client
+	scheme  *runtime.Scheme
+	version int
+	// managed holds the fields each manager owns, by object and manager
+	managed map[string]map[string]fieldSet
+	// Default sets the fields the API server defaults; it defaults to ServerDefaults
+	Default func(obj runtime.Object)
+	// Writes lists the writes that changed an object, as "<create|update> <kind> <namespace>/<name>"
+	Writes []string
+}
+
+// NewClient returns a Client holding objs
+func NewClient(scheme *runtime.Scheme, objs ...runtime.Object) *Client {
+	return &Client{
+		Client:  fake.NewFakeClientWithScheme(scheme, objs...),
+		scheme:  scheme,
+		managed: map[string]map[string]fieldSet{},
+		Default: ServerDefaults,
+	}
+}
+
+// Reset forgets the writes made so far
+func (c *Client) Reset() {
+	c.Writes = nil
+}
+
+// Create creates obj; its fields are owned by the field manager of the request
+func (c *Client) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	createOpts := &client.CreateOptions{}
+	createOpts.ApplyOptions(opts)
+	return c.write(ctx, obj, managerOf(createOpts.FieldManager), "create")
+}
+
+// Update replaces obj; the fields it changes pass to the field manager of the request
+func (c *Client) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	updateOpts := &client.UpdateOptions{}
+	updateOpts.ApplyOptions(opts)
+	return c.write(ctx, obj, managerOf(updateOpts.FieldManager), "update")
+}
+
+// Patch applies apply patches with server-side apply and passes other patches to the fake client
+func (c *Client) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if patch.Type() != types.ApplyPatchType {
+		if err := c.Client.Patch(ctx, obj, patch, opts...); err != nil {
+			return err
+		}
+		return c.record("update", obj)
+	}
+	patchOpts := &client.PatchOptions{}
+	patchOpts.ApplyOptions(opts)
+	if patchOpts.FieldManager == "" {
+		return apierrors.NewBadRequest("apply requires a field manager")
+	}
+	return c.write(ctx, obj, patchOpts.FieldManager, "apply")
+}
+
+// write stores obj for manager. An apply merges the fields of obj into the live object; a create or update replaces it.
+// On return, obj holds the stored object.
+func (c *Client) write(ctx context.Context, obj runtime.Object, manager string, op string) error {
+	gvk, err := apiutil.GVKForObject(obj, c.scheme)
+	if err != nil {
+		return err
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	key := objectKey(gvk, accessor.GetNamespace(), accessor.GetName())
+	request, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return err
+	}
+	live, err := c.scheme.New(gvk)
+	if err != nil {
+		return err
+	}
+	exists := true
+	if err := c.Client.Get(ctx, client.ObjectKey{Namespace: accessor.GetNamespace(), Name: accessor.GetName()}, live); apierrors.IsNotFound(err) {
+		exists = false
+	} else if err != nil {
+		return err
+	}
+	resource := schema.GroupResource{Group: gvk.Group, Resource: gvk.Kind}
+	if exists && op == "create" {
+		return apierrors.NewAlreadyExists(resource, accessor.GetName())
+	}
+	if !exists && op == "update" {
+		return apierrors.NewNotFound(resource, accessor.GetName())
+	}
+	liveFields := map[string]interface{}{}
+	if exists {
+		if liveFields, err = runtime.DefaultUnstructuredConverter.ToUnstructured(live); err != nil {
+			return err
+		}
+	}
+	managers := c.managed[key]
+	if managers == nil {
+		managers = map[string]fieldSet{}
+	}
+	var fields map[string]interface{}
+	if op == "apply" {
+		fields = runtime.DeepCopyJSON(liveFields)
+		owned := leafFields(request, nil)
+		for path := range managers[manager] {
+			if !owned[path] && !ownedByOthers(managers, manager, path) {
+				removeField(fields, splitPath(path))
+			}
+		}
+		mergeFields(fields, request)
+		managers[manager] = owned
+	} else {
+		fields = request
+		changed := changedFields(liveFields, fields)
+		for _, owned := range managers {
+			for path := range changed {
+				delete(owned, path)
+			}
+		}
+		if managers[manager] == nil {
+			managers[manager] = fieldSet{}
+		}
+		for path := range changed {
+			managers[manager][path] = true
+		}
+	}
+	// Fields that are gone are no longer owned
+	for _, owned := range managers {
+		for path := range owned {
+			if _, ok := getField(fields, splitPath(path)); !ok {
+				delete(owned, path)
+			}
+		}
+	}
+	stored, err := c.scheme.New(gvk)
+	if err != nil {
+		return err
+	}
+	// The type is kept by the scheme, not in the stored fields, and the status is written through its own requests
+	for _, typeField := range []string{"apiVersion", "kind"} {
+		delete(fields, typeField)
+		delete(liveFields, typeField)
+	}
+	delete(fields, "status")
+	if status, ok := liveFields["status"]; ok {
+		fields["status"] = status
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(fields, stored); err != nil {
+		return err
+	}
+	if c.Default != nil {
+		c.Default(stored)
+	}
+	if storedFields, err := runtime.DefaultUnstructuredConverter.ToUnstructured(stored); err != nil {
+		return err
+	} else if exists && reflect.DeepEqual(storedFields, liveFields) {
+		c.managed[key] = managers
+		return copyInto(live, obj)
+	}
+	c.managed[key] = managers
+	storedAccessor, err := meta.Accessor(stored)
+	if err != nil {
+		return err
+	}
+	c.version++
+	storedAccessor.SetResourceVersion(strconv.Itoa(c.version))
+	if exists {
+		err = c.Client.Update(ctx, stored)
+	} else {
+		err = c.Client.Create(ctx, stored)
+	}
+	if err != nil {
+		return err
+	}
+	verb := "update"
+	if !exists {
+		verb = "create"
+	}
+	c.Writes = append(c.Writes, fmt.Sprintf("%s %s %s/%s", verb, gvk.Kind, accessor.GetNamespace(), accessor.GetName()))
+	return copyInto(stored, obj)
+}
+
+// record bumps the resource version of obj after a write the fake client made and appends it to Writes
+func (c *Client) record(verb string, obj runtime.Object) error {
+	gvk, err := apiutil.GVKForObject(obj, c.scheme)
+	if err != nil {
+		return err
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	c.version++
+	accessor.SetResourceVersion(strconv.Itoa(c.version))
+	c.Writes = append(c.Writes, fmt.Sprintf("%s %s %s/%s", verb, gvk.Kind, accessor.GetNamespace(), accessor.GetName()))
+	return c.Client.Update(context.Background(), obj)
+}
+
+// ManagedFields returns the paths of the fields manager owns on an object, with path segments joined by dots
+func (c *Client) ManagedFields(obj runtime.Object, manager string) ([]string, error) {
+	gvk, err := apiutil.GVKForObject(obj, c.scheme)
+	if err != nil {
+		return nil, err
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for path := range c.managed[objectKey(gvk, accessor.GetNamespace(), accessor.GetName())][manager] {
+		paths = append(paths, strings.Join(splitPath(path), "."))
+	}
+	return paths, nil
+}
+
+func managerOf(fieldManager string) string {
+	if fieldManager == "" {
+		return updateManager
+	}
+	return fieldManager
+}
+
+func objectKey(gvk schema.GroupVersionKind, namespace string, name string) string {
+	return gvk.String() + " " + namespace + "/" + name
+}
+
+// copyInto copies from into to, either of which may be unstructured
+func copyInto(from runtime.Object, to runtime.Object) error {
+	fields, err := runtime.DefaultUnstructuredConverter.ToUnstructured(from)
+	if err != nil {
+		return err
+	}
+	if u, ok := to.(runtime.Unstructured); ok {
+		gvk := u.GetObjectKind().GroupVersionKind()
+		u.SetUnstructuredContent(fields)
+		u.GetObjectKind().SetGroupVersionKind(gvk)
+		return nil
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(fields, to)
+}
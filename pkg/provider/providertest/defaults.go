@@ -0,0 +1,115 @@
+package providertest
+
+import (
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// ServerDefaults sets the fields the API server defaults on the objects providers create,
+// so that tests see the live objects a management cluster would return
+func ServerDefaults(obj runtime.Object) {
+	switch obj := obj.(type) {
+	case *appsv1.Deployment:
+		spec := &obj.Spec
+		if spec.Replicas == nil {
+			spec.Replicas = int32Ptr(1)
+		}
+		if spec.Strategy.Type == "" {
+			spec.Strategy.Type = appsv1.RollingUpdateDeploymentStrategyType
+		}
+		if spec.Strategy.Type == appsv1.RollingUpdateDeploymentStrategyType && spec.Strategy.RollingUpdate == nil {
+			quarter := intstr.FromString("25%")
+			spec.Strategy.RollingUpdate = &appsv1.RollingUpdateDeployment{MaxUnavailable: &quarter, MaxSurge: &quarter}
+		}
+		if spec.RevisionHistoryLimit == nil {
+			spec.RevisionHistoryLimit = int32Ptr(10)
+		}
+		if spec.ProgressDeadlineSeconds == nil {
+			spec.ProgressDeadlineSeconds = int32Ptr(600)
+		}
+		defaultPodSpec(&obj.Spec.Template.Spec)
+	case *batchv1.Job:
+		if obj.Spec.Completions == nil {
+			obj.Spec.Completions = int32Ptr(1)
+		}
+		if obj.Spec.Parallelism == nil {
+			obj.Spec.Parallelism = int32Ptr(1)
+		}
+		if obj.Spec.BackoffLimit == nil {
+			obj.Spec.BackoffLimit = int32Ptr(6)
+		}
+		defaultPodSpec(&obj.Spec.Template.Spec)
+	case *corev1.PersistentVolumeClaim:
+		if obj.Spec.VolumeMode == nil {
+			filesystem := corev1.PersistentVolumeFilesystem
+			obj.Spec.VolumeMode = &filesystem
+		}
+	}
+}
+
+func defaultPodSpec(spec *corev1.PodSpec) {
+	if spec.RestartPolicy == "" {
+		spec.RestartPolicy = corev1.RestartPolicyAlways
+	}
+	if spec.DNSPolicy == "" {
+		spec.DNSPolicy = corev1.DNSClusterFirst
+	}
+	if spec.SchedulerName == "" {
+		spec.SchedulerName = corev1.DefaultSchedulerName
+	}
+	if spec.TerminationGracePeriodSeconds == nil {
+		period := int64(corev1.DefaultTerminationGracePeriodSeconds)
+		spec.TerminationGracePeriodSeconds = &period
+	}
+	if spec.SecurityContext == nil {
+		spec.SecurityContext = &corev1.PodSecurityContext{}
+	}
+	for i := range spec.InitContainers {
+		defaultContainer(&spec.InitContainers[i])
+	}
+	for i := range spec.Containers {
+		defaultContainer(&spec.Containers[i])
+	}
+	for i := range spec.Volumes {
+		source := &spec.Volumes[i].VolumeSource
+		mode := int32(corev1.SecretVolumeSourceDefaultMode)
+		if source.Secret != nil && source.Secret.DefaultMode == nil {
+			source.Secret.DefaultMode = &mode
+		}
+		if source.ConfigMap != nil && source.ConfigMap.DefaultMode == nil {
+			source.ConfigMap.DefaultMode = &mode
+		}
+		if source.Projected != nil && source.Projected.DefaultMode == nil {
+			source.Projected.DefaultMode = &mode
+		}
+	}
+}
+
+func defaultContainer(container *corev1.Container) {
+	if container.TerminationMessagePath == "" {
+		container.TerminationMessagePath = corev1.TerminationMessagePathDefault
+	}
+	if container.TerminationMessagePolicy == "" {
+		container.TerminationMessagePolicy = corev1.TerminationMessageReadFile
+	}
+	if container.ImagePullPolicy == "" {
+		container.ImagePullPolicy = corev1.PullIfNotPresent
+		if image := container.Image[strings.LastIndex(container.Image, "/")+1:]; !strings.Contains(image, ":") || strings.HasSuffix(image, ":latest") {
+			container.ImagePullPolicy = corev1.PullAlways
+		}
+	}
+	for i := range container.Ports {
+		if container.Ports[i].Protocol == "" {
+			container.Ports[i].Protocol = corev1.ProtocolTCP
+		}
+	}
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
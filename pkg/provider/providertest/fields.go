@@ -0,0 +1,103 @@
+package providertest
+
+import (
+	"reflect"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// fieldSet holds the paths of the fields a manager owns; path segments are separated by pathSeparator
+type fieldSet map[string]bool
+
+// pathSeparator separates path segments, which may themselves hold dots, like annotation keys
+const pathSeparator = "\x00"
+
+func joinPath(path []string) string {
+	return strings.Join(path, pathSeparator)
+}
+
+func splitPath(path string) []string {
+	return strings.Split(path, pathSeparator)
+}
+
+// leafFields returns the paths of the scalars, lists and empty maps in fields
+func leafFields(fields map[string]interface{}, prefix []string) fieldSet {
+	leaves := fieldSet{}
+	for key, value := range fields {
+		path := append(append([]string{}, prefix...), key)
+		if m, ok := value.(map[string]interface{}); ok && len(m) > 0 {
+			for leaf := range leafFields(m, path) {
+				leaves[leaf] = true
+			}
+			continue
+		}
+		leaves[joinPath(path)] = true
+	}
+	return leaves
+}
+
+// changedFields returns the leaf paths of next whose value differs in live
+func changedFields(live map[string]interface{}, next map[string]interface{}) fieldSet {
+	changed := fieldSet{}
+	for path := range leafFields(next, nil) {
+		liveValue, liveOK := getField(live, splitPath(path))
+		nextValue, _ := getField(next, splitPath(path))
+		if !liveOK || !reflect.DeepEqual(liveValue, nextValue) {
+			changed[path] = true
+		}
+	}
+	return changed
+}
+
+func ownedByOthers(managers map[string]fieldSet, manager string, path string) bool {
+	for other, owned := range managers {
+		if other != manager && owned[path] {
+			return true
+		}
+	}
+	return false
+}
+
+func getField(fields map[string]interface{}, path []string) (interface{}, bool) {
+	var value interface{} = fields
+	for _, key := range path {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// removeField deletes the field at path along with the maps it leaves empty
+func removeField(fields map[string]interface{}, path []string) {
+	if len(path) == 1 {
+		delete(fields, path[0])
+		return
+	}
+	child, ok := fields[path[0]].(map[string]interface{})
+	if !ok {
+		return
+	}
+	removeField(child, path[1:])
+	if len(child) == 0 {
+		delete(fields, path[0])
+	}
+}
+
+// mergeFields sets the fields of src on dst, merging maps and replacing scalars and lists
+func mergeFields(dst map[string]interface{}, src map[string]interface{}) {
+	for key, value := range src {
+		srcMap, srcIsMap := value.(map[string]interface{})
+		dstMap, dstIsMap := dst[key].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			mergeFields(dstMap, srcMap)
+			continue
+		}
+		dst[key] = runtime.DeepCopyJSONValue(value)
+	}
+}
@@ -0,0 +1,177 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	cluster "github.com/CiscoAI/kf-cluster-api/api/v1alpha1"
+	"github.com/CiscoAI/kf-cluster-api/pkg/provision"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Stages provisions a cluster in resumable stages
+type Stages interface {
+	Stages() []provision.Stage
+	TeardownStages() []provision.Stage
+	// Kubeconfig returns the kubeconfig of the cluster once the stages have completed
+	Kubeconfig() []byte
+}
+
+// KubeconfigRenewer is implemented by Stages whose kubeconfig holds credentials that expire
+type KubeconfigRenewer interface {
+	// KubeconfigRenewal returns when the kubeconfig needs to be generated again, or zero when it doesn't expire
+	KubeconfigRenewal() time.Time
+}
+
+// minKubeconfigRenewal bounds how often a kubeconfig is renewed
+const minKubeconfigRenewal = time.Minute
+
+// Upgrader is implemented by Stages that move a running cluster to the versions of its spec.
+// Its stages run after the provisioning stages while a Kubernetes upgrade is in progress.
+type Upgrader interface {
+	UpgradeStages() []provision.Stage
+}
+
+// StagedProvider implements Provider for platforms the controller provisions itself in stages.
+// Every stage is reported in its own condition; see cluster.StageCondition.
+type StagedProvider struct {
+	Options
+	// NewStages builds the stages of a KfCluster from its spec
+	NewStages func(ctx context.Context, kfCluster *cluster.KfCluster) (Stages, error)
+	// IsTerminal reports the errors of the platform API that retrying can't fix; optional
+	IsTerminal func(err error) bool
+
+	stages Stages
+}
+
+var _ Provider = &StagedProvider{}
+
+// classify marks the errors IsTerminal reports as terminal
+func (p *StagedProvider) classify(err error) error {
+	if err != nil && p.IsTerminal != nil && p.IsTerminal(err) {
+		return Terminal(err)
+	}
+	return err
+}
+
+func (p *StagedProvider) getStages(ctx context.Context, kfCluster *cluster.KfCluster) (Stages, error) {
+	if p.stages == nil {
+		stages, err := p.NewStages(ctx, kfCluster)
+		if err != nil {
+			return nil, p.classify(err)
+		}
+		p.stages = stages
+	}
+	return p.stages, nil
+}
+
+// EnsureInfrastructure runs the stages in order and stops at the first one in progress
+func (p *StagedProvider) EnsureInfrastructure(ctx context.Context, kfCluster *cluster.KfCluster) (bool, error) {
+	stages, err := p.getStages(ctx, kfCluster)
+	if err != nil {
+		return false, err
+	}
+	return p.runStages(ctx, kfCluster, stages.Stages(), "provisioned")
+}
+
+// runStages runs stages in order, reporting each in its condition, and stops at the first one in progress
+func (p *StagedProvider) runStages(ctx context.Context, kfCluster *cluster.KfCluster, stages []provision.Stage, action string) (bool, error) {
+	log := p.Log.WithValues("kfcluster", kfCluster.Namespace+"/"+kfCluster.Name)
+	for _, stage := range stages {
+		done, err := stage.Run(ctx)
+		conditionType := cluster.StageCondition(stage.Name)
+		if err != nil {
+			log.Error(err, "error running provisioning stage", "stage", stage.Name)
+			kfCluster.Status.SetCondition(conditionType, corev1.ConditionFalse, "StageFailed", err.Error())
+			return false, p.classify(err)
+		}
+		if !done {
+			log.Info("provisioning stage in progress", "stage", stage.Name)
+			kfCluster.Status.SetCondition(conditionType, corev1.ConditionFalse, "InProgress", stage.Name+" is being "+action)
+			return false, nil
+		}
+		kfCluster.Status.SetCondition(conditionType, corev1.ConditionTrue, "StageComplete", stage.Name+" is "+action)
+	}
+	return true, nil
+}
+
+// GetKubeconfig returns the kubeconfig observed by the stages
+func (p *StagedProvider) GetKubeconfig(ctx context.Context, kfCluster *cluster.KfCluster) ([]byte, error) {
+	stages, err := p.getStages(ctx, kfCluster)
+	if err != nil {
+		return nil, err
+	}
+	kubeconfig := stages.Kubeconfig()
+	if len(kubeconfig) == 0 {
+		return nil, fmt.Errorf("the provisioner returned no kubeconfig")
+	}
+	return kubeconfig, nil
+}
+
+// Upgrade runs the upgrade stages of Stages implementing Upgrader while a Kubernetes upgrade is in progress.
+// Other stages converge versions as part of EnsureInfrastructure.
+func (p *StagedProvider) Upgrade(ctx context.Context, kfCluster *cluster.KfCluster) (bool, error) {
+	upgrade := kfCluster.Status.KubernetesUpgrade
+	if upgrade == nil || upgrade.Phase != cluster.KubernetesUpgradeInProgress {
+		return true, nil
+	}
+	stages, err := p.getStages(ctx, kfCluster)
+	if err != nil {
+		return false, err
+	}
+	upgrader, ok := stages.(Upgrader)
+	if !ok {
+		return true, nil
+	}
+	return p.runStages(ctx, kfCluster, upgrader.UpgradeStages(), "upgraded")
+}
+
+// Teardown runs the teardown stages in order and stops at the first one in progress
+func (p *StagedProvider) Teardown(ctx context.Context, kfCluster *cluster.KfCluster) (bool, error) {
+	log := p.Log.WithValues("kfcluster", kfCluster.Namespace+"/"+kfCluster.Name)
+	stages, err := p.getStages(ctx, kfCluster)
+	if err != nil {
+		return false, err
+	}
+	for _, stage := range stages.TeardownStages() {
+		done, err := stage.Run(ctx)
+		if err != nil {
+			log.Error(err, "error running teardown stage", "stage", stage.Name)
+			return false, p.classify(err)
+		}
+		if !done {
+			log.Info("teardown stage in progress", "stage", stage.Name)
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Status reports the first stage that hasn't completed. A ready cluster is refreshed when
+// its kubeconfig needs renewing.
+func (p *StagedProvider) Status(ctx context.Context, kfCluster *cluster.KfCluster) (Status, error) {
+	stages, err := p.getStages(ctx, kfCluster)
+	if err != nil {
+		return Status{}, err
+	}
+	for _, stage := range stages.Stages() {
+		condition := kfCluster.Status.GetCondition(cluster.StageCondition(stage.Name))
+		if condition == nil {
+			return Status{Reason: "Pending", Message: stage.Name + " has not started"}, nil
+		}
+		if condition.Status != corev1.ConditionTrue {
+			return Status{Reason: condition.Reason, Message: condition.Message}, nil
+		}
+	}
+	status := Status{Ready: true, Reason: "Provisioned", Message: "all stages are complete"}
+	if renewer, ok := stages.(KubeconfigRenewer); ok {
+		if renewal := renewer.KubeconfigRenewal(); !renewal.IsZero() {
+			status.RefreshAfter = time.Until(renewal)
+			if status.RefreshAfter < minKubeconfigRenewal {
+				status.RefreshAfter = minKubeconfigRenewal
+			}
+		}
+	}
+	return status, nil
+}
@@ -0,0 +1,24 @@
+package provision
+
+// Defaults of the clusters bootstrapped with kubeadm
+const (
+	DefaultKubernetesVersion = "1.15.3"
+	PodSubnet                = "192.168.0.0/16"
+	CNIManifest              = "https://docs.projectcalico.org/v3.10/manifests/calico.yaml"
+)
+
+// InstallScript installs a container runtime and the kubeadm tool set on Ubuntu.
+// It is a text/template fragment expecting a KubernetesVersion value.
+const InstallScript = `
+export DEBIAN_FRONTEND=noninteractive
+apt-get update
+apt-get install -y apt-transport-https ca-certificates curl gnupg docker.io
+systemctl enable --now docker
+curl -s https://packages.cloud.google.com/apt/doc/apt-key.gpg | apt-key add -
+echo "deb https://apt.kubernetes.io/ kubernetes-xenial main" > /etc/apt/sources.list.d/kubernetes.list
+apt-get update
+apt-get install -y kubelet={{.KubernetesVersion}}-00 kubeadm={{.KubernetesVersion}}-00 kubectl={{.KubernetesVersion}}-00
+apt-mark hold kubelet kubeadm kubectl
+modprobe br_netfilter
+sysctl -w net.bridge.bridge-nf-call-iptables=1
+`
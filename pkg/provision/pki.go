@@ -0,0 +1,171 @@
+package provision
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// Keys of the PKI data persisted by the controller between reconciles
+const (
+	PKICACertKey         = "ca.crt"
+	PKICAKeyKey          = "ca.key"
+	PKIBootstrapTokenKey = "token"
+)
+
+const tokenAlphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+// ClusterPKI holds the cluster CA and kubeadm bootstrap token generated ahead of provisioning,
+// so that the controller can mint the admin kubeconfig and workers can join with a pinned CA
+type ClusterPKI struct {
+	CACert         []byte
+	CAKey          []byte
+	BootstrapToken string
+}
+
+// NewClusterPKI generates a self-signed cluster CA and a bootstrap token
+func NewClusterPKI(clusterName string) (*ClusterPKI, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("Error generating CA key: %v", err)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(now.UnixNano()),
+		Subject:               pkix.Name{CommonName: "kubernetes", Organization: []string{clusterName}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating CA certificate: %v", err)
+	}
+	token, err := newBootstrapToken()
+	if err != nil {
+		return nil, err
+	}
+	return &ClusterPKI{
+		CACert:         pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		CAKey:          pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+		BootstrapToken: token,
+	}, nil
+}
+
+// ClusterPKIFromData restores a ClusterPKI persisted with Data
+func ClusterPKIFromData(data map[string][]byte) (*ClusterPKI, error) {
+	pki := &ClusterPKI{
+		CACert:         data[PKICACertKey],
+		CAKey:          data[PKICAKeyKey],
+		BootstrapToken: string(data[PKIBootstrapTokenKey]),
+	}
+	if len(pki.CACert) == 0 || len(pki.CAKey) == 0 || pki.BootstrapToken == "" {
+		return nil, fmt.Errorf("cluster PKI data is incomplete")
+	}
+	return pki, nil
+}
+
+// Data returns the PKI in the form persisted by the controller
+func (p *ClusterPKI) Data() map[string][]byte {
+	return map[string][]byte{
+		PKICACertKey:         p.CACert,
+		PKICAKeyKey:          p.CAKey,
+		PKIBootstrapTokenKey: []byte(p.BootstrapToken),
+	}
+}
+
+// CACertHash returns the public key pin kubeadm join uses to discover the control plane
+func (p *ClusterPKI) CACertHash() (string, error) {
+	cert, _, err := p.parseCA()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// AdminKubeconfig mints a cluster-admin client certificate and returns a kubeconfig for server
+func (p *ClusterPKI) AdminKubeconfig(clusterName string, server string) ([]byte, error) {
+	caCert, caKey, err := p.parseCA()
+	if err != nil {
+		return nil, err
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("Error generating admin key: %v", err)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(now.UnixNano()),
+		Subject:      pkix.Name{CommonName: "kubernetes-admin", Organization: []string{"system:masters"}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating admin certificate: %v", err)
+	}
+	user := "kubernetes-admin@" + clusterName
+	config := clientcmdapi.NewConfig()
+	config.Clusters[clusterName] = &clientcmdapi.Cluster{
+		Server:                   server,
+		CertificateAuthorityData: p.CACert,
+	}
+	config.AuthInfos[user] = &clientcmdapi.AuthInfo{
+		ClientCertificateData: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		ClientKeyData:         pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+	}
+	config.Contexts[user] = &clientcmdapi.Context{Cluster: clusterName, AuthInfo: user}
+	config.CurrentContext = user
+	return clientcmd.Write(*config)
+}
+
+func (p *ClusterPKI) parseCA() (*x509.Certificate, *rsa.PrivateKey, error) {
+	certBlock, _ := pem.Decode(p.CACert)
+	if certBlock == nil {
+		return nil, nil, fmt.Errorf("cluster CA certificate is not PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error parsing cluster CA certificate: %v", err)
+	}
+	keyBlock, _ := pem.Decode(p.CAKey)
+	if keyBlock == nil {
+		return nil, nil, fmt.Errorf("cluster CA key is not PEM encoded")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error parsing cluster CA key: %v", err)
+	}
+	return cert, key, nil
+}
+
+// newBootstrapToken returns a random token in the kubeadm format [a-z0-9]{6}.[a-z0-9]{16}
+func newBootstrapToken() (string, error) {
+	buf := make([]byte, 22)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("Error generating bootstrap token: %v", err)
+	}
+	token := make([]byte, 0, 23)
+	for i, b := range buf {
+		if i == 6 {
+			token = append(token, '.')
+		}
+		token = append(token, tokenAlphabet[int(b)%len(tokenAlphabet)])
+	}
+	return string(token), nil
+}
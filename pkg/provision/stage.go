@@ -0,0 +1,12 @@
+// Package provision holds the building blocks shared by the platforms the controller provisions itself
+package provision
+
+import "context"
+
+// Stage is a resumable, idempotent provisioning step.
+// Run starts the step or checks on it without blocking, and returns true once it is complete.
+// Stages are run in order on every reconcile; completed ones return quickly.
+type Stage struct {
+	Name string
+	Run  func(ctx context.Context) (bool, error)
+}